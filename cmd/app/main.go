@@ -1,35 +1,108 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/olegshirko/reposqueeze/internal/app/controller"
 	"github.com/olegshirko/reposqueeze/internal/app/usecase"
+	"github.com/olegshirko/reposqueeze/internal/domain/gateway"
+	"github.com/olegshirko/reposqueeze/internal/infrastructure/archive"
 	"github.com/olegshirko/reposqueeze/internal/infrastructure/git"
 	"github.com/olegshirko/reposqueeze/internal/infrastructure/gitlab"
 	"github.com/olegshirko/reposqueeze/internal/pkg/logger"
+	"github.com/olegshirko/reposqueeze/pkg/config"
 )
 
 func main() {
 	// 0. Create logger
 	log := logger.NewLogger()
 
-	// 1. Create instances of the gateway implementations (Frameworks & Drivers)
-	gitGateway := git.NewOSExecGitGateway(log)
-	gitlabToken := os.Getenv("GITLAB_TOKEN")
-	if gitlabToken == "" {
-		panic("GITLAB_TOKEN environment variable not set")
+	// 1. Load configuration: defaults < config files < environment < global flags
+	globalFlags := flag.NewFlagSet("reposqueeze", flag.ExitOnError)
+	profile := globalFlags.String("profile", "", "Config profile to use (env REPOSQUEEZE_PROFILE)")
+	globalFlags.String("gitlab-url", "", "Base URL of the GitLab instance (env GITLAB_BASE_URL)")
+	globalFlags.String("gitlab-api-version", "", "GitLab REST API version (env GITLAB_API_VERSION)")
+	globalFlags.String("deletion-timeout", "", "How long to wait for GitLab to delete a project, 0 to not wait (env GITLAB_DELETION_TIMEOUT)")
+	globalFlags.Bool("permanently-remove", false, "Permanently remove projects marked for delayed deletion (env GITLAB_PERMANENTLY_REMOVE)")
+	globalFlags.String("http-timeout", "", "How long one GitLab request waits for a response, 0 for no limit (env GITLAB_HTTP_TIMEOUT)")
+	globalFlags.String("http-retries", "", "How many times a failed GitLab request is retried (env GITLAB_HTTP_RETRIES)")
+	globalFlags.String("git-backend", "", "Git implementation: exec (git executable) or native (env REPOSQUEEZE_GIT_BACKEND)")
+	// Parsing stops at the first non-flag argument, which is the command name.
+	globalFlags.Parse(os.Args[1:])
+
+	// A wrong configuration is reported like a wrong command line.
+	cfg, err := config.Load(*profile)
+	if err != nil {
+		log.Errorf("Invalid configuration: %v", err)
+		os.Exit(controller.ExitUsage)
+	}
+	// Only the flags given on the command line override the loaded settings.
+	globalFlags.Visit(func(f *flag.Flag) {
+		if f.Name == "profile" {
+			return
+		}
+		if err := cfg.Set(f.Name, f.Value.String()); err != nil {
+			log.Errorf("Invalid value for --%s: %v", f.Name, err)
+			os.Exit(controller.ExitUsage)
+		}
+	})
+	if err := cfg.Validate(); err != nil {
+		log.Errorf("Invalid configuration: %v", err)
+		os.Exit(controller.ExitUsage)
+	}
+	// 2. Create instances of the gateway implementations (Frameworks & Drivers).
+	// The token is read and checked by the commands that work with GitLab.
+	var gitGateway gateway.GitGateway
+	var setGitToken func(token string)
+	if cfg.GitBackend == config.GitBackendNative {
+		nativeGateway := git.NewNativeGitGateway(log)
+		setGitToken = func(token string) { nativeGateway.HTTPToken = token }
+		gitGateway = nativeGateway
+	} else {
+		execGateway := git.NewOSExecGitGateway(log)
+		setGitToken = func(token string) { execGateway.HTTPToken = token }
+		gitGateway = execGateway
 	}
-	gitlabGateway := gitlab.NewHTTPGitLabGateway(gitlabToken, log)
+	gitlabGateway := gitlab.NewHTTPGitLabGateway(cfg.GitLabURL, cfg.GitLabAPIVersion, "", log)
+	gitlabGateway.DeletionTimeout = cfg.DeletionTimeout
+	gitlabGateway.PermanentlyRemove = cfg.PermanentlyRemove
+	gitlabGateway.Transport.Timeout = cfg.HTTPTimeout
+	gitlabGateway.Transport.MaxAttempts = cfg.HTTPRetries + 1
+	archiveExtractors := archive.NewExtractors(log)
 
-	// 2. Create an instance of the use case, injecting the gateways (Use Cases)
+	// Ctrl-C cancels the command, which then undoes what it has started.
+	// Another Ctrl-C during the cleanup quits at once.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		signal.Stop(signals)
+		log.Warn("Interrupted, cleaning up; interrupt again to quit at once")
+		cancel()
+	}()
+
+	// 3. Create an instance of the use case, injecting the gateways (Use Cases)
 	createBranchUseCase := usecase.NewCreateAndPushOrphanBranchUseCase(gitGateway, gitlabGateway, log)
-	createOrphanBranchFromGitlabUseCase := usecase.NewCreateOrphanBranchFromGitlabUseCase(gitGateway, gitlabGateway, log)
+	createOrphanBranchFromGitlabUseCase := usecase.NewCreateOrphanBranchFromGitlabUseCase(gitGateway, gitlabGateway, archiveExtractors, log)
+	squashUseCase := usecase.NewSquashUseCase(gitGateway, gitlabGateway, log)
 
-	// 3. Create an instance of the controller, injecting the use case (Interface Adapters)
-	cliController := controller.NewCLIController(createBranchUseCase, createOrphanBranchFromGitlabUseCase, gitlabGateway, log)
+	// 4. Create an instance of the controller, injecting the use case (Interface Adapters)
+	cliController := controller.NewCLIController(createBranchUseCase, createOrphanBranchFromGitlabUseCase, squashUseCase, gitGateway, gitlabGateway, archiveExtractors, cfg, log)
+	cliController.SetToken = func(token string) {
+		gitlabGateway.Token = token
+		setGitToken(token)
+	}
 
-	// 4. Run the controller with command-line arguments
-	// os.Args[1:] excludes the program name
-	cliController.Run(os.Args[1:])
+	// 5. Run the controller with the command and its arguments
+	if err := cliController.Run(ctx, globalFlags.Args()); err != nil {
+		log.Errorf("Error: %v", err)
+		cancel()
+		os.Exit(controller.ExitCode(err))
+	}
 }
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/olegshirko/reposqueeze/internal/app/controller"
+)
+
+// TestMain runs main instead of the tests when the test binary is started by
+// runMain, so the exit code of the process can be checked.
+func TestMain(m *testing.M) {
+	if args, ok := os.LookupEnv("REPOSQUEEZE_TEST_MAIN_ARGS"); ok {
+		os.Args = append([]string{"reposqueeze"}, strings.Fields(args)...)
+		main()
+		os.Exit(controller.ExitOK)
+	}
+	os.Exit(m.Run())
+}
+
+// runMain runs the command with args, a user config file holding userConfig
+// and the given environment, and returns its exit code.
+func runMain(t *testing.T, userConfig string, env []string, args ...string) int {
+	t.Helper()
+	configHome := t.TempDir()
+	if userConfig != "" {
+		dir := filepath.Join(configHome, "reposqueeze")
+		if err := os.Mkdir(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "config.yml"), []byte(userConfig), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cmd := exec.Command(os.Args[0])
+	cmd.Dir = t.TempDir()
+	cmd.Env = append([]string{
+		"REPOSQUEEZE_TEST_MAIN_ARGS=" + strings.Join(args, " "),
+		"XDG_CONFIG_HOME=" + configHome,
+		"HOME=" + t.TempDir(),
+		"PATH=" + os.Getenv("PATH"),
+	}, env...)
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	if err != nil {
+		t.Fatalf("run %v: %v", args, err)
+	}
+	return controller.ExitOK
+}
+
+// A wrong configuration exits like a wrong command line, whether it is found
+// while loading the settings or while validating them.
+func TestInvalidConfigurationExitCode(t *testing.T) {
+	tests := []struct {
+		name       string
+		userConfig string
+		env        []string
+		args       []string
+		want       int
+	}{
+		{name: "valid", args: []string{"config", "show"}, want: controller.ExitOK},
+		{name: "malformed config file", userConfig: "gitlab_url: [\n", args: []string{"config", "show"}, want: controller.ExitUsage},
+		{name: "unknown profile", args: []string{"--profile", "missing", "config", "show"}, want: controller.ExitUsage},
+		{name: "invalid global flag", args: []string{"--http-retries", "many", "config", "show"}, want: controller.ExitUsage},
+		{name: "invalid url", env: []string{"GITLAB_BASE_URL=ftp://gitlab.example.com"}, args: []string{"config", "show"}, want: controller.ExitUsage},
+		{name: "invalid api version", userConfig: "gitlab_api_version: v4/projects\n", args: []string{"config", "show"}, want: controller.ExitUsage},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := runMain(t, tt.userConfig, tt.env, tt.args...); got != tt.want {
+				t.Errorf("exit code %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
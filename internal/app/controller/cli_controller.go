@@ -2,41 +2,69 @@ package controller
 
 import (
 	"context"
+	"errors"
 	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
 
 	"github.com/olegshirko/reposqueeze/internal/app/usecase"
+	"github.com/olegshirko/reposqueeze/internal/domain/entity"
 	"github.com/olegshirko/reposqueeze/internal/domain/gateway" // Добавлено
+	"github.com/olegshirko/reposqueeze/internal/infrastructure/dryrun"
 	"github.com/olegshirko/reposqueeze/internal/pkg/logger"
+	"github.com/olegshirko/reposqueeze/pkg/config"
 )
 
 // CLIController handles the command-line interface logic.
 type CLIController struct {
 	createFromLocalUseCase  *usecase.CreateAndPushOrphanBranchUseCase
 	createFromGitlabUseCase *usecase.CreateOrphanBranchFromGitlabUseCase
+	squashUseCase           *usecase.SquashUseCase
+	gitGateway              gateway.GitGateway
 	gitlabGateway           gateway.GitLabGateway // Изменено
+	archiveExtractors       gateway.ArchiveExtractors
+	config                  *config.Config
+	out                     io.Writer // Command output that is not a log message
 	logger                  logger.Logger
+
+	// SetToken passes the token resolved by connect to the gateways.
+	SetToken func(token string)
 }
 
 // NewCLIController creates a new instance of CLIController.
 func NewCLIController(
 	createFromLocalUseCase *usecase.CreateAndPushOrphanBranchUseCase,
 	createFromGitlabUseCase *usecase.CreateOrphanBranchFromGitlabUseCase,
+	squashUseCase *usecase.SquashUseCase,
+	gitGateway gateway.GitGateway,
 	gitlabGateway gateway.GitLabGateway, // Изменено
+	archiveExtractors gateway.ArchiveExtractors,
+	cfg *config.Config,
 	log logger.Logger,
 ) *CLIController {
 	return &CLIController{
 		createFromLocalUseCase:  createFromLocalUseCase,
 		createFromGitlabUseCase: createFromGitlabUseCase,
+		squashUseCase:           squashUseCase,
+		gitGateway:              gitGateway,
 		gitlabGateway:           gitlabGateway, // Добавлено
+		archiveExtractors:       archiveExtractors,
+		config:                  cfg,
+		out:                     os.Stdout,
 		logger:                  log,
 	}
 }
 
-// Run executes the controller logic.
-func (c *CLIController) Run(args []string) {
+// Run executes the controller logic and returns the error the command has
+// failed with; ExitCode maps it to the exit code of the process. Cancelling
+// ctx stops the command, which then undoes the changes it has started.
+func (c *CLIController) Run(ctx context.Context, args []string) error {
 	if len(args) < 1 {
 		c.printUsage()
-		return
+		return &usageError{errors.New("no command given")}
 	}
 
 	command := args[0]
@@ -44,76 +72,453 @@ func (c *CLIController) Run(args []string) {
 
 	switch command {
 	case "create-from-local":
-		c.handleCreateFromLocal(remainingArgs)
+		return c.handleCreateFromLocal(ctx, remainingArgs)
 	case "create-from-gitlab":
-		c.handleCreateFromGitlab(remainingArgs)
+		return c.handleCreateFromGitlab(ctx, remainingArgs)
+	case "squash":
+		return c.handleSquash(ctx, remainingArgs)
+	case "config":
+		return c.handleConfig(remainingArgs)
 	default:
-		c.logger.Errorf("Unknown command: %s", command)
 		c.printUsage()
+		return &usageError{fmt.Errorf("unknown command: %s", command)}
 	}
 }
 
-func (c *CLIController) handleCreateFromLocal(args []string) {
-	fs := flag.NewFlagSet("create-from-local", flag.ExitOnError)
+func (c *CLIController) handleCreateFromLocal(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("create-from-local", flag.ContinueOnError)
 	repoPath := fs.String("repo-path", "", "Path to the repository")
 	branchName := fs.String("branch-name", "", "Name of the new orphan branch")
 	sourceBranch := fs.String("from", "master", "Source branch to create orphan from")
+	projectRef := projectFlags(fs)
+	replace := fs.String("replace", string(usecase.ReplaceModeBackup), "What to do with an existing project: backup, keep-backup or delete")
+	namespace := fs.String("namespace", c.config.Namespace, "Full path of the group to create the project in, e.g. group/subgroup")
+	projectPath := fs.String("project-path", "", "URL path (slug) of the new project")
+	visibility := fs.String("visibility", "", "Visibility of the new project: private, internal or public")
+	description := fs.String("description", "", "Description of the new project")
+	defaultBranch := fs.String("default-branch", "", "Default branch of the new project")
+	defaultTransport := usecase.TransportAPI
+	if c.config.Transport != "" {
+		defaultTransport = usecase.Transport(c.config.Transport)
+	}
+	transport := fs.String("transport", string(defaultTransport), "How to upload the branch: api (Commits API) or push (git push)")
+	pushProtocol := fs.String("push-protocol", string(usecase.PushProtocolHTTPS), "Protocol for --transport=push: https or ssh")
+	batchMaxBytes := fs.Int64("batch-max-bytes", usecase.DefaultBatchMaxBytes, "Maximum payload of one commit with --transport=api")
+	batchMaxFiles := fs.Int("batch-max-files", usecase.DefaultBatchMaxFiles, "Maximum number of files in one commit with --transport=api")
+	resume := fs.Bool("resume", false, "Upload the missing parts into the project left by a run that failed part way")
+	backupProjectID := fs.Int("backup-project-id", 0, "With --resume, the backup project to finish the replacement of")
+	dryRun := fs.Bool("dry-run", false, "Print the plan without changing the repository or GitLab")
+	var excludes, includes stringList
+	fs.Var(&excludes, "exclude", "Gitignore-style pattern of files to leave out of the upload, can be repeated")
+	fs.Var(&includes, "include", "Gitignore-style pattern of files to upload even if excluded, can be repeated")
+	keepHistory := keepHistoryFlags(fs)
+	output := outputFlag(fs)
 
-	fs.Parse(args)
+	start := time.Now()
+	resultFormat, err := c.parseFlags(fs, args, output)
+	if err != nil {
+		return c.reportUsage("create-from-local", start, resultFormat, err)
+	}
+	invalid := func(err error) error {
+		return c.reportUsage("create-from-local", start, resultFormat, usage(fs, err))
+	}
 
 	if *repoPath == "" || *branchName == "" {
-		fs.Usage()
-		return
+		return invalid(errors.New("--repo-path and --branch-name are required"))
+	}
+	project, err := projectRef()
+	if err != nil {
+		return invalid(err)
+	}
+	keep, err := keepHistory()
+	if err != nil {
+		return invalid(err)
+	}
+
+	if !usecase.Transport(*transport).Valid() {
+		return invalid(fmt.Errorf("unknown transport: %s", *transport))
+	}
+	if !usecase.PushProtocol(*pushProtocol).Valid() {
+		return invalid(fmt.Errorf("unknown push protocol: %s", *pushProtocol))
+	}
+
+	if *resume && usecase.Transport(*transport) != usecase.TransportAPI {
+		return invalid(errors.New("--resume works only with --transport=api"))
+	}
+	if *backupProjectID != 0 && !*resume {
+		return invalid(errors.New("--backup-project-id needs --resume"))
+	}
+	if *backupProjectID < 0 {
+		return invalid(fmt.Errorf("invalid project id %d", *backupProjectID))
+	}
+
+	replaceMode := usecase.ReplaceMode(*replace)
+	if !replaceMode.Valid() {
+		return invalid(fmt.Errorf("unknown replace mode: %s", *replace))
+	}
+	if *visibility != "" && !usecase.Visibility(*visibility).Valid() {
+		return invalid(fmt.Errorf("unknown visibility: %s", *visibility))
 	}
 
 	input := usecase.Input{
 		RepoPath:     *repoPath,
 		BranchName:   *branchName,
 		SourceBranch: *sourceBranch,
+		Project:      project,
+		ReplaceMode:  replaceMode,
+		Transport:    usecase.Transport(*transport),
+		PushProtocol: usecase.PushProtocol(*pushProtocol),
+
+		BatchMaxBytes: *batchMaxBytes,
+		BatchMaxFiles: *batchMaxFiles,
+
+		Resume:          *resume,
+		BackupProjectID: *backupProjectID,
+
+		// Excludes from the config files come first, so the flags can override them.
+		Excludes: append(append([]string{}, c.config.Excludes...), excludes...),
+		Includes: includes,
+
+		KeepHistory: keep,
+
+		NewProject: usecase.ProjectOptions{
+			Namespace:     *namespace,
+			Path:          *projectPath,
+			Visibility:    usecase.Visibility(*visibility),
+			Description:   *description,
+			DefaultBranch: *defaultBranch,
+		},
 	}
 
-	c.logger.Infof("Starting process for repository: %s", input.RepoPath)
-	duration, filesCount, err := c.createFromLocalUseCase.Execute(context.Background(), input)
+	useCase := c.createFromLocalUseCase
+	var plan *dryrun.Plan
+	if *dryRun {
+		plan = dryrun.NewPlan()
+		gitGateway, gitlabGateway := c.recordingGateways(plan)
+		useCase = usecase.NewCreateAndPushOrphanBranchUseCase(gitGateway, gitlabGateway, c.logger)
+	}
+
+	var result *usecase.Result
+	if err = c.connect(ctx); err == nil {
+		c.logger.Infof("Starting process for repository: %s", input.RepoPath)
+		result, err = useCase.Execute(ctx, input)
+	}
+	if resultFormat == outputJSON {
+		return c.printReport("create-from-local", start, result, plan, err)
+	}
 	if err != nil {
-		c.logger.Errorf("Error: %v", err)
-		return
+		return err
+	}
+
+	if plan != nil {
+		c.printPlan(plan)
+		return nil
 	}
 
 	c.logger.Infof("Successfully created and pushed orphan branch '%s'.", input.BranchName)
-	c.logger.Infof("Copied %d files in %s.", filesCount, duration)
+	c.printResult(result, "Copied")
+	return nil
 }
 
-func (c *CLIController) handleCreateFromGitlab(args []string) {
-	fs := flag.NewFlagSet("create-from-gitlab", flag.ExitOnError)
+func (c *CLIController) handleCreateFromGitlab(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("create-from-gitlab", flag.ContinueOnError)
 	repoPath := fs.String("repo-path", "", "Path to the repository")
 	branchName := fs.String("branch-name", "", "Name of the new orphan branch")
+	projectRef := projectFlags(fs)
+	ref := fs.String("ref", "", "Branch, tag or commit SHA to download, the default branch by default")
+	path := fs.String("path", "", "Subdirectory of the repository to download")
+	format := fs.String("format", string(entity.ArchiveZip), "Archive format: zip, tar.gz, tar.bz2 or tar")
+	dryRun := fs.Bool("dry-run", false, "Print the plan without changing the repository or GitLab")
+	output := outputFlag(fs)
 
-	fs.Parse(args)
+	start := time.Now()
+	resultFormat, err := c.parseFlags(fs, args, output)
+	if err != nil {
+		return c.reportUsage("create-from-gitlab", start, resultFormat, err)
+	}
+	invalid := func(err error) error {
+		return c.reportUsage("create-from-gitlab", start, resultFormat, usage(fs, err))
+	}
 
 	if *repoPath == "" || *branchName == "" {
-		fs.Usage()
-		return
+		return invalid(errors.New("--repo-path and --branch-name are required"))
+	}
+	project, err := projectRef()
+	if err != nil {
+		return invalid(err)
+	}
+	if !entity.ArchiveFormat(*format).Valid() {
+		return invalid(fmt.Errorf("unknown archive format: %s", *format))
 	}
 
 	input := usecase.CreateOrphanBranchFromGitlabInput{
 		RepoPath:   *repoPath,
 		BranchName: *branchName,
+		Project:    project,
+		Ref:        *ref,
+		Path:       strings.Trim(*path, "/"),
+		Format:     entity.ArchiveFormat(*format),
+	}
+
+	useCase := c.createFromGitlabUseCase
+	var plan *dryrun.Plan
+	if *dryRun {
+		plan = dryrun.NewPlan()
+		gitGateway, gitlabGateway := c.recordingGateways(plan)
+		extractors := gateway.ArchiveExtractors{}
+		for format, extractor := range c.archiveExtractors {
+			extractors[format] = dryrun.NewRecordingArchiveExtractor(extractor, plan)
+		}
+		useCase = usecase.NewCreateOrphanBranchFromGitlabUseCase(gitGateway, gitlabGateway, extractors, c.logger)
+	}
+
+	var result *usecase.Result
+	if err = c.connect(ctx); err == nil {
+		c.logger.Infof("Starting process for repository: %s", input.RepoPath)
+		result, err = useCase.Execute(ctx, input)
 	}
+	if resultFormat == outputJSON {
+		return c.printReport("create-from-gitlab", start, result, plan, err)
+	}
+	if err != nil {
+		return err
+	}
+
+	if plan != nil {
+		c.printPlan(plan)
+		return nil
+	}
+
+	c.logger.Infof("Successfully created orphan branch '%s'.", input.BranchName)
+	c.printResult(result, "Copied")
+	return nil
+}
 
-	c.logger.Infof("Starting process for repository: %s", input.RepoPath)
-	duration, filesCount, err := c.createFromGitlabUseCase.Execute(context.Background(), input)
+func (c *CLIController) handleSquash(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("squash", flag.ContinueOnError)
+	repoPath := fs.String("repo-path", "", "Path to the repository")
+	projectRef := projectFlags(fs)
+	branch := fs.String("branch", "", "Remote branch to replace, the default branch of the project by default")
+	sourceBranch := fs.String("from", "", "Local branch with the files, the remote branch name by default")
+	pushProtocol := fs.String("push-protocol", string(usecase.PushProtocolHTTPS), "Protocol for git push: https or ssh")
+	deleteBranches := fs.Bool("delete-branches", false, "Delete all other branches of the project")
+	deleteTags := fs.Bool("delete-tags", false, "Delete all tags of the project")
+	var excludes, includes stringList
+	fs.Var(&excludes, "exclude", "Gitignore-style pattern of files to leave out, can be repeated")
+	fs.Var(&includes, "include", "Gitignore-style pattern of files to keep even if excluded, can be repeated")
+	keepHistory := keepHistoryFlags(fs)
+	dryRun := fs.Bool("dry-run", false, "Print the plan without changing the repository or GitLab")
+	output := outputFlag(fs)
+
+	start := time.Now()
+	resultFormat, err := c.parseFlags(fs, args, output)
 	if err != nil {
-		c.logger.Errorf("Error: %v", err)
-		return
+		return c.reportUsage("squash", start, resultFormat, err)
+	}
+	invalid := func(err error) error {
+		return c.reportUsage("squash", start, resultFormat, usage(fs, err))
 	}
 
-	c.logger.Infof("Successfully created and pushed orphan branch '%s'.", input.BranchName)
-	c.logger.Infof("Copied %d files in %s.", filesCount, duration)
+	if *repoPath == "" {
+		return invalid(errors.New("--repo-path is required"))
+	}
+	project, err := projectRef()
+	if err != nil {
+		return invalid(err)
+	}
+	keep, err := keepHistory()
+	if err != nil {
+		return invalid(err)
+	}
+	if !usecase.PushProtocol(*pushProtocol).Valid() {
+		return invalid(fmt.Errorf("unknown push protocol: %s", *pushProtocol))
+	}
+
+	input := usecase.SquashInput{
+		RepoPath:       *repoPath,
+		Project:        project,
+		Branch:         *branch,
+		SourceBranch:   *sourceBranch,
+		PushProtocol:   usecase.PushProtocol(*pushProtocol),
+		DeleteBranches: *deleteBranches,
+		DeleteTags:     *deleteTags,
+		Excludes:       append(append([]string{}, c.config.Excludes...), excludes...),
+		Includes:       includes,
+		KeepHistory:    keep,
+	}
+
+	useCase := c.squashUseCase
+	var plan *dryrun.Plan
+	if *dryRun {
+		plan = dryrun.NewPlan()
+		gitGateway, gitlabGateway := c.recordingGateways(plan)
+		useCase = usecase.NewSquashUseCase(gitGateway, gitlabGateway, c.logger)
+	}
+
+	var result *usecase.Result
+	if err = c.connect(ctx); err == nil {
+		c.logger.Infof("Starting process for repository: %s", input.RepoPath)
+		result, err = useCase.Execute(ctx, input)
+	}
+	if resultFormat == outputJSON {
+		return c.printReport("squash", start, result, plan, err)
+	}
+	if err != nil {
+		return err
+	}
+
+	if plan != nil {
+		c.printPlan(plan)
+		return nil
+	}
+
+	c.logger.Info("Successfully squashed the history of the project.")
+	c.printResult(result, "Pushed")
+	return nil
+}
+
+func (c *CLIController) handleConfig(args []string) error {
+	if len(args) < 1 || args[0] != "show" {
+		c.printUsage()
+		return &usageError{errors.New("usage: config show")}
+	}
+
+	fs := flag.NewFlagSet("config show", flag.ContinueOnError)
+	output := outputFlag(fs)
+	resultFormat, err := c.parseFlags(fs, args[1:], output)
+	if errors.Is(err, flag.ErrHelp) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if resultFormat == outputJSON {
+		return c.config.ShowJSON(c.out)
+	}
+	return c.config.Show(c.out)
+}
+
+// usage prints the usage of fs and returns err as a usage error.
+func usage(fs *flag.FlagSet, err error) error {
+	fs.Usage()
+	return &usageError{err}
+}
+
+// parseFlags parses the flags of a command and switches the log for the output
+// format they select. A wrong flag is returned as a usage error, with the format
+// given before it, so the error can still be reported in that format. The flag
+// package has printed the error and the usage already.
+func (c *CLIController) parseFlags(fs *flag.FlagSet, args []string, output func() (outputFormat, error)) (outputFormat, error) {
+	err := fs.Parse(args)
+	format, formatErr := output()
+	switch {
+	case formatErr != nil:
+		format = outputText
+		if err == nil {
+			err = usage(fs, formatErr)
+		}
+	case errors.Is(err, flag.ErrHelp):
+	case err != nil:
+		err = &usageError{err}
+	}
+	c.useOutput(format)
+	return format, err
+}
+
+// reportUsage reports a wrong command line of command as its result document,
+// if the output is one, and returns the usage error. A request for help with
+// -h is not an error.
+func (c *CLIController) reportUsage(command string, start time.Time, format outputFormat, err error) error {
+	if errors.Is(err, flag.ErrHelp) {
+		return nil
+	}
+	if format == outputJSON {
+		return c.printReport(command, start, nil, nil, err)
+	}
+	return err
+}
+
+// connect reads the token, before a command that works with GitLab, and checks
+// that it is set and that the instance answers at the URL and API version given.
+// The token is read here, so that a token command only runs when it is needed.
+func (c *CLIController) connect(ctx context.Context) error {
+	if err := c.config.ResolveToken(ctx); err != nil {
+		return err
+	}
+	if c.SetToken != nil {
+		c.SetToken(c.config.GitLabToken)
+	}
+	if c.config.GitLabToken == "" {
+		return entity.Unauthorized("GitLab token is not set: set GITLAB_TOKEN or a token source in the config file")
+	}
+	version, err := c.gitlabGateway.GetVersion(ctx)
+	if err != nil {
+		return fmt.Errorf("GitLab instance %s is not available: %w", c.config.GitLabURL, err)
+	}
+	c.logger.Infof("Using GitLab %s (%s) at %s", version.Version, version.Revision, c.config.GitLabURL)
+	return nil
+}
+
+// recordingGateways wraps the real gateways so that a use case only reads from
+// the repository and GitLab and records everything else into plan.
+func (c *CLIController) recordingGateways(plan *dryrun.Plan) (gateway.GitGateway, gateway.GitLabGateway) {
+	return dryrun.NewRecordingGitGateway(c.gitGateway, plan), dryrun.NewRecordingGitLabGateway(c.gitlabGateway, plan)
+}
+
+func (c *CLIController) printPlan(plan *dryrun.Plan) {
+	c.logger.Info("Dry run, nothing has been changed. Plan:")
+	for i, step := range plan.Steps {
+		c.logger.Infof("  %d. [%s] %s", i+1, step.Target, step.Description)
+	}
+	if plan.FilesCount > 0 {
+		c.logger.Infof("Files to commit: %d (%d bytes)", plan.FilesCount, plan.BytesCount)
+	}
+	if len(plan.SkippedFiles) > 0 {
+		c.logger.Infof("Files to skip: %d", len(plan.SkippedFiles))
+		for _, file := range plan.SkippedFiles {
+			c.logger.Infof("  %s", file)
+		}
+	}
+}
+
+// printResult reports the commits and the files of a successful run.
+func (c *CLIController) printResult(result *usecase.Result, verb string) {
+	c.logger.Infof("Commit %s (tree %s) on branch '%s'.", result.CommitSHA, result.TreeSHA, result.Branch)
+	if result.RemoteCommitSHA != "" && result.RemoteCommitSHA != result.CommitSHA {
+		c.logger.Infof("Branch '%s' of project %s is at %s.", result.Branch, result.Project, result.RemoteCommitSHA)
+	}
+	c.logger.Infof("%s %d files (%d bytes) in %s.", verb, result.Files, result.Bytes, result.Duration)
 }
 
 func (c *CLIController) printUsage() {
-	c.logger.Info("Usage: go run cmd/app/main.go <command> [options]")
+	c.logger.Info("Usage: go run cmd/app/main.go [global options] <command> [options]")
+	c.logger.Info("Global options:")
+	c.logger.Info("  --profile <name>              Config profile to use")
+	c.logger.Info("  --gitlab-url <url>            Base URL of the GitLab instance (default https://gitlab.com)")
+	c.logger.Info("  --gitlab-api-version <ver>    GitLab REST API version (default v4)")
+	c.logger.Info("  --deletion-timeout <dur>      How long to wait for a project deletion (default 2m)")
+	c.logger.Info("  --permanently-remove          Permanently remove projects marked for delayed deletion")
+	c.logger.Info("  --http-timeout <dur>          How long one GitLab request waits for a response (default 30s)")
+	c.logger.Info("  --http-retries <n>            How many times a failed GitLab request is retried (default 4)")
+	c.logger.Info("  --git-backend exec|native     Git implementation: the git executable (default) or built-in")
 	c.logger.Info("Commands:")
-	c.logger.Info("  create-from-local   --repo-path <path> --branch-name <name> [--from <source>]")
-	c.logger.Info("  create-from-gitlab  --repo-path <path> --branch-name <name>")
+	c.logger.Info("  create-from-local   --repo-path <path> --branch-name <name> [--project <path> | --project-id <id>]")
+	c.logger.Info("                      [--from <source>] [--replace backup|keep-backup|delete]")
+	c.logger.Info("                      [--namespace <group>] [--project-path <path>] [--visibility private|internal|public]")
+	c.logger.Info("                      [--description <text>] [--default-branch <name>]")
+	c.logger.Info("                      [--transport api|push] [--push-protocol https|ssh]")
+	c.logger.Info("                      [--batch-max-bytes <n>] [--batch-max-files <n>]")
+	c.logger.Info("                      [--resume [--backup-project-id <id>]]")
+	c.logger.Info("                      [--exclude <pattern>]... [--include <pattern>]...")
+	c.logger.Info("                      [--keep-last <n> | --keep-since <date>] [--dry-run] [--output text|json]")
+	c.logger.Info("  create-from-gitlab  --repo-path <path> --branch-name <name> [--project <path> | --project-id <id>]")
+	c.logger.Info("                      [--ref <ref>] [--path <dir>] [--format zip|tar.gz|tar.bz2|tar] [--dry-run]")
+	c.logger.Info("                      [--output text|json]")
+	c.logger.Info("  squash              --repo-path <path> [--project <path> | --project-id <id>] [--branch <name>] [--from <source>]")
+	c.logger.Info("                      [--push-protocol https|ssh] [--delete-branches] [--delete-tags]")
+	c.logger.Info("                      [--exclude <pattern>]... [--include <pattern>]...")
+	c.logger.Info("                      [--keep-last <n> | --keep-since <date>] [--dry-run] [--output text|json]")
+	c.logger.Info("  config show         [--output text|json]")
+	c.logger.Info("                      Print the effective configuration with secrets redacted")
 }
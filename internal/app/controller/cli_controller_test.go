@@ -0,0 +1,88 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/olegshirko/reposqueeze/internal/testutil"
+	"github.com/olegshirko/reposqueeze/pkg/config"
+)
+
+// testController returns a controller without use cases and gateways, which
+// is enough for the command lines that fail before connecting to GitLab,
+// and the buffer it prints its documents to.
+func testController() (*CLIController, *bytes.Buffer) {
+	c := NewCLIController(nil, nil, nil, nil, nil, nil, &config.Config{}, testutil.Logger())
+	out := &bytes.Buffer{}
+	c.out = out
+	return c, out
+}
+
+// A wrong command line under --output json is reported in the document as
+// well, with the exit code of a usage error.
+func TestRunReportsUsageErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		args      []string
+		wantError string // error of the document, empty if none is printed
+	}{
+		{
+			name:      "missing flag",
+			args:      []string{"create-from-local", "--output", "json", "--branch-name", "main"},
+			wantError: "--repo-path and --branch-name are required",
+		},
+		{
+			name:      "invalid value",
+			args:      []string{"create-from-gitlab", "--output", "json", "--repo-path", ".", "--branch-name", "main", "--format", "rar"},
+			wantError: "unknown archive format: rar",
+		},
+		{
+			name:      "unknown flag",
+			args:      []string{"squash", "--output", "json", "--no-such-flag"},
+			wantError: "flag provided but not defined: -no-such-flag",
+		},
+		{
+			name:      "malformed flag value",
+			args:      []string{"squash", "--output=json", "--keep-last", "many"},
+			wantError: `invalid value "many" for flag -keep-last: parse error`,
+		},
+		{name: "text output", args: []string{"squash", "--no-such-flag"}},
+		{name: "unknown output format", args: []string{"squash", "--output", "yaml", "--repo-path", "."}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, out := testController()
+
+			err := c.Run(context.Background(), tt.args)
+			if code := ExitCode(err); code != ExitUsage {
+				t.Errorf("Run error %v has exit code %d, want %d", err, code, ExitUsage)
+			}
+			if tt.wantError == "" {
+				if out.Len() > 0 {
+					t.Errorf("printed %s, want nothing", out)
+				}
+				return
+			}
+			var doc report
+			if err := json.Unmarshal(out.Bytes(), &doc); err != nil {
+				t.Fatalf("output is not a report: %v\n%s", err, out)
+			}
+			if doc.Command != tt.args[0] || doc.Status != "failed" || doc.ExitCode != ExitUsage || doc.Error != tt.wantError {
+				t.Errorf("report of %s with status %s, exit code %d and error %q, want %s, failed, %d and %q",
+					doc.Command, doc.Status, doc.ExitCode, doc.Error, tt.args[0], ExitUsage, tt.wantError)
+			}
+		})
+	}
+}
+
+func TestRunHelp(t *testing.T) {
+	c, out := testController()
+	if err := c.Run(context.Background(), []string{"squash", "--output", "json", "-h"}); err != nil {
+		t.Errorf("Run with -h: %v", err)
+	}
+	if out.Len() > 0 {
+		t.Errorf("help printed the document %s", out)
+	}
+}
@@ -0,0 +1,69 @@
+package controller
+
+import (
+	"context"
+	"errors"
+
+	"github.com/olegshirko/reposqueeze/internal/domain/entity"
+)
+
+// Exit codes of the command, one for each class of failure, so that scripts
+// can tell the failures apart without parsing the log.
+const (
+	ExitOK               = 0
+	ExitFailure          = 1 // Any other failure
+	ExitUsage            = 2 // Wrong command line, as with the flag package
+	ExitNotFound         = 3
+	ExitConflict         = 4
+	ExitUnauthorized     = 5
+	ExitForbidden        = 6
+	ExitRateLimited      = 7
+	ExitValidationFailed = 8
+	ExitGitCommandFailed = 9
+	ExitInterrupted      = 130 // Cancelled with Ctrl-C, as a shell reports SIGINT
+)
+
+// usageError is a wrong command line. The usage has been printed already.
+type usageError struct{ err error }
+
+func (e *usageError) Error() string { return e.err.Error() }
+
+func (e *usageError) Unwrap() error { return e.err }
+
+// ExitCode returns the exit code for the error a command has failed with.
+func ExitCode(err error) int {
+	var (
+		notFound     *entity.NotFoundError
+		conflict     *entity.ConflictError
+		unauthorized *entity.UnauthorizedError
+		forbidden    *entity.ForbiddenError
+		rateLimited  *entity.RateLimitedError
+		validation   *entity.ValidationFailedError
+		gitCommand   *entity.GitCommandError
+		gitNative    *entity.GitError
+		usage        *usageError
+	)
+	switch {
+	case err == nil:
+		return ExitOK
+	case errors.Is(err, context.Canceled):
+		return ExitInterrupted
+	case errors.As(err, &usage):
+		return ExitUsage
+	case errors.As(err, &notFound):
+		return ExitNotFound
+	case errors.As(err, &conflict):
+		return ExitConflict
+	case errors.As(err, &unauthorized):
+		return ExitUnauthorized
+	case errors.As(err, &forbidden):
+		return ExitForbidden
+	case errors.As(err, &rateLimited):
+		return ExitRateLimited
+	case errors.As(err, &validation):
+		return ExitValidationFailed
+	case errors.As(err, &gitCommand), errors.As(err, &gitNative):
+		return ExitGitCommandFailed
+	}
+	return ExitFailure
+}
@@ -0,0 +1,40 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/olegshirko/reposqueeze/internal/domain/entity"
+)
+
+func TestExitCode(t *testing.T) {
+	gitErr := &entity.GitCommandError{Args: []string{"push"}, ExitCode: -1, Err: errors.New("signal: killed")}
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"success", nil, ExitOK},
+		{"other failure", errors.New("boom"), ExitFailure},
+		{"usage", &usageError{errors.New("unknown flag")}, ExitUsage},
+		{"not found", entity.NotFound("project %s", "group/app"), ExitNotFound},
+		{"conflict", entity.Conflict("project %s was not deleted", "group/app"), ExitConflict},
+		{"ambiguous name", entity.ValidationFailed("found multiple projects with name %s", "app"), ExitValidationFailed},
+		{"unauthorized", entity.Unauthorized("no token"), ExitUnauthorized},
+		{"git failure", fmt.Errorf("push failed: %w", gitErr), ExitGitCommandFailed},
+		{"native git failure", &entity.GitError{Operation: "push", Err: errors.New("connection refused")}, ExitGitCommandFailed},
+		{"cancelled", context.Canceled, ExitInterrupted},
+		// A git command killed by Ctrl-C fails with both errors.
+		{"cancelled git", fmt.Errorf("push failed: %w", fmt.Errorf("%w: %w", context.Canceled, gitErr)), ExitInterrupted},
+		{"cancelled native git", &entity.GitError{Operation: "read files", Err: context.Canceled}, ExitInterrupted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ExitCode(tt.err); got != tt.want {
+				t.Errorf("ExitCode(%v) = %d, want %d", tt.err, got, tt.want)
+			}
+		})
+	}
+}
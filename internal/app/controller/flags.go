@@ -0,0 +1,90 @@
+package controller
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/olegshirko/reposqueeze/internal/app/usecase"
+	"github.com/olegshirko/reposqueeze/internal/domain/entity"
+)
+
+// stringList is a flag that can be given several times; every value is kept.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// projectFlags defines --project and --project-id on fs. The returned function
+// builds the project reference after fs has been parsed; without either flag
+// the use case falls back to the name of the repository directory.
+func projectFlags(fs *flag.FlagSet) func() (usecase.ProjectRef, error) {
+	projectPath := fs.String("project", "", "Full path of the GitLab project, e.g. group/subgroup/project")
+	projectID := fs.Int("project-id", 0, "Numeric ID of the GitLab project")
+	return func() (usecase.ProjectRef, error) {
+		if *projectPath != "" && *projectID != 0 {
+			return usecase.ProjectRef{}, fmt.Errorf("--project and --project-id cannot be used together")
+		}
+		if *projectID < 0 {
+			return usecase.ProjectRef{}, fmt.Errorf("invalid project id %d", *projectID)
+		}
+		return usecase.ProjectRef{ID: *projectID, Path: strings.Trim(*projectPath, "/")}, nil
+	}
+}
+
+// keepHistoryFlags defines --keep-last and --keep-since on fs. The returned
+// function builds the kept history after fs has been parsed; without either
+// flag the whole history is squashed.
+func keepHistoryFlags(fs *flag.FlagSet) func() (entity.KeepHistory, error) {
+	last := fs.Int("keep-last", 0, "Keep the last N commits on top of the squashed history")
+	since := fs.String("keep-since", "", "Keep the commits since a date, YYYY-MM-DD or RFC 3339, on top of the squashed history")
+	return func() (entity.KeepHistory, error) {
+		if *last != 0 && *since != "" {
+			return entity.KeepHistory{}, fmt.Errorf("--keep-last and --keep-since cannot be used together")
+		}
+		if *last < 0 {
+			return entity.KeepHistory{}, fmt.Errorf("invalid number of commits %d", *last)
+		}
+		keep := entity.KeepHistory{Last: *last}
+		if *since != "" {
+			date, err := parseDate(*since)
+			if err != nil {
+				return entity.KeepHistory{}, err
+			}
+			keep.Since = date
+		}
+		return keep, nil
+	}
+}
+
+// parseDate parses a date as YYYY-MM-DD in local time or as an RFC 3339 timestamp.
+func parseDate(value string) (time.Time, error) {
+	if date, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return date, nil
+	}
+	date, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q, use YYYY-MM-DD or RFC 3339", value)
+	}
+	return date, nil
+}
+
+// outputFlag defines --output on fs. The returned function checks the format
+// after fs has been parsed.
+func outputFlag(fs *flag.FlagSet) func() (outputFormat, error) {
+	output := fs.String("output", string(outputText), "Format of the result: text (log lines) or json (one document on stdout)")
+	return func() (outputFormat, error) {
+		format := outputFormat(*output)
+		if !format.Valid() {
+			return "", fmt.Errorf("unknown output format: %s", *output)
+		}
+		return format, nil
+	}
+}
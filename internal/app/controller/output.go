@@ -0,0 +1,138 @@
+package controller
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/olegshirko/reposqueeze/internal/app/usecase"
+	"github.com/olegshirko/reposqueeze/internal/infrastructure/dryrun"
+)
+
+// outputFormat is how a command reports its result.
+type outputFormat string
+
+const (
+	// outputText reports the result in log lines.
+	outputText outputFormat = "text"
+	// outputJSON prints a single report document to stdout and the log to stderr.
+	outputJSON outputFormat = "json"
+)
+
+// Valid reports whether f is a known output format.
+func (f outputFormat) Valid() bool {
+	return f == outputText || f == outputJSON
+}
+
+// report is the document printed by --output json, for failed runs and dry runs as well.
+type report struct {
+	Command  string `json:"command"`
+	Status   string `json:"status"` // "succeeded", "failed" or "planned"
+	ExitCode int    `json:"exit_code"`
+	Error    string `json:"error,omitempty"`
+
+	Project         *projectReport `json:"project,omitempty"`
+	Branch          string         `json:"branch,omitempty"`
+	CommitSHA       string         `json:"commit_sha,omitempty"`
+	TreeSHA         string         `json:"tree_sha,omitempty"`
+	RemoteCommitSHA string         `json:"remote_commit_sha,omitempty"`
+	Files           int            `json:"files"`
+	Bytes           int64          `json:"bytes"`
+
+	DurationSeconds float64       `json:"duration_seconds"` // Whole command
+	Phases          []phaseReport `json:"phases"`
+	Warnings        []string      `json:"warnings"`
+
+	Plan *planReport `json:"plan,omitempty"`
+}
+
+type projectReport struct {
+	ID   int    `json:"id,omitempty"`
+	Path string `json:"path"`
+	URL  string `json:"url,omitempty"`
+}
+
+type phaseReport struct {
+	Name            string  `json:"name"`
+	DurationSeconds float64 `json:"duration_seconds"`
+}
+
+type planReport struct {
+	Steps        []planStep `json:"steps"`
+	SkippedFiles []string   `json:"skipped_files"`
+}
+
+type planStep struct {
+	Target      string `json:"target"`
+	Description string `json:"description"`
+}
+
+// printReport prints the report of a command that started at start and
+// returns err, so the exit code does not depend on the output format.
+func (c *CLIController) printReport(command string, start time.Time, result *usecase.Result, plan *dryrun.Plan, err error) error {
+	doc := report{
+		Command:         command,
+		Status:          "succeeded",
+		ExitCode:        ExitCode(err),
+		DurationSeconds: time.Since(start).Seconds(),
+		Phases:          []phaseReport{},
+		Warnings:        []string{},
+	}
+	switch {
+	case err != nil:
+		doc.Status = "failed"
+		doc.Error = err.Error()
+	case plan != nil:
+		doc.Status = "planned"
+	}
+
+	if result != nil {
+		if result.Project != "" {
+			doc.Project = &projectReport{ID: result.ProjectID, Path: result.Project, URL: result.ProjectURL}
+		}
+		doc.Branch = result.Branch
+		doc.CommitSHA = result.CommitSHA
+		doc.TreeSHA = result.TreeSHA
+		doc.RemoteCommitSHA = result.RemoteCommitSHA
+		doc.Files = result.Files
+		doc.Bytes = result.Bytes
+		for _, phase := range result.Phases {
+			doc.Phases = append(doc.Phases, phaseReport{Name: phase.Name, DurationSeconds: phase.Duration.Seconds()})
+		}
+		doc.Warnings = append(doc.Warnings, result.Warnings...)
+	}
+
+	if plan != nil && err == nil {
+		// Nothing has been committed; the result holds placeholders.
+		doc.CommitSHA, doc.TreeSHA, doc.RemoteCommitSHA = "", "", ""
+		if plan.FilesCount > 0 {
+			// The plan counts the files that would be committed.
+			doc.Files = plan.FilesCount
+			doc.Bytes = plan.BytesCount
+		}
+		doc.Plan = &planReport{Steps: []planStep{}, SkippedFiles: []string{}}
+		for _, step := range plan.Steps {
+			doc.Plan.Steps = append(doc.Plan.Steps, planStep{Target: step.Target, Description: step.Description})
+		}
+		doc.Plan.SkippedFiles = append(doc.Plan.SkippedFiles, plan.SkippedFiles...)
+	}
+
+	if writeErr := c.writeJSON(doc); writeErr != nil && err == nil {
+		return writeErr
+	}
+	return err
+}
+
+// writeJSON prints v to the command output as indented JSON.
+func (c *CLIController) writeJSON(v interface{}) error {
+	encoder := json.NewEncoder(c.out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+// useOutput switches the log to stderr when the command output is a document.
+func (c *CLIController) useOutput(format outputFormat) {
+	if format == outputJSON {
+		c.logger.SetOutput(os.Stderr)
+	}
+}
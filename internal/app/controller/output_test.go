@@ -0,0 +1,103 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/olegshirko/reposqueeze/internal/app/usecase"
+	"github.com/olegshirko/reposqueeze/internal/domain/entity"
+	"github.com/olegshirko/reposqueeze/internal/infrastructure/dryrun"
+)
+
+func TestPrintReport(t *testing.T) {
+	result := &usecase.Result{
+		Project:         "group/app",
+		ProjectID:       42,
+		ProjectURL:      "https://gitlab.example.com/group/app",
+		Branch:          "main",
+		CommitSHA:       "c1",
+		TreeSHA:         "t1",
+		RemoteCommitSHA: "c1",
+		Files:           3,
+		Bytes:           120,
+		Phases:          []usecase.Phase{{Name: "build", Duration: 1500 * time.Millisecond}},
+		Warnings:        []string{"backup kept"},
+	}
+	plan := dryrun.NewPlan()
+	plan.Steps = []dryrun.Step{{Target: "gitlab", Description: "create project app"}}
+	plan.SkippedFiles = []string{"vendor/lib.go"}
+	plan.FilesCount, plan.BytesCount = 2, 19
+
+	tests := []struct {
+		name   string
+		result *usecase.Result
+		plan   *dryrun.Plan
+		err    error
+		want   report
+	}{
+		{
+			name:   "succeeded",
+			result: result,
+			want: report{
+				Command: "squash", Status: "succeeded", ExitCode: ExitOK,
+				Project: &projectReport{ID: 42, Path: "group/app", URL: "https://gitlab.example.com/group/app"},
+				Branch:  "main", CommitSHA: "c1", TreeSHA: "t1", RemoteCommitSHA: "c1", Files: 3, Bytes: 120,
+				Phases:   []phaseReport{{Name: "build", DurationSeconds: 1.5}},
+				Warnings: []string{"backup kept"},
+			},
+		},
+		{
+			// The SHAs of a dry run are placeholders and the plan counts the files.
+			name:   "planned",
+			result: result,
+			plan:   plan,
+			want: report{
+				Command: "squash", Status: "planned", ExitCode: ExitOK,
+				Project: &projectReport{ID: 42, Path: "group/app", URL: "https://gitlab.example.com/group/app"},
+				Branch:  "main", Files: 2, Bytes: 19,
+				Phases:   []phaseReport{{Name: "build", DurationSeconds: 1.5}},
+				Warnings: []string{"backup kept"},
+				Plan: &planReport{
+					Steps:        []planStep{{Target: "gitlab", Description: "create project app"}},
+					SkippedFiles: []string{"vendor/lib.go"},
+				},
+			},
+		},
+		{
+			name: "failed",
+			plan: plan,
+			err:  fmt.Errorf("failed to find project: %w", entity.NotFound("project group/app not found")),
+			want: report{
+				Command: "squash", Status: "failed", ExitCode: ExitNotFound,
+				Error:    "failed to find project: project group/app not found",
+				Phases:   []phaseReport{},
+				Warnings: []string{},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, out := testController()
+
+			err := c.printReport("squash", time.Now(), tt.result, tt.plan, tt.err)
+			if !errors.Is(err, tt.err) {
+				t.Errorf("printReport returned %v, want the error of the command %v", err, tt.err)
+			}
+			var doc report
+			if err := json.Unmarshal(out.Bytes(), &doc); err != nil {
+				t.Fatalf("output is not a report: %v\n%s", err, out)
+			}
+			if doc.DurationSeconds < 0 || doc.DurationSeconds > 60 {
+				t.Errorf("duration %f seconds", doc.DurationSeconds)
+			}
+			doc.DurationSeconds = 0
+			if !reflect.DeepEqual(doc, tt.want) {
+				t.Errorf("report:\n%+v\nwant:\n%+v", doc, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,239 @@
+package usecase
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/olegshirko/reposqueeze/internal/domain/entity"
+	"github.com/olegshirko/reposqueeze/internal/domain/gateway"
+	"github.com/olegshirko/reposqueeze/internal/pkg/logger"
+)
+
+const (
+	// DefaultBatchMaxBytes is the default limit on the payload of one Commits API request.
+	DefaultBatchMaxBytes = 20 << 20 // 20 MiB
+	// DefaultBatchMaxFiles is the default limit on the number of actions in one commit.
+	DefaultBatchMaxFiles = 1000
+
+	// batchAttempts is how many times a failed batch is tried before giving up.
+	batchAttempts = 3
+	// actionOverhead approximates the JSON around the content of one action.
+	actionOverhead = 128
+)
+
+// batchRetryDelay is multiplied by the attempt number between retries.
+var batchRetryDelay = 2 * time.Second
+
+// commitBatcher uploads a large set of actions as a chain of commits on one
+// branch, each small enough for the GitLab request size limits. A failed batch
+// is retried on its own; the batches committed before it are kept. When a
+// batch keeps failing, upload returns a partialUploadError and a later upload
+// with resume continues after the last committed part.
+type commitBatcher struct {
+	gitLab   gateway.GitLabGateway
+	logger   logger.Logger
+	maxBytes int64
+	maxFiles int
+}
+
+func newCommitBatcher(gitLab gateway.GitLabGateway, log logger.Logger, maxBytes int64, maxFiles int) *commitBatcher {
+	if maxBytes <= 0 {
+		maxBytes = DefaultBatchMaxBytes
+	}
+	if maxFiles <= 0 {
+		maxFiles = DefaultBatchMaxFiles
+	}
+	return &commitBatcher{gitLab: gitLab, logger: log, maxBytes: maxBytes, maxFiles: maxFiles}
+}
+
+// split groups actions into batches by estimated payload size and count.
+// An action larger than maxBytes gets a batch of its own.
+func (b *commitBatcher) split(actions []gateway.CommitAction) [][]gateway.CommitAction {
+	var batches [][]gateway.CommitAction
+	var current []gateway.CommitAction
+	var currentBytes int64
+	for _, action := range actions {
+		size := actionPayloadSize(action)
+		if len(current) > 0 && (currentBytes+size > b.maxBytes || len(current) >= b.maxFiles) {
+			batches = append(batches, current)
+			current, currentBytes = nil, 0
+		}
+		if size > b.maxBytes {
+			b.logger.Warnf("File %s (%d bytes encoded) exceeds the batch limit of %d bytes and is sent alone", action.FilePath, size, b.maxBytes)
+		}
+		current = append(current, action)
+		currentBytes += size
+	}
+	if len(current) > 0 {
+		batches = append(batches, current)
+	}
+	return batches
+}
+
+// actionPayloadSize estimates the size of an action in the request body.
+// Text content can grow when JSON escapes it, which actionOverhead only partly covers.
+func actionPayloadSize(action gateway.CommitAction) int64 {
+	contentSize := len(action.Content)
+	if action.Encoding == "base64" {
+		contentSize = base64.StdEncoding.EncodedLen(contentSize)
+	}
+	return int64(contentSize + len(action.FilePath) + actionOverhead)
+}
+
+// partialUploadError is returned by upload when a part fails after the parts
+// before it have been committed. They stay on the branch for a resumed upload.
+type partialUploadError struct {
+	part  int // The part that failed, counted from 1
+	parts int
+	err   error
+}
+
+func (e *partialUploadError) Error() string {
+	return fmt.Sprintf("failed to upload part %d of %d, parts 1-%d are committed: %v", e.part, e.parts, e.part-1, e.err)
+}
+
+func (e *partialUploadError) Unwrap() error { return e.err }
+
+// upload commits the actions to branchName and returns the last commit. With
+// resume, the parts that an earlier upload of the same actions has committed
+// to the branch are found by the message of the branch head and skipped.
+func (b *commitBatcher) upload(ctx context.Context, projectID, branchName, commitMessage string, actions []gateway.CommitAction, resume bool) (*entity.Commit, error) {
+	batches := b.split(actions)
+	if len(batches) > 1 {
+		b.logger.Infof("Uploading %d files in %d commits", len(actions), len(batches))
+	}
+
+	var last *entity.Commit
+	done := 0
+	if resume {
+		var err error
+		done, last, err = b.landedParts(ctx, projectID, branchName, commitMessage, len(batches))
+		if err != nil {
+			return nil, err
+		}
+		if done > 0 {
+			b.logger.Infof("Resuming after part %d/%d, committed as %s", done, len(batches), last.SHA)
+		}
+	}
+
+	for i := done; i < len(batches); i++ {
+		batch := batches[i]
+		commit, err := b.commitBatch(ctx, projectID, branchName, partMessage(commitMessage, i+1, len(batches), len(actions)), batch, last)
+		if err != nil {
+			if i == 0 {
+				return nil, fmt.Errorf("failed to upload part 1 of %d: %w", len(batches), err)
+			}
+			return nil, &partialUploadError{part: i + 1, parts: len(batches), err: err}
+		}
+		last = commit
+		if len(batches) > 1 {
+			b.logger.Infof("Committed part %d/%d (%d files) as %s", i+1, len(batches), len(batch), commit.SHA)
+		}
+	}
+
+	if len(batches) > 1 {
+		b.logger.Infof("Commits of parts 1-%d on branch %s form one logical import", len(batches), branchName)
+	}
+	return last, nil
+}
+
+// partMessage returns the message of part of parts, or commitMessage if there is only one part.
+func partMessage(commitMessage string, part, parts, files int) string {
+	if parts == 1 {
+		return commitMessage
+	}
+	return fmt.Sprintf("%s (part %d/%d)\n\nThis commit is part %d of %d of a single import of %d files.",
+		commitMessage, part, parts, part, parts, files)
+}
+
+// landedParts returns how many of parts are committed to the branch and the
+// last of them, as told by the message of the branch head. A head that is not
+// a part of commitMessage, or of another number of parts, cannot be resumed.
+func (b *commitBatcher) landedParts(ctx context.Context, projectID, branchName, commitMessage string, parts int) (int, *entity.Commit, error) {
+	branch, err := b.gitLab.GetBranch(ctx, projectID, branchName)
+	if err != nil {
+		return 0, nil, err
+	}
+	if branch == nil || branch.CommitSHA == "" {
+		return 0, nil, nil
+	}
+	head := &entity.Commit{SHA: branch.CommitSHA, Message: branch.CommitMessage}
+
+	subject, _, _ := strings.Cut(branch.CommitMessage, "\n")
+	if parts == 1 && subject == commitMessage {
+		return 1, head, nil
+	}
+	var part, total int
+	rest, ok := strings.CutPrefix(subject, commitMessage)
+	if _, err := fmt.Sscanf(rest, " (part %d/%d)", &part, &total); !ok || err != nil || part < 1 || part > total {
+		return 0, nil, fmt.Errorf("branch %s is at %s, which is not a part of this upload, so the upload cannot be resumed", branchName, branch.CommitSHA)
+	}
+	if total != parts {
+		return 0, nil, fmt.Errorf("branch %s holds part %d of %d, but the files now make %d parts: resume with the same files and batch limits",
+			branchName, part, total, parts)
+	}
+	return part, head, nil
+}
+
+// commitBatch commits one batch, retrying the failures that retryBatch accepts.
+// Before a retry it checks whether the branch head has moved past previous,
+// which means the failed request was applied and only its response was lost.
+func (b *commitBatcher) commitBatch(ctx context.Context, projectID, branchName, message string, batch []gateway.CommitAction, previous *entity.Commit) (*entity.Commit, error) {
+	var err error
+	for attempt := 1; attempt <= batchAttempts; attempt++ {
+		if attempt > 1 {
+			if head := b.landedCommit(ctx, projectID, branchName, previous); head != nil {
+				b.logger.Infof("Batch was committed despite the error, continuing from %s", head.SHA)
+				return head, nil
+			}
+			select {
+			case <-time.After(time.Duration(attempt-1) * batchRetryDelay):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+
+		var commit *entity.Commit
+		commit, err = b.gitLab.CommitFilesViaAPI(ctx, projectID, branchName, message, batch)
+		if err == nil {
+			return commit, nil
+		}
+		if ctx.Err() != nil || !retryBatch(err) {
+			return nil, err
+		}
+		b.logger.Warnf("Commit of %d files failed (attempt %d of %d): %v", len(batch), attempt, batchAttempts, err)
+	}
+	return nil, err
+}
+
+// retryBatch reports whether a failed commit is worth another attempt.
+//
+// A commit is not idempotent, so the HTTP transport retries it only when
+// GitLab has not handled it (429 and 503) and gives up on those for good.
+// The other server errors, timeouts and network errors may hide a commit that
+// has landed; the transport cannot tell, but commitBatch can check the branch
+// head, so it retries them. Errors in the request itself are not retried.
+func retryBatch(err error) bool {
+	var apiErr *entity.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode >= 500 && apiErr.StatusCode != http.StatusServiceUnavailable
+	}
+	return true
+}
+
+// landedCommit returns the branch head if it differs from previous.
+func (b *commitBatcher) landedCommit(ctx context.Context, projectID, branchName string, previous *entity.Commit) *entity.Commit {
+	branch, err := b.gitLab.GetBranch(ctx, projectID, branchName)
+	if err != nil || branch == nil || branch.CommitSHA == "" {
+		return nil
+	}
+	if previous != nil && branch.CommitSHA == previous.SHA {
+		return nil
+	}
+	return &entity.Commit{SHA: branch.CommitSHA}
+}
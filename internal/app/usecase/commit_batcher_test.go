@@ -0,0 +1,200 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/olegshirko/reposqueeze/internal/domain/entity"
+	"github.com/olegshirko/reposqueeze/internal/domain/gateway"
+	"github.com/olegshirko/reposqueeze/internal/testutil"
+)
+
+// testActions returns n text actions of size bytes each.
+func testActions(n, size int) []gateway.CommitAction {
+	var actions []gateway.CommitAction
+	for i := range n {
+		actions = append(actions, gateway.NewCreateAction(fmt.Sprintf("file%d.txt", i), []byte(strings.Repeat("x", size)), false))
+	}
+	return actions
+}
+
+// noRetryDelay retries failed batches at once for the duration of a test.
+func noRetryDelay(t *testing.T) {
+	delay := batchRetryDelay
+	batchRetryDelay = 0
+	t.Cleanup(func() { batchRetryDelay = delay })
+}
+
+func TestCommitBatcherSplit(t *testing.T) {
+	actionSize := actionPayloadSize(testActions(1, 100)[0])
+	tests := []struct {
+		name     string
+		actions  []gateway.CommitAction
+		maxBytes int64
+		maxFiles int
+		want     []int // files per batch
+	}{
+		{"one batch", testActions(5, 100), 10 * actionSize, 10, []int{5}},
+		{"file limit", testActions(5, 100), 10 * actionSize, 2, []int{2, 2, 1}},
+		{"byte limit", testActions(5, 100), 2 * actionSize, 10, []int{2, 2, 1}},
+		{"large file alone", append(testActions(2, 100), testActions(1, 1000)...), 2 * actionSize, 10, []int{2, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newCommitBatcher(testutil.NewFakeGitLab(), testutil.Logger(), tt.maxBytes, tt.maxFiles)
+			var got []int
+			for _, batch := range b.split(tt.actions) {
+				got = append(got, len(batch))
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("batches of %v files, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCommitBatcherUpload(t *testing.T) {
+	noRetryDelay(t)
+	serverError := entity.NewAPIError(&entity.APIError{StatusCode: http.StatusBadGateway, Status: "502 Bad Gateway"})
+	badRequest := entity.NewAPIError(&entity.APIError{StatusCode: http.StatusBadRequest, Status: "400 Bad Request"})
+	tests := []struct {
+		name      string
+		commitErr func(call int) (bool, error)
+		wantCalls int
+		wantParts int    // parts committed to the branch
+		wantErr   string // empty if the upload succeeds
+	}{
+		{name: "no failure", wantCalls: 3, wantParts: 3},
+		{
+			name: "part retried",
+			commitErr: func(call int) (bool, error) {
+				if call == 2 {
+					return false, serverError
+				}
+				return false, nil
+			},
+			wantCalls: 4,
+			wantParts: 3,
+		},
+		{
+			// The response of part 2 is lost, but the commit has landed, so it is not made twice.
+			name: "landed part detected",
+			commitErr: func(call int) (bool, error) {
+				if call == 2 {
+					return true, serverError
+				}
+				return false, nil
+			},
+			wantCalls: 3,
+			wantParts: 3,
+		},
+		{
+			name: "part fails for good",
+			commitErr: func(call int) (bool, error) {
+				if call >= 2 {
+					return false, serverError
+				}
+				return false, nil
+			},
+			wantCalls: 1 + batchAttempts,
+			wantParts: 1,
+			wantErr:   "failed to upload part 2 of 3, parts 1-1 are committed",
+		},
+		{
+			name: "request error is not retried",
+			commitErr: func(call int) (bool, error) {
+				if call == 1 {
+					return false, badRequest
+				}
+				return false, nil
+			},
+			wantCalls: 1,
+			wantErr:   "failed to upload part 1 of 3",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gitLab := testutil.NewFakeGitLab()
+			gitLab.CommitErr = tt.commitErr
+			b := newCommitBatcher(gitLab, testutil.Logger(), 1<<20, 2)
+
+			last, err := b.upload(context.Background(), "1", "main", "Import", testActions(5, 10), false)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("upload error = %v, want one containing %q", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatalf("upload: %v", err)
+			}
+			if gitLab.CommitCalls != tt.wantCalls {
+				t.Errorf("%d commit requests, want %d", gitLab.CommitCalls, tt.wantCalls)
+			}
+			commits := gitLab.BranchCommits(1, "main")
+			if len(commits) != tt.wantParts {
+				t.Fatalf("%d commits on the branch, want %d", len(commits), tt.wantParts)
+			}
+			for i, commit := range commits {
+				if want := fmt.Sprintf("Import (part %d/3)", i+1); !strings.HasPrefix(commit.Message, want) {
+					t.Errorf("commit %d has message %q, want %q", i+1, commit.Message, want)
+				}
+			}
+			if err == nil && last.SHA != commits[len(commits)-1].SHA {
+				t.Errorf("upload returned %s, want the branch head %s", last.SHA, commits[len(commits)-1].SHA)
+			}
+		})
+	}
+}
+
+func TestCommitBatcherResume(t *testing.T) {
+	noRetryDelay(t)
+	gitLab := testutil.NewFakeGitLab()
+	gitLab.CommitErr = func(call int) (bool, error) {
+		if call >= 2 {
+			return false, errors.New("connection reset")
+		}
+		return false, nil
+	}
+	b := newCommitBatcher(gitLab, testutil.Logger(), 1<<20, 2)
+	actions := testActions(5, 10)
+
+	_, err := b.upload(context.Background(), "1", "main", "Import", actions, false)
+	var partial *partialUploadError
+	if !errors.As(err, &partial) || partial.part != 2 {
+		t.Fatalf("upload error = %v, want a partial upload failing at part 2", err)
+	}
+
+	// The rerun uploads the parts after the one that has landed.
+	gitLab.CommitErr = nil
+	gitLab.CommitCalls = 0
+	last, err := b.upload(context.Background(), "1", "main", "Import", actions, true)
+	if err != nil {
+		t.Fatalf("resumed upload: %v", err)
+	}
+	if gitLab.CommitCalls != 2 {
+		t.Errorf("resumed upload made %d commits, want 2", gitLab.CommitCalls)
+	}
+	commits := gitLab.BranchCommits(1, "main")
+	if len(commits) != 3 || last.SHA != commits[2].SHA {
+		t.Fatalf("branch has %d commits ending at %s, want 3 ending at %s", len(commits), commits[len(commits)-1].SHA, last.SHA)
+	}
+	for i, commit := range commits {
+		if want := fmt.Sprintf("Import (part %d/3)", i+1); !strings.HasPrefix(commit.Message, want) {
+			t.Errorf("commit %d has message %q, want %q", i+1, commit.Message, want)
+		}
+	}
+
+	// Other files or limits make other parts, which cannot continue the branch.
+	if _, err := b.upload(context.Background(), "1", "main", "Import", testActions(7, 10), true); err == nil ||
+		!strings.Contains(err.Error(), "holds part 3 of 3, but the files now make 4 parts") {
+		t.Errorf("resume with other files: error = %v", err)
+	}
+	if _, err := b.upload(context.Background(), "1", "main", "Other import", actions, true); err == nil ||
+		!strings.Contains(err.Error(), "not a part of this upload") {
+		t.Errorf("resume with another message: error = %v", err)
+	}
+}
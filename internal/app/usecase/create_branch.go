@@ -2,15 +2,16 @@ package usecase
 
 import (
 	"context"
-	"io"
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
 	"strconv"
-	"strings"
 	"time"
 
 	"github.com/olegshirko/reposqueeze/internal/domain/entity"
 	"github.com/olegshirko/reposqueeze/internal/domain/gateway"
+	"github.com/olegshirko/reposqueeze/internal/pkg/ignore"
 	"github.com/olegshirko/reposqueeze/internal/pkg/logger"
 )
 
@@ -21,11 +22,68 @@ type CreateAndPushOrphanBranchUseCase struct {
 	logger        logger.Logger
 }
 
+// Transport defines how the orphan branch is uploaded to GitLab.
+type Transport string
+
+const (
+	// TransportAPI sends all files in one request to the GitLab Commits API.
+	TransportAPI Transport = "api"
+	// TransportPush pushes the local orphan branch with git push.
+	TransportPush Transport = "push"
+)
+
+// Valid reports whether t is a known transport.
+func (t Transport) Valid() bool {
+	return t == TransportAPI || t == TransportPush
+}
+
+// PushProtocol defines which project URL git push uses.
+type PushProtocol string
+
+const (
+	// PushProtocolHTTPS pushes over HTTPS, authenticated with the GitLab token.
+	PushProtocolHTTPS PushProtocol = "https"
+	// PushProtocolSSH pushes over SSH with the user's SSH keys.
+	PushProtocolSSH PushProtocol = "ssh"
+)
+
+// Valid reports whether p is a known push protocol.
+func (p PushProtocol) Valid() bool {
+	return p == PushProtocolHTTPS || p == PushProtocolSSH
+}
+
 // Input represents the input data for the use case.
 type Input struct {
 	RepoPath     string
 	BranchName   string
 	SourceBranch string
+	Project      ProjectRef     // Project to replace, the name of the repository directory by default
+	NewProject   ProjectOptions // Settings of the new project
+	ReplaceMode  ReplaceMode    // What to do with an existing project, ReplaceModeBackup by default
+	Transport    Transport      // How to upload the branch, TransportAPI by default
+	PushProtocol PushProtocol   // Used with TransportPush, PushProtocolHTTPS by default
+
+	// Limits of one commit with TransportAPI; larger uploads are split into
+	// several commits. Zero means DefaultBatchMaxBytes and DefaultBatchMaxFiles.
+	BatchMaxBytes int64
+	BatchMaxFiles int
+
+	// Resume continues an upload through TransportAPI that has failed part way.
+	// The project is not replaced again: the new project left by the failed run
+	// gets the parts that are missing, and the replacement is finished with the
+	// backup of the old project given by BackupProjectID, if there is one.
+	Resume          bool
+	BackupProjectID int
+
+	// Gitignore-style patterns of files to leave out of the upload. They are
+	// applied after the .squeezeignore file of the repository, Includes last,
+	// and the last matching pattern wins.
+	Excludes []string
+	Includes []string
+
+	// KeepHistory keeps the recent commits on top of the squashed history.
+	// It needs TransportPush, because the Commits API cannot upload history.
+	KeepHistory entity.KeepHistory
 }
 
 // NewCreateAndPushOrphanBranchUseCase creates a new instance of the use case.
@@ -42,102 +100,261 @@ func NewCreateAndPushOrphanBranchUseCase(
 }
 
 // Execute runs the use case.
-func (uc *CreateAndPushOrphanBranchUseCase) Execute(ctx context.Context, input Input) (time.Duration, int, error) {
-	// Step 1: Find and delete the project if it exists.
-	projectName := filepath.Base(strings.TrimSuffix(input.RepoPath, ".git"))
-	uc.logger.Info(projectName)
-	project, err := uc.GitLabGateway.FindProjectByName(projectName)
+func (uc *CreateAndPushOrphanBranchUseCase) Execute(ctx context.Context, input Input) (*Result, error) {
+	projectRef := projectRefFromRepoPath(input.Project, input.RepoPath, input.NewProject.Namespace)
+	uc.logger.Infof("Project: %s", projectRef)
+	if !input.KeepHistory.IsZero() && input.Transport != TransportPush {
+		return nil, fmt.Errorf("keeping %s needs the push transport", input.KeepHistory)
+	}
+	if input.Resume && input.Transport == TransportPush {
+		return nil, fmt.Errorf("only an upload through the api transport can be resumed")
+	}
+	matcher, err := loadIgnoreRules(input.RepoPath, input.Excludes, input.Includes)
 	if err != nil {
-		return 0, 0, err
+		return nil, err
 	}
+	result := newResult(uc.logger)
 
-	if project != nil {
-		err = uc.GitLabGateway.DeleteProject(project.ID)
-		if err != nil {
-			return 0, 0, err
-		}
+	// Step 1: Build the orphan branch locally under a name that cannot clash with
+	// the user's branches. The checkout of the repository is not touched.
+	endPhase := result.startPhase("build")
+	repo := &entity.Repository{Path: input.RepoPath}
+	localBranch := &entity.Branch{Name: "reposqueeze-orphan-" + time.Now().UTC().Format("20060102-150405")}
+	commit, err := createBranch(ctx, uc.GitGateway, repo, localBranch, input.SourceBranch, input.KeepHistory)
+	if err != nil {
+		return nil, err
 	}
 
-	project, err = uc.GitLabGateway.CreateProject(projectName)
+	// Cleanup runs even if ctx is cancelled.
+	cleanupCtx := context.WithoutCancel(ctx)
+	defer func() {
+		if err := uc.GitGateway.DeleteLocalBranch(cleanupCtx, input.RepoPath, localBranch.Name); err != nil {
+			result.warnf("Warning: failed to clean up local orphan branch %s: %v", localBranch.Name, err)
+		}
+	}()
+
+	// Step 2: Get a list of all files of the branch and leave out the excluded ones.
+	files, err := uc.GitGateway.ListFiles(ctx, input.RepoPath, localBranch.Name)
 	if err != nil {
-		return 0, 0, err
+		return nil, err
 	}
-
-	// Step 2: Create the orphan branch locally and commit all files.
-	repo := &entity.Repository{Path: input.RepoPath}
-	branch := &entity.Branch{Name: input.BranchName}
-	err = uc.GitGateway.CreateOrphanBranch(ctx, repo, branch, input.SourceBranch)
+	// The commits must match the upload, whatever the transport.
+	files, commit, err = excludeFiles(ctx, uc.logger, uc.GitGateway, input.RepoPath, localBranch.Name, matcher, files, commit)
 	if err != nil {
-		return 0, 0, err
+		return nil, err
 	}
 
-	if err := uc.GitGateway.RemoveDirectory(input.RepoPath, "vendor"); err != nil {
-		return 0, 0, err
+	if len(files) == 0 {
+		// Nothing to push: do not touch the existing project at all.
+		return nil, fmt.Errorf("no files to push in %s", input.RepoPath)
 	}
+	endPhase()
 
-	// Step 3: Get a list of all files in the repository.
-	files, err := uc.GitGateway.ListFiles(input.RepoPath)
+	// Step 3: Move the existing project aside and create a new one.
+	// Until the new project is verified, any failure restores the old project,
+	// unless the committed parts of the upload are kept for a resumed run.
+	endPhase = result.startPhase("create_project")
+	replacement := newProjectReplacement(uc.GitLabGateway, uc.logger, input.ReplaceMode, projectRef, input.NewProject)
+	var project *entity.Project
+	if input.Resume {
+		project, err = replacement.Resume(ctx, input.BackupProjectID)
+	} else {
+		project, err = replacement.Begin(ctx)
+	}
 	if err != nil {
-		return 0, 0, err
+		return nil, err
 	}
-
+	endPhase()
+	succeeded := false
+	keep := input.Resume
 	defer func() {
-		// Switch back to the original branch first
-		if err := uc.GitGateway.CheckoutBranch(input.RepoPath, input.SourceBranch); err != nil {
-			uc.logger.Warnf("Warning: failed to switch back to branch %s: %v\n", input.SourceBranch, err)
-		}
-		// Then delete the orphan branch
-		if err := uc.GitGateway.DeleteLocalBranch(input.RepoPath, input.BranchName); err != nil {
-			uc.logger.Warnf("Warning: failed to clean up local orphan branch %s: %v\n", input.BranchName, err)
+		if !succeeded && !keep {
+			replacement.Rollback(ctx)
 		}
 	}()
 
-	// // Step 2: Create the branch on the remote using the commit SHA.
-	// err = uc.GitLabGateway.CreateRemoteBranch(ctx, input.GitLabProjectID, input.BranchName, commitSHA)
-	// if err != nil {
-	// 	return fmt.Errorf("failed to create remote branch: %w", err)
-	// }
+	// Step 4: Upload the orphan branch to the new project.
+	endPhase = result.startPhase("upload")
+	startTime := time.Now()
+	if input.Transport == TransportPush {
+		err = uc.pushBranch(ctx, project, input, localBranch.Name)
+	} else {
+		err = uc.commitFilesViaAPI(ctx, project, input, localBranch.Name, files)
+	}
+	var partial *partialUploadError
+	if errors.As(err, &partial) && ctx.Err() == nil {
+		// The committed parts are kept for a resumed run; Ctrl-C still undoes everything.
+		keep = true
+	}
+	if err != nil {
+		if keep {
+			return nil, fmt.Errorf("%w; %s", err, replacement.resumeHint())
+		}
+		return nil, err
+	}
+	duration := time.Since(startTime)
+	endPhase()
 
-	if len(files) == 0 {
-		return 0, 0, err
+	// Step 5: Verify the branch has landed in the new project before dropping the backup.
+	endPhase = result.startPhase("verify")
+	projectID := strconv.Itoa(project.ID)
+	remoteBranch, err := uc.GitLabGateway.GetBranch(ctx, projectID, input.BranchName)
+	if err != nil {
+		return nil, err
+	}
+	if remoteBranch == nil || remoteBranch.CommitSHA == "" {
+		return nil, fmt.Errorf("branch %s was not found in project %s after push", input.BranchName, project.Name)
 	}
+	// A pushed branch holds the local commits; the Commits API makes new ones.
+	if input.Transport == TransportPush && remoteBranch.CommitSHA != commit.CommitSHA {
+		return nil, fmt.Errorf("branch %s of project %s is at %s instead of the pushed commit %s",
+			input.BranchName, project.Name, remoteBranch.CommitSHA, commit.CommitSHA)
+	}
+	uc.logger.Infof("Verified branch %s at %s in project %s", remoteBranch.Name, remoteBranch.CommitSHA, project.Name)
+	endPhase()
 
-	// Step 4: Prepare the file actions for the GitLab API commit.
-	var actions []gateway.CommitAction
-	for _, file := range files {
-		f, err := os.Open(filepath.Join(input.RepoPath, file))
-		if err != nil {
-			return 0, 0, err
+	succeeded = true
+	endPhase = result.startPhase("finish")
+	replacement.Finish(ctx, result)
+
+	// Step 6: Create the default branch of the new project, if it is another
+	// branch, from the same commit, so the project does not point to a missing branch.
+	defaultBranch := input.NewProject.DefaultBranch
+	if defaultBranch != "" && defaultBranch != input.BranchName {
+		if err := uc.GitLabGateway.CreateRemoteBranch(ctx, projectID, defaultBranch, remoteBranch.CommitSHA); err != nil {
+			result.warnf("Warning: failed to create default branch %s at %s: %v", defaultBranch, remoteBranch.CommitSHA, err)
+		} else {
+			uc.logger.Infof("Created default branch %s at %s", defaultBranch, remoteBranch.CommitSHA)
 		}
+	}
+
+	endPhase()
+
+	result.setProject(project)
+	result.Branch = input.BranchName
+	result.CommitSHA = commit.CommitSHA
+	result.TreeSHA = commit.TreeSHA
+	result.RemoteCommitSHA = remoteBranch.CommitSHA
+	result.Files = len(files)
+	result.Bytes = commit.Bytes
+	result.Duration = duration
+	return result, nil
+}
+
+// createBranch creates the local branch to upload: an orphan branch with all
+// files, or one that keeps the recent commits on top of the squashed history.
+func createBranch(ctx context.Context, git gateway.GitGateway, repo *entity.Repository, branch *entity.Branch, sourceBranch string, keep entity.KeepHistory) (*entity.CommitResult, error) {
+	if keep.IsZero() {
+		return git.CreateOrphanBranch(ctx, repo, branch, sourceBranch)
+	}
+	return git.CreateSquashedBranch(ctx, repo, branch, sourceBranch, keep)
+}
+
+// loadIgnoreRules builds the matcher of excluded files from the .squeezeignore
+// file of the repository and the given patterns.
+func loadIgnoreRules(repoPath string, excludes, includes []string) (*ignore.Matcher, error) {
+	matcher := ignore.New()
 
-		content, err := io.ReadAll(f)
+	ignoreFile, err := os.Open(filepath.Join(repoPath, ignore.FileName))
+	if err == nil {
+		err = matcher.Read(ignoreFile)
+		ignoreFile.Close()
 		if err != nil {
-			f.Close()
-			return 0, 0, err
+			return nil, fmt.Errorf("invalid %s: %w", ignore.FileName, err)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	for _, pattern := range excludes {
+		if err := matcher.AddExclude(pattern); err != nil {
+			return nil, err
+		}
+	}
+	for _, pattern := range includes {
+		if err := matcher.AddInclude(pattern); err != nil {
+			return nil, err
+		}
+	}
+	return matcher, nil
+}
+
+// excludeFiles leaves the files excluded by matcher out of the commits of
+// branch and returns the files that are left and the new last commit, which is
+// commit if nothing is excluded. Nothing is removed from the working tree.
+func excludeFiles(ctx context.Context, log logger.Logger, git gateway.GitGateway, repoPath, branch string, matcher *ignore.Matcher, files []string, commit *entity.CommitResult) ([]string, *entity.CommitResult, error) {
+	if matcher.Empty() {
+		return files, commit, nil
+	}
+
+	kept, excluded := matcher.Filter(files)
+	if len(excluded) > 0 {
+		log.Infof("Excluding %d of %d files from the upload", len(excluded), len(files))
+		for _, file := range excluded {
+			log.Debugf("Excluded %s", file)
 		}
-		f.Close()
+	}
+	if len(kept) == 0 {
+		return nil, commit, nil
+	}
+	// Older commits may hold excluded files that are gone from the last one,
+	// so every commit is checked, not only the excluded files listed here.
+	commit, err := git.ExcludeFiles(ctx, repoPath, branch, matcher.Excluded)
+	if err != nil {
+		return nil, nil, err
+	}
+	return kept, commit, nil
+}
+
+// commitFilesViaAPI uploads the files of the local branch through the GitLab
+// Commits API, split into as many commits as the batch limits require.
+func (uc *CreateAndPushOrphanBranchUseCase) commitFilesViaAPI(ctx context.Context, project *entity.Project, input Input, localBranch string, files []string) error {
+	// The files are read from the branch, not from the working tree, which may
+	// hold another branch or uncommitted changes.
+	contents, err := uc.GitGateway.ReadFiles(ctx, input.RepoPath, localBranch, files)
+	if err != nil {
+		return err
+	}
 
-		actions = append(actions, gateway.CommitAction{
-			Action:   "create",
-			FilePath: file,
-			Content:  string(content),
-			Encoding: "text",
-		})
+	// Prepare the file actions for the GitLab API commit.
+	var actions []gateway.CommitAction
+	for _, file := range contents {
+		actions = append(actions, gateway.NewCreateAction(file.Path, file.Content, file.Executable))
 	}
 
-	// Step 5: Commit the files via the GitLab API. This will be the second commit.
 	commitMessage := "Add project files to orphan branch " + input.BranchName
-	startTime := time.Now()
-	err = uc.GitLabGateway.CommitFilesViaAPI(
+	batcher := newCommitBatcher(uc.GitLabGateway, uc.logger, input.BatchMaxBytes, input.BatchMaxFiles)
+	_, err = batcher.upload(
+		ctx,
 		strconv.Itoa(project.ID),
 		input.BranchName,
 		commitMessage,
 		actions,
+		input.Resume,
 	)
+	return err
+}
+
+// pushBranch pushes the local orphan branch to the new project with git push.
+func (uc *CreateAndPushOrphanBranchUseCase) pushBranch(ctx context.Context, project *entity.Project, input Input, localBranch string) error {
+	remoteURL, err := remoteURL(project, input.PushProtocol)
 	if err != nil {
-		return 0, 0, err
+		return err
 	}
-	duration := time.Since(startTime)
+	return uc.GitGateway.PushBranch(ctx, input.RepoPath, remoteURL, localBranch, input.BranchName)
+}
 
-	return duration, len(files), nil
+// remoteURL returns the URL git pushes to a project with, HTTPS by default.
+func remoteURL(project *entity.Project, protocol PushProtocol) (string, error) {
+	url := project.HTTPURLToRepo
+	if protocol == PushProtocolSSH {
+		url = project.SSHURLToRepo
+	}
+	if url == "" {
+		if protocol == "" {
+			protocol = PushProtocolHTTPS
+		}
+		return "", fmt.Errorf("project %s has no %s url to push to", project.Name, protocol)
+	}
+	return url, nil
 }
@@ -1,13 +1,9 @@
 package usecase
 
 import (
-	"archive/zip"
-	"bytes"
 	"context"
-	"io"
+	"fmt"
 	"os"
-	"path/filepath"
-	"strings"
 	"time"
 
 	"github.com/olegshirko/reposqueeze/internal/domain/entity"
@@ -16,115 +12,139 @@ import (
 )
 
 type CreateOrphanBranchFromGitlabUseCase struct {
-	GitGateway    gateway.GitGateway
-	GitLabGateway gateway.GitLabGateway
-	logger        logger.Logger
+	GitGateway        gateway.GitGateway
+	GitLabGateway     gateway.GitLabGateway
+	ArchiveExtractors gateway.ArchiveExtractors
+	logger            logger.Logger
 }
 
 type CreateOrphanBranchFromGitlabInput struct {
 	RepoPath   string
 	BranchName string
+	Project    ProjectRef           // Project to download, the name of the repository directory by default
+	Ref        string               // Branch, tag or commit SHA to download, the default branch by default
+	Path       string               // Subdirectory to download, the whole repository by default
+	Format     entity.ArchiveFormat // Archive format to download, entity.ArchiveZip by default
 }
 
 func NewCreateOrphanBranchFromGitlabUseCase(
 	gitGateway gateway.GitGateway,
 	gitLabGateway gateway.GitLabGateway,
+	archiveExtractors gateway.ArchiveExtractors,
 	log logger.Logger,
 ) *CreateOrphanBranchFromGitlabUseCase {
 	return &CreateOrphanBranchFromGitlabUseCase{
-		GitGateway:    gitGateway,
-		GitLabGateway: gitLabGateway,
-		logger:        log,
+		GitGateway:        gitGateway,
+		GitLabGateway:     gitLabGateway,
+		ArchiveExtractors: archiveExtractors,
+		logger:            log,
 	}
 }
 
-func (uc *CreateOrphanBranchFromGitlabUseCase) Execute(ctx context.Context, input CreateOrphanBranchFromGitlabInput) (time.Duration, int, error) {
-	projectName := filepath.Base(strings.TrimSuffix(input.RepoPath, ".git"))
-	uc.logger.Info(projectName)
-	project, err := uc.GitLabGateway.FindProjectByName(projectName)
+func (uc *CreateOrphanBranchFromGitlabUseCase) Execute(ctx context.Context, input CreateOrphanBranchFromGitlabInput) (*Result, error) {
+	format := input.Format
+	if format == "" {
+		format = entity.ArchiveZip
+	}
+	extractor, ok := uc.ArchiveExtractors[format]
+	if !ok {
+		return nil, fmt.Errorf("unsupported archive format %q", format)
+	}
+
+	projectRef := projectRefFromRepoPath(input.Project, input.RepoPath, "")
+	uc.logger.Infof("Project: %s", projectRef)
+	project, err := findProject(ctx, uc.GitLabGateway, projectRef)
 	if err != nil {
-		return 0, 0, err
+		return nil, err
 	}
 	if project == nil {
-		uc.logger.Infof("project %s not found", projectName)
-		return 0, 0, nil
+		return nil, entity.NotFound("project %s not found", projectRef)
 	}
+	result := newResult(uc.logger)
 
+	// Remember the checkout, so a failed or cancelled run switches back to it
+	// instead of leaving the repository on the new orphan branch.
+	previous, err := uc.GitGateway.CurrentBranch(ctx, input.RepoPath)
+	if err != nil {
+		return nil, err
+	}
 	repo := &entity.Repository{Path: input.RepoPath}
 	branch := &entity.Branch{Name: input.BranchName}
 	if err := uc.GitGateway.CreateEmptyOrphanBranch(ctx, repo, branch, ""); err != nil {
-		return 0, 0, err
+		return nil, err
 	}
+	succeeded := false
+	defer func() {
+		if !succeeded {
+			uc.restoreCheckout(context.WithoutCancel(ctx), input.RepoPath, previous)
+		}
+	}()
 
-	if err = uc.GitGateway.CleanWorkdir(input.RepoPath); err != nil {
-		return 0, 0, err
+	if err = uc.GitGateway.CleanWorkdir(ctx, input.RepoPath); err != nil {
+		return nil, err
 	}
 
-	buffer := new(bytes.Buffer)
-	if err = uc.GitLabGateway.DownloadRepoArchive(project.ID, buffer); err != nil {
-		return 0, 0, err
+	// The archive is streamed to a temporary file, so its size is not limited by memory.
+	archiveFile, err := os.CreateTemp("", "reposqueeze-*."+string(format))
+	if err != nil {
+		return nil, err
 	}
+	defer func() {
+		archiveFile.Close()
+		if err := os.Remove(archiveFile.Name()); err != nil {
+			result.warnf("Warning: failed to remove temporary archive %s: %v", archiveFile.Name(), err)
+		}
+	}()
 
-	zipReader, err := zip.NewReader(bytes.NewReader(buffer.Bytes()), int64(buffer.Len()))
-	if err != nil {
-		return 0, 0, err
+	endPhase := result.startPhase("download")
+	options := gateway.ArchiveOptions{Ref: input.Ref, Path: input.Path, Format: format}
+	if err = uc.GitLabGateway.DownloadRepoArchive(ctx, project.ID, options, archiveFile); err != nil {
+		return nil, err
 	}
+	endPhase()
 
-	var filesCount int
-	for _, file := range zipReader.File {
-		if !strings.HasSuffix(file.Name, "/") {
-			filesCount++
-		}
+	archiveInfo, err := archiveFile.Stat()
+	if err != nil {
+		return nil, err
 	}
 
-	if len(zipReader.File) > 0 {
-		firstFile := zipReader.File[0]
-		rootDir := strings.Split(firstFile.Name, "/")[0] + "/"
-
-		for _, file := range zipReader.File {
-			if !strings.HasPrefix(file.Name, rootDir) {
-				continue
-			}
-			relativePath := strings.TrimPrefix(file.Name, rootDir)
-			if relativePath == "" {
-				continue
-			}
-
-			extractedFilePath := filepath.Join(input.RepoPath, relativePath)
-
-			if file.FileInfo().IsDir() {
-				os.MkdirAll(extractedFilePath, file.Mode())
-			} else {
-				zippedFile, err := file.Open()
-				if err != nil {
-					return 0, 0, err
-				}
-				defer zippedFile.Close()
-
-				outputFile, err := os.OpenFile(
-					extractedFilePath,
-					os.O_WRONLY|os.O_CREATE|os.O_TRUNC,
-					file.Mode(),
-				)
-				if err != nil {
-					return 0, 0, err
-				}
-				defer outputFile.Close()
-
-				_, err = io.Copy(outputFile, zippedFile)
-				if err != nil {
-					return 0, 0, err
-				}
-			}
-		}
+	endPhase = result.startPhase("extract")
+	summary, err := extractor.Extract(ctx, archiveFile, archiveInfo.Size(), input.RepoPath)
+	if err != nil {
+		return nil, err
 	}
+	endPhase()
 
 	commitMessage := "Add project files to orphan branch " + input.BranchName
+	endPhase = result.startPhase("commit")
 	startTime := time.Now()
-	if err = uc.GitGateway.Commit(input.RepoPath, commitMessage); err != nil {
-		return 0, 0, err
+	commit, err := uc.GitGateway.Commit(ctx, input.RepoPath, commitMessage)
+	if err != nil {
+		return nil, err
 	}
 	duration := time.Since(startTime)
+	endPhase()
+	succeeded = true
+
+	result.setProject(project)
+	result.Branch = input.BranchName
+	result.CommitSHA = commit.CommitSHA
+	result.TreeSHA = commit.TreeSHA
+	result.Files = summary.Files
+	result.Bytes = summary.Bytes
+	result.Duration = duration
+	return result, nil
+}
 
-	return duration, filesCount, nil
+// restoreCheckout switches the repository back to the branch or commit that
+// was checked out before and removes the extracted files.
+func (uc *CreateOrphanBranchFromGitlabUseCase) restoreCheckout(ctx context.Context, repoPath, previous string) {
+	uc.logger.Warnf("Switching %s back to %s", repoPath, previous)
+	if err := uc.GitGateway.Checkout(ctx, repoPath, previous); err != nil {
+		uc.logger.Errorf("Failed to switch back to %s, check out a branch manually: %v", previous, err)
+		return
+	}
+	if err := uc.GitGateway.CleanWorkdir(ctx, repoPath); err != nil {
+		uc.logger.Warnf("Warning: failed to remove the extracted files from %s: %v", repoPath, err)
+	}
 }
@@ -0,0 +1,73 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/olegshirko/reposqueeze/internal/domain/entity"
+	"github.com/olegshirko/reposqueeze/internal/domain/gateway"
+)
+
+// ProjectRef identifies a GitLab project. The first field that is set is used:
+// ID, then Path, then Name.
+type ProjectRef struct {
+	ID   int    // Numeric project ID
+	Path string // Full path with namespace, e.g. group/subgroup/project
+	Name string // Project name, searched among the projects owned by the user
+}
+
+// projectRefFromRepoPath falls back to the name of the repository directory
+// when ref identifies nothing. A name or a path without a namespace is looked
+// up in namespace if it is set, or among the projects owned by the user.
+func projectRefFromRepoPath(ref ProjectRef, repoPath, namespace string) ProjectRef {
+	if ref.ID != 0 {
+		return ref
+	}
+	if ref.Path == "" && ref.Name == "" {
+		ref.Name = filepath.Base(strings.TrimSuffix(filepath.Clean(repoPath), ".git"))
+	}
+	if ref.Path != "" && !strings.Contains(strings.Trim(ref.Path, "/"), "/") {
+		ref.Name, ref.Path = strings.Trim(ref.Path, "/"), ""
+	}
+	if ref.Path == "" && namespace != "" {
+		ref.Path = strings.Trim(namespace, "/") + "/" + ref.Name
+		ref.Name = ""
+	}
+	return ref
+}
+
+// String returns the identifier used by the reference, for messages.
+func (r ProjectRef) String() string {
+	switch {
+	case r.ID != 0:
+		return strconv.Itoa(r.ID)
+	case r.Path != "":
+		return strings.Trim(r.Path, "/")
+	}
+	return r.Name
+}
+
+// name returns the name of a project created for the reference, if it can be told without GitLab.
+func (r ProjectRef) name() string {
+	if r.Path != "" {
+		return path.Base(strings.Trim(r.Path, "/"))
+	}
+	return r.Name
+}
+
+// findProject returns the referenced project, or nil if it does not exist.
+func findProject(ctx context.Context, gitLab gateway.GitLabGateway, ref ProjectRef) (*entity.Project, error) {
+	switch {
+	case ref.ID != 0:
+		return gitLab.GetProject(ctx, ref.ID)
+	case ref.Path != "":
+		return gitLab.GetProjectByPath(ctx, ref.Path)
+	case ref.Name != "":
+		return gitLab.FindProjectByName(ctx, ref.Name)
+	}
+	return nil, fmt.Errorf("no project given")
+}
@@ -0,0 +1,74 @@
+package usecase
+
+import (
+	"context"
+	"testing"
+
+	"github.com/olegshirko/reposqueeze/internal/testutil"
+)
+
+func TestProjectRefFromRepoPath(t *testing.T) {
+	tests := []struct {
+		name      string
+		ref       ProjectRef
+		repoPath  string
+		namespace string
+		want      ProjectRef
+	}{
+		{"id", ProjectRef{ID: 5, Name: "lib"}, "/src/app", "team", ProjectRef{ID: 5, Name: "lib"}},
+		{"full path", ProjectRef{Path: "group/lib"}, "/src/app", "team", ProjectRef{Path: "group/lib"}},
+		{"name", ProjectRef{Name: "lib"}, "/src/app", "", ProjectRef{Name: "lib"}},
+		{"name in namespace", ProjectRef{Name: "lib"}, "/src/app", "team", ProjectRef{Path: "team/lib"}},
+		{"path without namespace", ProjectRef{Path: "/lib/"}, "/src/app", "", ProjectRef{Name: "lib"}},
+		{"path without namespace in namespace", ProjectRef{Path: "lib"}, "/src/app", "team/", ProjectRef{Path: "team/lib"}},
+		{"repository directory", ProjectRef{}, "/src/app/", "", ProjectRef{Name: "app"}},
+		{"bare repository", ProjectRef{}, "/src/app.git", "", ProjectRef{Name: "app"}},
+		{"repository directory in namespace", ProjectRef{}, "/src/app", "/team/sub/", ProjectRef{Path: "team/sub/app"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := projectRefFromRepoPath(tt.ref, tt.repoPath, tt.namespace); got != tt.want {
+				t.Errorf("projectRefFromRepoPath(%+v, %s, %s) = %+v, want %+v", tt.ref, tt.repoPath, tt.namespace, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFindProject(t *testing.T) {
+	gitLab := testutil.NewFakeGitLab()
+	gitLab.AddProject(1, "app")
+	gitLab.AddProject(2, "lib").PathWithNamespace = "other/lib"
+	gitLab.AddProject(3, "tool")
+
+	// Each field names another project, so the result tells which one was used.
+	tests := []struct {
+		name    string
+		ref     ProjectRef
+		wantID  int // 0 if no project is found
+		wantErr bool
+	}{
+		{name: "id first", ref: ProjectRef{ID: 1, Path: "other/lib", Name: "tool"}, wantID: 1},
+		{name: "path before name", ref: ProjectRef{Path: "/other/lib/", Name: "tool"}, wantID: 2},
+		{name: "name", ref: ProjectRef{Name: "tool"}, wantID: 3},
+		{name: "missing id", ref: ProjectRef{ID: 9, Name: "tool"}},
+		{name: "missing path", ref: ProjectRef{Path: "group/lib", Name: "tool"}},
+		{name: "repository directory", ref: projectRefFromRepoPath(ProjectRef{}, "/src/app", ""), wantID: 1},
+		{name: "repository directory in namespace", ref: projectRefFromRepoPath(ProjectRef{}, "/src/lib", "other"), wantID: 2},
+		{name: "nothing", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			project, err := findProject(context.Background(), gitLab, tt.ref)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("findProject(%+v) error = %v, want error %t", tt.ref, err, tt.wantErr)
+			}
+			gotID := 0
+			if project != nil {
+				gotID = project.ID
+			}
+			if gotID != tt.wantID {
+				t.Errorf("findProject(%+v) found project %d, want %d", tt.ref, gotID, tt.wantID)
+			}
+		})
+	}
+}
@@ -0,0 +1,374 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/olegshirko/reposqueeze/internal/domain/entity"
+	"github.com/olegshirko/reposqueeze/internal/domain/gateway"
+	"github.com/olegshirko/reposqueeze/internal/pkg/logger"
+)
+
+// ReplaceMode defines what happens to an existing GitLab project with the same name.
+type ReplaceMode string
+
+const (
+	// ReplaceModeBackup renames the existing project to a timestamped backup and
+	// removes the backup only after the new project has been pushed and verified.
+	ReplaceModeBackup ReplaceMode = "backup"
+	// ReplaceModeKeepBackup works like ReplaceModeBackup but never removes the backup.
+	ReplaceModeKeepBackup ReplaceMode = "keep-backup"
+	// ReplaceModeDelete deletes the existing project before creating the new one.
+	// Nothing can be restored if a later step fails.
+	ReplaceModeDelete ReplaceMode = "delete"
+)
+
+// Valid reports whether m is a known replace mode.
+func (m ReplaceMode) Valid() bool {
+	switch m {
+	case ReplaceModeBackup, ReplaceModeKeepBackup, ReplaceModeDelete:
+		return true
+	}
+	return false
+}
+
+// Visibility is the visibility level of a GitLab project.
+type Visibility string
+
+const (
+	// VisibilityPrivate grants access to project members only.
+	VisibilityPrivate Visibility = "private"
+	// VisibilityInternal grants access to any signed-in user.
+	VisibilityInternal Visibility = "internal"
+	// VisibilityPublic grants access to everyone.
+	VisibilityPublic Visibility = "public"
+)
+
+// Valid reports whether v is a known visibility level.
+func (v Visibility) Valid() bool {
+	return v == VisibilityPrivate || v == VisibilityInternal || v == VisibilityPublic
+}
+
+// ProjectOptions are the settings of the new project. Empty fields are taken
+// from the replaced project if there is one, or left to GitLab.
+type ProjectOptions struct {
+	Namespace     string // Full path of the group or user namespace, e.g. group/subgroup
+	Path          string // URL slug of the project
+	Visibility    Visibility
+	Description   string
+	DefaultBranch string
+}
+
+// projectReplacement replaces a GitLab project in steps that can be rolled back:
+// Begin moves the old project aside and creates the new one, Finish removes the
+// backup once the new project is verified, Rollback restores the old project.
+// Resume takes over a new project whose upload a failed run has kept.
+type projectReplacement struct {
+	gitLab  gateway.GitLabGateway
+	logger  logger.Logger
+	mode    ReplaceMode
+	ref     ProjectRef
+	options ProjectOptions
+	name    string // name of the new project, known after Begin has looked up the old one
+
+	original *entity.Project         // old project as it was before the replacement
+	settings *entity.ProjectSettings // settings of the old project, restored by Finish
+	backup   *entity.Project         // old project after it was renamed
+	created  *entity.Project         // new project
+
+	// Failures lists the settings that could not be migrated to the new project, known after Finish.
+	Failures []entity.SettingFailure
+}
+
+func newProjectReplacement(gitLab gateway.GitLabGateway, log logger.Logger, mode ReplaceMode, ref ProjectRef, options ProjectOptions) *projectReplacement {
+	if mode == "" {
+		mode = ReplaceModeBackup
+	}
+	return &projectReplacement{
+		gitLab:  gitLab,
+		logger:  log,
+		mode:    mode,
+		ref:     ref,
+		options: options,
+	}
+}
+
+// Begin moves an existing project out of the way and creates a new one.
+// If Begin fails, everything it has done is already rolled back.
+func (r *projectReplacement) Begin(ctx context.Context) (*entity.Project, error) {
+	if !r.mode.Valid() {
+		return nil, fmt.Errorf("unknown replace mode %q", r.mode)
+	}
+
+	existing, err := findProject(ctx, r.gitLab, r.ref)
+	if err != nil {
+		return nil, err
+	}
+
+	r.name = r.ref.name()
+	if existing == nil && r.name == "" {
+		// A project ID does not tell the name of a project to create.
+		return nil, entity.NotFound("project %s not found", r.ref)
+	}
+	if existing != nil {
+		r.name = existing.Name
+	}
+
+	// Resolve the settings before anything is changed, so a wrong namespace fails early.
+	createOptions, err := r.createOptions(ctx, existing)
+	if err != nil {
+		return nil, err
+	}
+
+	if existing != nil {
+		// The settings are read before the project is touched, so they can be
+		// restored even when it is deleted without a backup.
+		r.settings, err = r.gitLab.SnapshotProjectSettings(ctx, existing.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the settings of project %s: %w", r.name, err)
+		}
+		r.logger.Infof("Saved settings of project %s: %d members, %d variables, %d protected branches, %d webhooks, %d labels",
+			r.name, len(r.settings.Members), len(r.settings.Variables), len(r.settings.ProtectedBranches), len(r.settings.Hooks), len(r.settings.Labels))
+
+		r.original = existing
+		if r.mode == ReplaceModeDelete {
+			r.logger.Warnf("Deleting existing project %s (id %d) without a backup", r.name, existing.ID)
+			if err := r.gitLab.DeleteProject(ctx, existing.ID); err != nil {
+				return nil, err
+			}
+		} else {
+			if err := r.moveToBackup(ctx, existing); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	created, err := r.gitLab.CreateProject(ctx, createOptions)
+	if err != nil {
+		r.Rollback(ctx)
+		return nil, err
+	}
+	r.created = created
+	r.logger.Infof("Created project %s (id %d)", describeProject(created), created.ID)
+
+	return created, nil
+}
+
+// Resume picks up a replacement whose upload has failed part way and was kept:
+// the new project has the name of the old one already, and the old project is
+// the backup with ID backupID, or gone if backupID is 0. The settings are read
+// again from the backup, so Finish restores them and removes the backup.
+func (r *projectReplacement) Resume(ctx context.Context, backupID int) (*entity.Project, error) {
+	project, err := findProject(ctx, r.gitLab, r.ref)
+	if err != nil {
+		return nil, err
+	}
+	if project == nil {
+		return nil, entity.NotFound("project %s not found, there is no upload to resume", r.ref)
+	}
+	r.name = project.Name
+	r.created = project
+	r.logger.Infof("Resuming the upload into project %s (id %d)", describeProject(project), project.ID)
+
+	if backupID == 0 {
+		r.logger.Warnf("No backup project given, the settings of the replaced project are not restored")
+		return project, nil
+	}
+	backup, err := r.gitLab.GetProject(ctx, backupID)
+	if err != nil {
+		return nil, err
+	}
+	if backup == nil {
+		return nil, entity.NotFound("backup project %d not found", backupID)
+	}
+	r.settings, err = r.gitLab.SnapshotProjectSettings(ctx, backup.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the settings of backup project %s: %w", backup.Name, err)
+	}
+	r.original = &entity.Project{ID: backup.ID, Name: project.Name, Path: project.Path}
+	r.backup = backup
+	return project, nil
+}
+
+// resumeHint tells how to continue an upload into the new project that has failed part way.
+func (r *projectReplacement) resumeHint() string {
+	hint := fmt.Sprintf("project %s (id %d) keeps the committed parts, rerun with --resume to upload the rest",
+		describeProject(r.created), r.created.ID)
+	if r.backup != nil {
+		hint += fmt.Sprintf(" and --backup-project-id %d to finish the replacement of backup %s", r.backup.ID, r.backup.Name)
+	} else if r.original != nil {
+		hint += fmt.Sprintf("; the settings of the deleted project %s are not restored by the rerun", r.original.Name)
+	}
+	return hint
+}
+
+// createOptions merges the options with the settings of the existing project.
+// The namespace comes from the project path, then the options, then the existing project.
+func (r *projectReplacement) createOptions(ctx context.Context, existing *entity.Project) (gateway.CreateProjectOptions, error) {
+	options := gateway.CreateProjectOptions{
+		Name:          r.name,
+		Path:          r.options.Path,
+		Visibility:    string(r.options.Visibility),
+		Description:   r.options.Description,
+		DefaultBranch: r.options.DefaultBranch,
+	}
+
+	namespacePath := r.options.Namespace
+	if dir := path.Dir(r.ref.Path); r.ref.Path != "" && dir != "." {
+		namespacePath = dir
+		if options.Path == "" {
+			options.Path = path.Base(r.ref.Path)
+		}
+	}
+	if existing != nil {
+		if namespacePath == "" {
+			namespacePath = existing.Namespace.FullPath
+		}
+		if options.Path == "" {
+			options.Path = existing.Path
+		}
+		if options.Visibility == "" {
+			options.Visibility = existing.Visibility
+		}
+		if options.Description == "" {
+			options.Description = existing.Description
+		}
+	}
+
+	if namespacePath == "" {
+		return options, nil
+	}
+	if existing != nil && existing.Namespace.ID != 0 && existing.Namespace.FullPath == namespacePath {
+		options.NamespaceID = existing.Namespace.ID
+		return options, nil
+	}
+	namespace, err := r.gitLab.GetNamespace(ctx, namespacePath)
+	if err != nil {
+		return options, err
+	}
+	if namespace == nil {
+		return options, entity.NotFound("namespace %s not found", namespacePath)
+	}
+	options.NamespaceID = namespace.ID
+	return options, nil
+}
+
+// describeProject returns the full path of a project if GitLab has reported it, or its name.
+func describeProject(project *entity.Project) string {
+	if project.PathWithNamespace != "" {
+		return project.PathWithNamespace
+	}
+	return project.Name
+}
+
+func (r *projectReplacement) moveToBackup(ctx context.Context, project *entity.Project) error {
+	path := project.Path
+	if path == "" {
+		path = project.Name
+	}
+	suffix := "-backup-" + time.Now().UTC().Format("20060102-150405")
+
+	backup, err := r.gitLab.RenameProject(ctx, project.ID, project.Name+suffix, path+suffix)
+	if err != nil {
+		return fmt.Errorf("failed to move project %s to a backup: %w", project.Name, err)
+	}
+	r.backup = backup
+	r.logger.Infof("Moved existing project %s (id %d) to backup %s", project.Name, project.ID, backup.Name)
+	return nil
+}
+
+// Finish restores the settings of the old project on the new one and removes
+// the backup unless the mode keeps it. The settings are restored only now, so
+// protected branches cannot block the upload. A failure here does not affect
+// the new project, so it is only reported; the backup is kept if some settings
+// could not be migrated and can still be copied from it. The problems are
+// added to the warnings of result.
+func (r *projectReplacement) Finish(ctx context.Context, result *Result) {
+	r.restoreSettings(ctx)
+	for _, failure := range r.Failures {
+		result.Warnings = append(result.Warnings, "setting not migrated: "+failure.String())
+	}
+
+	if r.backup == nil {
+		return
+	}
+	if r.hasCopyableFailures() {
+		result.warnf("Keeping backup project %s (id %d), because some settings could not be migrated", r.backup.Name, r.backup.ID)
+		return
+	}
+	if r.mode == ReplaceModeKeepBackup {
+		r.logger.Infof("Keeping backup project %s (id %d)", r.backup.Name, r.backup.ID)
+		return
+	}
+	if err := r.gitLab.DeleteProject(ctx, r.backup.ID); err != nil {
+		result.warnf("Warning: failed to delete backup project %s (id %d), remove it manually: %v", r.backup.Name, r.backup.ID, err)
+		return
+	}
+	r.logger.Infof("Deleted backup project %s (id %d)", r.backup.Name, r.backup.ID)
+}
+
+// restoreSettings applies the saved settings to the new project and reports the ones that could not be migrated.
+func (r *projectReplacement) restoreSettings(ctx context.Context) {
+	if r.settings == nil || r.created == nil {
+		return
+	}
+
+	r.Failures = append(r.Failures, r.settings.Failures...)
+	failures, err := r.gitLab.RestoreProjectSettings(ctx, r.created.ID, r.settings)
+	if err != nil {
+		failures = append(failures, entity.SettingFailure{Setting: "settings", Reason: err.Error()})
+	}
+	r.Failures = append(r.Failures, failures...)
+
+	if len(r.Failures) == 0 {
+		r.logger.Infof("Restored the settings of project %s", r.name)
+		return
+	}
+	r.logger.Warnf("Settings that could not be migrated to project %s:", r.name)
+	for _, failure := range r.Failures {
+		r.logger.Warnf("  %s", failure)
+	}
+}
+
+// hasCopyableFailures reports whether a setting failed that can still be copied from the backup by hand.
+func (r *projectReplacement) hasCopyableFailures() bool {
+	for _, failure := range r.Failures {
+		if !failure.Unreadable {
+			return true
+		}
+	}
+	return false
+}
+
+// Rollback deletes the new project and gives the backup its original name back.
+// It runs even if ctx is cancelled, because it undoes a cancelled replacement.
+func (r *projectReplacement) Rollback(ctx context.Context) {
+	ctx = context.WithoutCancel(ctx)
+	if r.created != nil {
+		r.logger.Warnf("Rolling back: deleting new project %s (id %d)", r.created.Name, r.created.ID)
+		if err := r.gitLab.DeleteProject(ctx, r.created.ID); err != nil {
+			r.logger.Errorf("Rollback: failed to delete new project %s (id %d): %v", r.created.Name, r.created.ID, err)
+		}
+		r.created = nil
+	}
+
+	if r.backup != nil {
+		r.logger.Warnf("Rolling back: restoring project %s from backup %s", r.original.Name, r.backup.Name)
+		path := r.original.Path
+		if path == "" {
+			path = r.original.Name
+		}
+		if _, err := r.gitLab.RenameProject(ctx, r.backup.ID, r.original.Name, path); err != nil {
+			r.logger.Errorf("Rollback: failed to restore project %s, the old project is kept as %s (id %d): %v",
+				r.original.Name, r.backup.Name, r.backup.ID, err)
+			return
+		}
+		r.backup = nil
+	}
+
+	if r.original != nil && r.mode == ReplaceModeDelete {
+		r.logger.Errorf("Rollback: project %s (id %d) was deleted and cannot be restored", r.original.Name, r.original.ID)
+	}
+}
@@ -0,0 +1,139 @@
+package usecase
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/olegshirko/reposqueeze/internal/pkg/logger"
+	"github.com/olegshirko/reposqueeze/internal/testutil"
+)
+
+// projectNames returns the names of the projects of gitLab, sorted.
+func projectNames(gitLab *testutil.FakeGitLab) []string {
+	var names []string
+	for _, project := range gitLab.Projects {
+		names = append(names, project.Name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+func TestProjectReplacementCreateFails(t *testing.T) {
+	gitLab := testutil.NewFakeGitLab()
+	gitLab.AddProject(1, "app")
+	gitLab.Fail = func(call string) error {
+		if strings.HasPrefix(call, "create project") {
+			return errors.New("project limit reached")
+		}
+		return nil
+	}
+	r := newProjectReplacement(gitLab, testutil.Logger(), ReplaceModeBackup, ProjectRef{Name: "app"}, ProjectOptions{})
+
+	if _, err := r.Begin(context.Background()); err == nil || !strings.Contains(err.Error(), "project limit reached") {
+		t.Fatalf("Begin error = %v, want the error of CreateProject", err)
+	}
+	if !gitLab.Called("rename project 1 to app-backup-") {
+		t.Errorf("project was not moved to a backup first: %v", gitLab.Calls)
+	}
+	if names := projectNames(gitLab); !slices.Equal(names, []string{"app"}) {
+		t.Errorf("projects after the failure are %v, want the original [app]", names)
+	}
+	if gitLab.Projects[1].Path != "app" {
+		t.Errorf("original project has path %s, want app", gitLab.Projects[1].Path)
+	}
+	if gitLab.Called("delete project") {
+		t.Errorf("a project was deleted: %v", gitLab.Calls)
+	}
+}
+
+func TestProjectReplacementRollbackAfterCancel(t *testing.T) {
+	gitLab := testutil.NewFakeGitLab()
+	gitLab.AddProject(1, "app")
+	r := newProjectReplacement(gitLab, testutil.Logger(), ReplaceModeBackup, ProjectRef{Name: "app"}, ProjectOptions{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	created, err := r.Begin(ctx)
+	if err != nil {
+		t.Fatalf("Begin: %v", err)
+	}
+	cancel()
+	r.Rollback(ctx)
+
+	if gitLab.Projects[created.ID] != nil {
+		t.Errorf("new project %d was not deleted", created.ID)
+	}
+	if names := projectNames(gitLab); !slices.Equal(names, []string{"app"}) {
+		t.Errorf("projects after the rollback are %v, want the original [app]", names)
+	}
+	if gitLab.Projects[1] == nil || gitLab.Projects[1].Name != "app" {
+		t.Errorf("backup was not renamed back: %v", gitLab.Calls)
+	}
+}
+
+func TestProjectReplacementFinish(t *testing.T) {
+	tests := []struct {
+		mode       ReplaceMode
+		wantBackup bool
+	}{
+		{ReplaceModeBackup, false},
+		{ReplaceModeKeepBackup, true},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.mode), func(t *testing.T) {
+			gitLab := testutil.NewFakeGitLab()
+			gitLab.AddProject(1, "app")
+			r := newProjectReplacement(gitLab, testutil.Logger(), tt.mode, ProjectRef{Name: "app"}, ProjectOptions{})
+
+			created, err := r.Begin(context.Background())
+			if err != nil {
+				t.Fatalf("Begin: %v", err)
+			}
+			result := newResult(testutil.Logger())
+			r.Finish(context.Background(), result)
+
+			if gitLab.Projects[created.ID] == nil || gitLab.Projects[created.ID].Name != "app" {
+				t.Errorf("new project app is gone: %v", gitLab.Calls)
+			}
+			if !gitLab.Called("restore settings of ") {
+				t.Errorf("settings were not restored: %v", gitLab.Calls)
+			}
+			backup := gitLab.Projects[1]
+			if tt.wantBackup && (backup == nil || !strings.HasPrefix(backup.Name, "app-backup-")) {
+				t.Errorf("backup was not kept: %v", gitLab.Calls)
+			}
+			if !tt.wantBackup && backup != nil {
+				t.Errorf("backup %s was not deleted", backup.Name)
+			}
+			if len(result.Warnings) > 0 {
+				t.Errorf("warnings: %v", result.Warnings)
+			}
+		})
+	}
+}
+
+func TestProjectReplacementDeleteModeCannotRestore(t *testing.T) {
+	gitLab := testutil.NewFakeGitLab()
+	gitLab.AddProject(1, "app")
+	var log bytes.Buffer
+	r := newProjectReplacement(gitLab, logger.NewLoggerWithWriter(&log), ReplaceModeDelete, ProjectRef{Name: "app"}, ProjectOptions{})
+
+	created, err := r.Begin(context.Background())
+	if err != nil {
+		t.Fatalf("Begin: %v", err)
+	}
+	if gitLab.Projects[1] != nil || gitLab.Called("rename project") {
+		t.Errorf("original project was not deleted without a backup: %v", gitLab.Calls)
+	}
+
+	r.Rollback(context.Background())
+	if gitLab.Projects[created.ID] != nil {
+		t.Errorf("new project %d was not deleted", created.ID)
+	}
+	if !strings.Contains(log.String(), "project app (id 1) was deleted and cannot be restored") {
+		t.Errorf("rollback did not report the lost project, log:\n%s", log.String())
+	}
+}
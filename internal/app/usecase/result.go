@@ -0,0 +1,68 @@
+package usecase
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/olegshirko/reposqueeze/internal/domain/entity"
+	"github.com/olegshirko/reposqueeze/internal/pkg/logger"
+)
+
+// Result describes what a use case has done, for the final report.
+type Result struct {
+	Project    string // Path of the GitLab project
+	ProjectID  int
+	ProjectURL string // Web URL of the GitLab project
+	Branch     string // Branch created or replaced
+
+	// CommitSHA and TreeSHA identify the last commit built locally.
+	CommitSHA string
+	TreeSHA   string
+	// RemoteCommitSHA is the head of the branch in GitLab. It differs from
+	// CommitSHA when the files are uploaded through the Commits API.
+	RemoteCommitSHA string
+
+	Files    int           // Files committed
+	Bytes    int64         // Total size of the files
+	Duration time.Duration // Time spent uploading or committing the files
+
+	Phases   []Phase  // Steps of the use case in the order they ran
+	Warnings []string // Problems that did not stop the use case
+
+	logger logger.Logger
+}
+
+// Phase is a step of a use case and the time it took.
+type Phase struct {
+	Name     string
+	Duration time.Duration
+}
+
+// newResult creates an empty Result that logs its warnings to log.
+func newResult(log logger.Logger) *Result {
+	return &Result{logger: log}
+}
+
+// setProject fills in the project the use case works on.
+func (r *Result) setProject(project *entity.Project) {
+	r.Project = project.PathWithNamespace
+	r.ProjectID = project.ID
+	r.ProjectURL = project.WebURL
+}
+
+// startPhase starts timing the phase name. The returned function ends it.
+func (r *Result) startPhase(name string) func() {
+	start := time.Now()
+	return func() {
+		r.Phases = append(r.Phases, Phase{Name: name, Duration: time.Since(start)})
+	}
+}
+
+// warnf logs a warning and keeps it for the report.
+func (r *Result) warnf(format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	r.logger.Warn(message)
+	// The log marks some warnings as such; the report lists only warnings.
+	r.Warnings = append(r.Warnings, strings.TrimPrefix(message, "Warning: "))
+}
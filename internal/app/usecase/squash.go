@@ -0,0 +1,269 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/olegshirko/reposqueeze/internal/domain/entity"
+	"github.com/olegshirko/reposqueeze/internal/domain/gateway"
+	"github.com/olegshirko/reposqueeze/internal/pkg/logger"
+)
+
+// SquashUseCase replaces the history of a branch of an existing GitLab project
+// with a single commit. Unlike CreateAndPushOrphanBranchUseCase it keeps the
+// project, so its ID, issues, merge requests and settings stay intact.
+type SquashUseCase struct {
+	GitGateway    gateway.GitGateway
+	GitLabGateway gateway.GitLabGateway
+	logger        logger.Logger
+}
+
+// SquashInput represents the input data for SquashUseCase.
+type SquashInput struct {
+	RepoPath     string
+	Project      ProjectRef   // Project to squash, the name of the repository directory by default
+	Branch       string       // Remote branch to replace, the default branch of the project by default
+	SourceBranch string       // Local branch with the files, Branch by default
+	PushProtocol PushProtocol // PushProtocolHTTPS by default
+
+	// DeleteBranches and DeleteTags remove every other branch and every tag of
+	// the project, so no ref keeps the old history alive.
+	DeleteBranches bool
+	DeleteTags     bool
+
+	// Gitignore-style patterns of files to leave out, see Input.
+	Excludes []string
+	Includes []string
+
+	// KeepHistory keeps the recent commits on top of the squashed history.
+	KeepHistory entity.KeepHistory
+}
+
+// NewSquashUseCase creates a new instance of SquashUseCase.
+func NewSquashUseCase(gitGateway gateway.GitGateway, gitLabGateway gateway.GitLabGateway, log logger.Logger) *SquashUseCase {
+	return &SquashUseCase{
+		GitGateway:    gitGateway,
+		GitLabGateway: gitLabGateway,
+		logger:        log,
+	}
+}
+
+// Execute builds an orphan branch locally and force-pushes it over the branch
+// of the project. Protection rules that cover the branch are removed for the
+// push and restored afterwards, also when the push fails.
+func (uc *SquashUseCase) Execute(ctx context.Context, input SquashInput) (*Result, error) {
+	projectRef := projectRefFromRepoPath(input.Project, input.RepoPath, "")
+	project, err := findProject(ctx, uc.GitLabGateway, projectRef)
+	if err != nil {
+		return nil, err
+	}
+	if project == nil {
+		return nil, entity.NotFound("project %s not found", projectRef)
+	}
+
+	targetBranch := input.Branch
+	if targetBranch == "" {
+		targetBranch = project.DefaultBranch
+	}
+	if targetBranch == "" {
+		return nil, fmt.Errorf("project %s has no default branch, use --branch", describeProject(project))
+	}
+	sourceBranch := input.SourceBranch
+	if sourceBranch == "" {
+		sourceBranch = targetBranch
+	}
+	remoteURL, err := remoteURL(project, input.PushProtocol)
+	if err != nil {
+		return nil, err
+	}
+	matcher, err := loadIgnoreRules(input.RepoPath, input.Excludes, input.Includes)
+	if err != nil {
+		return nil, err
+	}
+	uc.logger.Infof("Squashing branch %s of project %s (id %d) to the files of local branch %s",
+		targetBranch, describeProject(project), project.ID, sourceBranch)
+	result := newResult(uc.logger)
+
+	// Step 1: Build the orphan branch locally under a name that cannot clash with the source.
+	endPhase := result.startPhase("build")
+	repo := &entity.Repository{Path: input.RepoPath}
+	localBranch := &entity.Branch{Name: "reposqueeze-squash-" + time.Now().UTC().Format("20060102-150405")}
+	commit, err := createBranch(ctx, uc.GitGateway, repo, localBranch, sourceBranch, input.KeepHistory)
+	if err != nil {
+		return nil, err
+	}
+	// Cleanup runs even if ctx is cancelled.
+	cleanupCtx := context.WithoutCancel(ctx)
+	defer func() {
+		if err := uc.GitGateway.DeleteLocalBranch(cleanupCtx, input.RepoPath, localBranch.Name); err != nil {
+			result.warnf("Warning: failed to clean up local orphan branch %s: %v", localBranch.Name, err)
+		}
+	}()
+
+	files, err := uc.GitGateway.ListFiles(ctx, input.RepoPath, localBranch.Name)
+	if err != nil {
+		return nil, err
+	}
+	files, commit, err = excludeFiles(ctx, uc.logger, uc.GitGateway, input.RepoPath, localBranch.Name, matcher, files, commit)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no files to push in %s", input.RepoPath)
+	}
+	endPhase()
+
+	// Step 2: Lift the protection of the branch for the force-push.
+	protections, err := uc.unprotect(ctx, result, project.ID, targetBranch)
+	reprotected := false
+	reprotect := func() {
+		if reprotected {
+			return
+		}
+		reprotected = true
+		uc.reprotect(cleanupCtx, result, project.ID, protections)
+	}
+	defer reprotect()
+	if err != nil {
+		return nil, err
+	}
+
+	// Step 3: Replace the branch.
+	endPhase = result.startPhase("push")
+	startTime := time.Now()
+	if err := uc.GitGateway.PushBranch(ctx, input.RepoPath, remoteURL, localBranch.Name, targetBranch); err != nil {
+		return nil, err
+	}
+	duration := time.Since(startTime)
+	reprotect()
+	endPhase()
+
+	endPhase = result.startPhase("verify")
+	remoteBranch, err := uc.GitLabGateway.GetBranch(ctx, strconv.Itoa(project.ID), targetBranch)
+	if err != nil {
+		return nil, err
+	}
+	if remoteBranch == nil {
+		return nil, fmt.Errorf("branch %s was not found in project %s after push", targetBranch, describeProject(project))
+	}
+	if remoteBranch.CommitSHA != commit.CommitSHA {
+		return nil, fmt.Errorf("branch %s of project %s is at %s instead of the pushed commit %s",
+			targetBranch, describeProject(project), remoteBranch.CommitSHA, commit.CommitSHA)
+	}
+	uc.logger.Infof("Branch %s of project %s is now at %s", targetBranch, describeProject(project), remoteBranch.CommitSHA)
+	endPhase()
+
+	// Step 4: Drop the refs that still point to the old history.
+	if input.DeleteBranches || input.DeleteTags {
+		endPhase = result.startPhase("delete_refs")
+		if input.DeleteBranches {
+			uc.deleteBranches(ctx, result, project.ID, targetBranch)
+		}
+		if input.DeleteTags {
+			uc.deleteTags(ctx, result, project.ID)
+		}
+		endPhase()
+	}
+
+	result.setProject(project)
+	result.Branch = targetBranch
+	result.CommitSHA = commit.CommitSHA
+	result.TreeSHA = commit.TreeSHA
+	result.RemoteCommitSHA = remoteBranch.CommitSHA
+	result.Files = len(files)
+	result.Bytes = commit.Bytes
+	result.Duration = duration
+	return result, nil
+}
+
+// unprotect removes the protection rules that cover branch and returns them
+// for reprotect. On error, the rules removed so far are returned as well.
+func (uc *SquashUseCase) unprotect(ctx context.Context, result *Result, projectID int, branch string) ([]entity.ProtectedBranch, error) {
+	rules, err := uc.GitLabGateway.ListProtectedBranches(ctx, projectID)
+	if err != nil {
+		return nil, err
+	}
+
+	var removed []entity.ProtectedBranch
+	for _, rule := range rules {
+		if !protectionMatches(rule.Name, branch) {
+			continue
+		}
+		if rule.Name != branch {
+			result.warnf("Rule %s also protects other branches, they are unprotected until the push is done", rule.Name)
+		}
+		if err := uc.GitLabGateway.UnprotectBranch(ctx, projectID, rule.Name); err != nil {
+			return removed, fmt.Errorf("failed to unprotect branch %s: %w", rule.Name, err)
+		}
+		uc.logger.Infof("Unprotected branch %s for the push", rule.Name)
+		removed = append(removed, rule)
+	}
+	return removed, nil
+}
+
+// reprotect restores protection rules. A rule that cannot be restored is only
+// reported, because the branch has been replaced already.
+func (uc *SquashUseCase) reprotect(ctx context.Context, result *Result, projectID int, rules []entity.ProtectedBranch) {
+	for _, rule := range rules {
+		if err := uc.GitLabGateway.ProtectBranch(ctx, projectID, rule); err != nil {
+			uc.logger.Errorf("Branch %s is left unprotected, protect it again manually: %v", rule.Name, err)
+			result.Warnings = append(result.Warnings, fmt.Sprintf("branch %s is left unprotected: %v", rule.Name, err))
+			continue
+		}
+		uc.logger.Infof("Protected branch %s again", rule.Name)
+	}
+}
+
+// deleteBranches deletes every branch except keep. Failures are reported and skipped.
+func (uc *SquashUseCase) deleteBranches(ctx context.Context, result *Result, projectID int, keep string) {
+	branches, err := uc.GitLabGateway.ListBranches(ctx, projectID)
+	if err != nil {
+		result.warnf("Warning: other branches are not deleted: %v", err)
+		return
+	}
+	deleted := 0
+	for _, branch := range branches {
+		if branch.Name == keep {
+			continue
+		}
+		if err := uc.GitLabGateway.DeleteBranch(ctx, projectID, branch.Name); err != nil {
+			result.warnf("Warning: %v", err)
+			continue
+		}
+		deleted++
+	}
+	uc.logger.Infof("Deleted %d other branches", deleted)
+}
+
+// deleteTags deletes every tag. Failures are reported and skipped.
+func (uc *SquashUseCase) deleteTags(ctx context.Context, result *Result, projectID int) {
+	tags, err := uc.GitLabGateway.ListTags(ctx, projectID)
+	if err != nil {
+		result.warnf("Warning: tags are not deleted: %v", err)
+		return
+	}
+	deleted := 0
+	for _, tag := range tags {
+		if err := uc.GitLabGateway.DeleteTag(ctx, projectID, tag.Name); err != nil {
+			result.warnf("Warning: %v", err)
+			continue
+		}
+		deleted++
+	}
+	uc.logger.Infof("Deleted %d tags", deleted)
+}
+
+// protectionMatches reports whether a protected branch rule covers branch.
+// In GitLab rules, "*" matches any sequence of characters, including "/".
+func protectionMatches(rule, branch string) bool {
+	if !strings.Contains(rule, "*") {
+		return rule == branch
+	}
+	pattern := "^" + strings.ReplaceAll(regexp.QuoteMeta(rule), `\*`, ".*") + "$"
+	matched, _ := regexp.MatchString(pattern, branch)
+	return matched
+}
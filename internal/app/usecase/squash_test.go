@@ -0,0 +1,171 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/olegshirko/reposqueeze/internal/domain/entity"
+	"github.com/olegshirko/reposqueeze/internal/domain/gateway"
+	"github.com/olegshirko/reposqueeze/internal/infrastructure/git"
+	"github.com/olegshirko/reposqueeze/internal/testutil"
+)
+
+// pushingGit is a git backend whose PushBranch is replaced, so the tests push
+// to the fake GitLab instead of a remote.
+type pushingGit struct {
+	gateway.GitGateway
+	push func(ctx context.Context, localBranch, remoteBranch string) error
+}
+
+func (g *pushingGit) PushBranch(ctx context.Context, repoPath, remoteURL, localBranch, remoteBranch string) error {
+	return g.push(ctx, localBranch, remoteBranch)
+}
+
+// squashFixture is a repository and a project with protected branches, other
+// branches and tags to squash.
+func squashFixture(t *testing.T) (*testutil.Repo, *testutil.FakeGitLab) {
+	repo := testutil.NewRepo(t)
+	repo.Commit("first", map[string]string{"main.go": "package main\n", "old.txt": "old"})
+	repo.Commit("second", map[string]string{"README.md": "# App\n"})
+
+	gitLab := testutil.NewFakeGitLab()
+	project := gitLab.AddProject(1, "app")
+	project.DefaultBranch = "master"
+	project.HTTPURLToRepo = "https://gitlab.example.com/group/app.git"
+	gitLab.SetBranch(1, "master", "old")
+	gitLab.SetBranch(1, "feature", "feature")
+	gitLab.Tags[1] = []entity.Tag{{Name: "v1.0.0"}, {Name: "v1.1.0"}}
+	gitLab.Protected[1] = []entity.ProtectedBranch{
+		{Name: "master", PushAccessLevels: []entity.AccessLevel{{AccessLevel: 40}}},
+		{Name: "ma*", AllowForcePush: true},
+		{Name: "release/*"},
+	}
+	return repo, gitLab
+}
+
+// sortedRules returns the protection rules of a project, sorted by name.
+func sortedRules(gitLab *testutil.FakeGitLab, projectID int) []entity.ProtectedBranch {
+	rules := slices.Clone(gitLab.Protected[projectID])
+	slices.SortFunc(rules, func(a, b entity.ProtectedBranch) int { return strings.Compare(a.Name, b.Name) })
+	return rules
+}
+
+func TestSquash(t *testing.T) {
+	tests := []struct {
+		name string
+		// push pushes the head of the local branch, which is at sha, or fails.
+		push    func(gitLab *testutil.FakeGitLab, cancel context.CancelFunc, sha string) error
+		wantErr string // empty if the squash succeeds
+	}{
+		{
+			name: "pushed",
+			push: func(gitLab *testutil.FakeGitLab, cancel context.CancelFunc, sha string) error {
+				gitLab.SetBranch(1, "master", sha)
+				return nil
+			},
+		},
+		{
+			name: "push fails",
+			push: func(gitLab *testutil.FakeGitLab, cancel context.CancelFunc, sha string) error {
+				return errors.New("pre-receive hook declined")
+			},
+			wantErr: "pre-receive hook declined",
+		},
+		{
+			name: "cancelled during the push",
+			push: func(gitLab *testutil.FakeGitLab, cancel context.CancelFunc, sha string) error {
+				cancel()
+				return context.Canceled
+			},
+			wantErr: context.Canceled.Error(),
+		},
+		{
+			name: "remote head differs from the pushed commit",
+			push: func(gitLab *testutil.FakeGitLab, cancel context.CancelFunc, sha string) error {
+				gitLab.SetBranch(1, "master", "concurrent")
+				return nil
+			},
+			wantErr: "is at concurrent instead of the pushed commit",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo, gitLab := squashFixture(t)
+			wantRules := sortedRules(gitLab, 1)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			log := testutil.Logger()
+			var pushed []string
+			gitGateway := &pushingGit{GitGateway: git.NewOSExecGitGateway(log), push: func(ctx context.Context, localBranch, remoteBranch string) error {
+				if rules := gitLab.Protected[1]; len(rules) != 1 || rules[0].Name != "release/*" {
+					t.Errorf("pushed while branch %s is protected by %v", remoteBranch, rules)
+				}
+				pushed = append(pushed, remoteBranch)
+				return tt.push(gitLab, cancel, repo.Git("rev-parse", localBranch))
+			}}
+
+			result, err := NewSquashUseCase(gitGateway, gitLab, log).Execute(ctx, SquashInput{RepoPath: repo.Path})
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Execute error = %v, want one containing %q", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatalf("Execute: %v", err)
+			}
+
+			if !slices.Equal(pushed, []string{"master"}) {
+				t.Errorf("pushed to %v, want [master]", pushed)
+			}
+			// The rules covering master, the wildcard among them, are restored as they were.
+			if rules := sortedRules(gitLab, 1); !reflect.DeepEqual(rules, wantRules) {
+				t.Errorf("protection rules after the squash: %+v, want %+v", rules, wantRules)
+			}
+			if gitLab.Called("unprotect branch release/*") {
+				t.Errorf("rule release/* that does not cover master was lifted: %v", gitLab.Calls)
+			}
+			if branches := repo.Branches(); !slices.Equal(branches, []string{"master"}) {
+				t.Errorf("local branches %v are left, want [master]", branches)
+			}
+			if err != nil {
+				return
+			}
+			if result.RemoteCommitSHA != result.CommitSHA || result.Files != 3 {
+				t.Errorf("result has remote commit %s for pushed commit %s with %d files, want the same commit with 3 files",
+					result.RemoteCommitSHA, result.CommitSHA, result.Files)
+			}
+			if !slices.ContainsFunc(result.Warnings, func(w string) bool { return strings.Contains(w, "Rule ma* also protects other branches") }) {
+				t.Errorf("no warning about the wildcard rule in %v", result.Warnings)
+			}
+		})
+	}
+}
+
+func TestSquashDeletesRefs(t *testing.T) {
+	repo, gitLab := squashFixture(t)
+	log := testutil.Logger()
+	gitGateway := &pushingGit{GitGateway: git.NewOSExecGitGateway(log), push: func(ctx context.Context, localBranch, remoteBranch string) error {
+		gitLab.SetBranch(1, remoteBranch, repo.Git("rev-parse", localBranch))
+		return nil
+	}}
+
+	_, err := NewSquashUseCase(gitGateway, gitLab, log).Execute(context.Background(), SquashInput{
+		RepoPath:       repo.Path,
+		DeleteBranches: true,
+		DeleteTags:     true,
+	})
+	if err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+
+	branches, _ := gitLab.ListBranches(context.Background(), 1)
+	if len(branches) != 1 || branches[0].Name != "master" {
+		t.Errorf("branches %+v are left, want only the squashed master", branches)
+	}
+	if len(gitLab.Tags[1]) != 0 {
+		t.Errorf("tags %+v are left", gitLab.Tags[1])
+	}
+}
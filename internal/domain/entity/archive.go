@@ -0,0 +1,28 @@
+package entity
+
+// ArchiveFormat is the file format of a repository archive, as used in the
+// file extension of the GitLab archive endpoint.
+type ArchiveFormat string
+
+const (
+	ArchiveZip    ArchiveFormat = "zip"
+	ArchiveTarGz  ArchiveFormat = "tar.gz"
+	ArchiveTarBz2 ArchiveFormat = "tar.bz2"
+	ArchiveTar    ArchiveFormat = "tar"
+)
+
+// Valid reports whether f is a known archive format.
+func (f ArchiveFormat) Valid() bool {
+	switch f {
+	case ArchiveZip, ArchiveTarGz, ArchiveTarBz2, ArchiveTar:
+		return true
+	}
+	return false
+}
+
+// ArchiveSummary describes the contents of a repository archive.
+type ArchiveSummary struct {
+	Files    int   // Regular files, symlinks included
+	Symlinks int   // Symbolic links
+	Bytes    int64 // Total uncompressed size of the files
+}
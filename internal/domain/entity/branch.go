@@ -2,5 +2,14 @@ package entity
 
 // Branch represents a Git branch.
 type Branch struct {
-	Name string
-}
\ No newline at end of file
+	Name      string
+	CommitSHA string // SHA of the branch head, empty when unknown
+	// CommitMessage is the message of the branch head, empty when unknown.
+	CommitMessage string
+}
+
+// Tag represents a Git tag.
+type Tag struct {
+	Name      string
+	CommitSHA string // SHA of the tagged commit
+}
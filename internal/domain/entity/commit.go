@@ -0,0 +1,46 @@
+package entity
+
+import (
+	"fmt"
+	"time"
+)
+
+// Commit represents a Git commit.
+type Commit struct {
+	SHA     string `json:"id"`
+	Message string `json:"message"`
+}
+
+// CommitResult describes a commit made in a local repository.
+type CommitResult struct {
+	CommitSHA string
+	TreeSHA   string
+	Files     int   // Files in the tree, symlinks included
+	Bytes     int64 // Total size of the files
+}
+
+// KeepHistory selects the most recent commits that survive when the history
+// of a branch is squashed. The zero value keeps none, so the whole history
+// becomes a single commit.
+type KeepHistory struct {
+	Last  int       // Keep the last Last commits
+	Since time.Time // Keep the commits committed at or after Since
+}
+
+// IsZero reports whether no commits are kept.
+func (k KeepHistory) IsZero() bool {
+	return k.Last == 0 && k.Since.IsZero()
+}
+
+// String describes the kept commits, e.g. "the last 5 commits".
+func (k KeepHistory) String() string {
+	switch {
+	case k.Last > 0 && !k.Since.IsZero():
+		return fmt.Sprintf("the last %d commits since %s", k.Last, k.Since.Format(time.RFC3339))
+	case k.Last > 0:
+		return fmt.Sprintf("the last %d commits", k.Last)
+	case !k.Since.IsZero():
+		return "the commits since " + k.Since.Format(time.RFC3339)
+	}
+	return "no commits"
+}
@@ -0,0 +1,144 @@
+package entity
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// APIError is a request that GitLab has refused. The gateways return it
+// wrapped in the error type of its status code, see NewAPIError, so callers
+// can tell the failures apart with errors.As.
+type APIError struct {
+	Operation  string // What was requested, e.g. "create project"
+	StatusCode int
+	Status     string // Status line, e.g. "404 Not Found"
+	Message    string // The message or error decoded from the response
+}
+
+func (e *APIError) Error() string {
+	if e.Status == "" {
+		return e.Message
+	}
+	msg := "gitlab api returned " + e.Status
+	if e.Operation != "" {
+		msg += " for " + e.Operation
+	}
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	return msg
+}
+
+// NotFoundError means that a project, namespace, branch or other object does not exist (404).
+type NotFoundError struct{ *APIError }
+
+func (e *NotFoundError) Unwrap() error { return e.APIError }
+
+// ConflictError means that the object exists already or was changed meanwhile (409).
+type ConflictError struct{ *APIError }
+
+func (e *ConflictError) Unwrap() error { return e.APIError }
+
+// UnauthorizedError means that the token is missing, invalid or expired (401).
+type UnauthorizedError struct{ *APIError }
+
+func (e *UnauthorizedError) Unwrap() error { return e.APIError }
+
+// ForbiddenError means that the token lacks the permission or scope for the request (403).
+type ForbiddenError struct{ *APIError }
+
+func (e *ForbiddenError) Unwrap() error { return e.APIError }
+
+// RateLimitedError means that GitLab kept rejecting requests over its rate limit (429).
+type RateLimitedError struct {
+	*APIError
+	RetryAfter time.Duration // How long GitLab asked to wait, 0 if it did not say
+}
+
+func (e *RateLimitedError) Unwrap() error { return e.APIError }
+
+// ValidationFailedError means that GitLab rejected the parameters of the request (400 or 422).
+type ValidationFailedError struct{ *APIError }
+
+func (e *ValidationFailedError) Unwrap() error { return e.APIError }
+
+// NewAPIError returns apiError wrapped in the error type of its status code,
+// or apiError itself for the other status codes.
+func NewAPIError(apiError *APIError) error {
+	switch apiError.StatusCode {
+	case http.StatusNotFound:
+		return &NotFoundError{apiError}
+	case http.StatusConflict:
+		return &ConflictError{apiError}
+	case http.StatusUnauthorized:
+		return &UnauthorizedError{apiError}
+	case http.StatusForbidden:
+		return &ForbiddenError{apiError}
+	case http.StatusTooManyRequests:
+		return &RateLimitedError{APIError: apiError}
+	case http.StatusBadRequest, http.StatusUnprocessableEntity:
+		return &ValidationFailedError{apiError}
+	}
+	return apiError
+}
+
+// NotFound returns a NotFoundError for an object that was looked up and is
+// missing without GitLab reporting an error, e.g. after an empty search.
+func NotFound(format string, args ...interface{}) error {
+	return &NotFoundError{&APIError{StatusCode: http.StatusNotFound, Message: fmt.Sprintf(format, args...)}}
+}
+
+// Conflict returns a ConflictError for an object that GitLab has not released
+// in time without reporting an error, e.g. a project that is still being deleted.
+func Conflict(format string, args ...interface{}) error {
+	return &ConflictError{&APIError{StatusCode: http.StatusConflict, Message: fmt.Sprintf(format, args...)}}
+}
+
+// ValidationFailed returns a ValidationFailedError for parameters that GitLab
+// accepted but that do not identify a single object, e.g. an ambiguous name.
+func ValidationFailed(format string, args ...interface{}) error {
+	return &ValidationFailedError{&APIError{StatusCode: http.StatusUnprocessableEntity, Message: fmt.Sprintf(format, args...)}}
+}
+
+// Unauthorized returns an UnauthorizedError for a request that cannot be sent
+// at all, e.g. because no token is set.
+func Unauthorized(format string, args ...interface{}) error {
+	return &UnauthorizedError{&APIError{StatusCode: http.StatusUnauthorized, Message: fmt.Sprintf(format, args...)}}
+}
+
+// GitCommandError is a git command that failed.
+type GitCommandError struct {
+	Args     []string // Arguments of git, without the repository path
+	ExitCode int      // -1 if git did not exit by itself, e.g. when it was cancelled
+	Stderr   string   // What git printed about the failure
+	Err      error
+}
+
+func (e *GitCommandError) Error() string {
+	command := "git"
+	if len(e.Args) > 0 {
+		command += " " + e.Args[0]
+	}
+	msg := fmt.Sprintf("%s failed: %v", command, e.Err)
+	if stderr := strings.TrimSpace(e.Stderr); stderr != "" {
+		msg += ": " + stderr
+	}
+	return msg
+}
+
+func (e *GitCommandError) Unwrap() error { return e.Err }
+
+// GitError is an operation of the built-in git backend that failed. It stands
+// for GitCommandError where no git command is run.
+type GitError struct {
+	Operation string // What was done, e.g. "push"
+	Err       error
+}
+
+func (e *GitError) Error() string {
+	return fmt.Sprintf("git %s failed: %v", e.Operation, e.Err)
+}
+
+func (e *GitError) Unwrap() error { return e.Err }
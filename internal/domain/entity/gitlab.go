@@ -5,3 +5,18 @@ type GitLabProject struct {
 	ID    string
 	Token string
 }
+
+// GitLabVersion describes the version of a GitLab instance.
+type GitLabVersion struct {
+	Version  string `json:"version"`
+	Revision string `json:"revision"`
+}
+
+// Namespace is a group or a user namespace that projects are created in.
+type Namespace struct {
+	ID       int    `json:"id"`
+	Name     string `json:"name"`
+	Path     string `json:"path"`
+	FullPath string `json:"full_path"`
+	Kind     string `json:"kind"` // "group" or "user"
+}
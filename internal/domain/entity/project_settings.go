@@ -0,0 +1,96 @@
+package entity
+
+// ProjectSettings is a snapshot of the settings of a GitLab project that are
+// lost when the project is deleted and created again.
+type ProjectSettings struct {
+	Description       string
+	Topics            []string
+	Avatar            *ProjectAvatar
+	Members           []ProjectMember
+	Variables         []CIVariable
+	ProtectedBranches []ProtectedBranch
+	Hooks             []ProjectHook
+	Labels            []Label
+
+	// Failures lists the settings that could not be read.
+	Failures []SettingFailure
+}
+
+// ProjectAvatar is the image file of a project avatar.
+type ProjectAvatar struct {
+	Filename string
+	Content  []byte
+}
+
+// ProjectMember is a direct member of a project.
+type ProjectMember struct {
+	ID          int    `json:"id"`
+	Username    string `json:"username"`
+	AccessLevel int    `json:"access_level"`
+	ExpiresAt   string `json:"expires_at,omitempty"`
+}
+
+// CIVariable is a CI/CD variable of a project. Value is nil for hidden
+// variables, whose value GitLab never returns.
+type CIVariable struct {
+	Key              string  `json:"key"`
+	Value            *string `json:"value"`
+	VariableType     string  `json:"variable_type"`
+	Protected        bool    `json:"protected"`
+	Masked           bool    `json:"masked"`
+	Hidden           bool    `json:"hidden"`
+	Raw              bool    `json:"raw"`
+	EnvironmentScope string  `json:"environment_scope"`
+	Description      string  `json:"description,omitempty"`
+}
+
+// ProtectedBranch is a protected branch rule of a project. Name may be a wildcard.
+type ProtectedBranch struct {
+	Name                      string        `json:"name"`
+	PushAccessLevels          []AccessLevel `json:"push_access_levels"`
+	MergeAccessLevels         []AccessLevel `json:"merge_access_levels"`
+	UnprotectAccessLevels     []AccessLevel `json:"unprotect_access_levels"`
+	AllowForcePush            bool          `json:"allow_force_push"`
+	CodeOwnerApprovalRequired bool          `json:"code_owner_approval_required"`
+}
+
+// AccessLevel grants an action to a role, a user or a group.
+type AccessLevel struct {
+	AccessLevel int  `json:"access_level"`
+	UserID      *int `json:"user_id,omitempty"`
+	GroupID     *int `json:"group_id,omitempty"`
+}
+
+// ProjectHook is a webhook of a project. Attributes holds the settings as
+// returned by GitLab, so event flags of any GitLab version are kept.
+// GitLab never returns the secret token of a hook.
+type ProjectHook struct {
+	URL        string
+	Attributes map[string]interface{}
+}
+
+// Label is a project label.
+type Label struct {
+	Name        string `json:"name"`
+	Color       string `json:"color"`
+	Description string `json:"description,omitempty"`
+	Priority    *int   `json:"priority,omitempty"`
+}
+
+// SettingFailure describes a setting that could not be migrated.
+type SettingFailure struct {
+	Setting string // e.g. "members" or "variables"
+	Item    string // e.g. a user name or a variable key, empty for the setting as a whole
+	Reason  string
+	// Unreadable is set when GitLab never returns the missing data, e.g. a hidden
+	// variable value, so it cannot be copied from the old project either.
+	Unreadable bool
+}
+
+// String returns a one-line description of the failure.
+func (f SettingFailure) String() string {
+	if f.Item == "" {
+		return f.Setting + ": " + f.Reason
+	}
+	return f.Setting + " " + f.Item + ": " + f.Reason
+}
@@ -5,8 +5,39 @@ type Repository struct {
 	Path string
 }
 
+// File is a file of a commit.
+type File struct {
+	Path       string
+	Content    []byte
+	Executable bool
+}
+
 // Project represents a GitLab project.
 type Project struct {
-	ID   int    `json:"id"`
-	Name string `json:"name"`
-}
\ No newline at end of file
+	ID                int      `json:"id"`
+	Name              string   `json:"name"`
+	Path              string   `json:"path"`
+	PathWithNamespace string   `json:"path_with_namespace"`
+	WebURL            string   `json:"web_url"`
+	HTTPURLToRepo     string   `json:"http_url_to_repo"`
+	SSHURLToRepo      string   `json:"ssh_url_to_repo"`
+	Description       string   `json:"description"`
+	Visibility        string   `json:"visibility"`
+	DefaultBranch     string   `json:"default_branch"`
+	Topics            []string `json:"topics"`
+	AvatarURL         string   `json:"avatar_url"`
+	Namespace         struct {
+		ID       int    `json:"id"`
+		FullPath string `json:"full_path"`
+	} `json:"namespace"`
+
+	// Set when the project is scheduled for delayed deletion.
+	// Older GitLab versions only return MarkedForDeletionAt.
+	MarkedForDeletionAt string `json:"marked_for_deletion_at,omitempty"`
+	MarkedForDeletionOn string `json:"marked_for_deletion_on,omitempty"`
+}
+
+// MarkedForDeletion reports whether the project is pending delayed deletion.
+func (p *Project) MarkedForDeletion() bool {
+	return p.MarkedForDeletionAt != "" || p.MarkedForDeletionOn != ""
+}
@@ -0,0 +1,22 @@
+package gateway
+
+import (
+	"context"
+	"io"
+
+	"github.com/olegshirko/reposqueeze/internal/domain/entity"
+)
+
+// ArchiveExtractor defines the interface for unpacking repository archives.
+// Archives downloaded from GitLab have a single top-level directory, which is
+// stripped so the repository contents land directly in the target directory.
+// Both methods stop between two entries when ctx is cancelled.
+type ArchiveExtractor interface {
+	// Extract unpacks the archive into targetDir.
+	Extract(ctx context.Context, archive io.ReaderAt, size int64, targetDir string) (*entity.ArchiveSummary, error)
+	// Inspect validates the archive like Extract does, without writing anything.
+	Inspect(ctx context.Context, archive io.ReaderAt, size int64) (*entity.ArchiveSummary, error)
+}
+
+// ArchiveExtractors maps every supported archive format to its extractor.
+type ArchiveExtractors map[entity.ArchiveFormat]ArchiveExtractor
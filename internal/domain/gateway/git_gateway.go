@@ -7,13 +7,42 @@ import (
 )
 
 // GitGateway defines the interface for interacting with a local Git system.
+//
+// The branches are built in the object database: creating a branch, listing or
+// reading its files and rewriting its commits leave HEAD, the index and the
+// working tree alone. Only CreateEmptyOrphanBranch, CleanWorkdir and Commit
+// work on the checkout.
+//
+// The methods that make commits return the last commit of the branch. Every
+// method stops the git commands it runs when ctx is cancelled.
 type GitGateway interface {
-	CreateOrphanBranch(ctx context.Context, repository *entity.Repository, branch *entity.Branch, sourceBranch string) error
+	// CreateOrphanBranch creates a branch with a single commit that holds the
+	// committed files of sourceBranch, or of HEAD if sourceBranch is empty.
+	CreateOrphanBranch(ctx context.Context, repository *entity.Repository, branch *entity.Branch, sourceBranch string) (*entity.CommitResult, error)
+	// CreateSquashedBranch creates a branch whose root commit holds the files of
+	// sourceBranch before the kept commits, followed by the kept commits with
+	// their original messages, authors and dates.
+	CreateSquashedBranch(ctx context.Context, repository *entity.Repository, branch *entity.Branch, sourceBranch string, keep entity.KeepHistory) (*entity.CommitResult, error)
+	// CreateEmptyOrphanBranch checks out a new branch without commits and empties the index.
 	CreateEmptyOrphanBranch(ctx context.Context, repository *entity.Repository, branch *entity.Branch, sourceBranch string) error
-	ListFiles(repoPath string) ([]string, error)
-	DeleteLocalBranch(repoPath, branchName string) error
-	CheckoutBranch(repoPath, branchName string) error
-	RemoveDirectory(repoPath, dirName string) error
-	CleanWorkdir(repoPath string) error
-	Commit(repoPath, message string) error
+	// CurrentBranch returns the name of the checked out branch, or the commit
+	// SHA if HEAD is detached.
+	CurrentBranch(ctx context.Context, repoPath string) (string, error)
+	// Checkout checks out a branch or commit, discarding the changes of the
+	// index and the working tree. Untracked files are left alone.
+	Checkout(ctx context.Context, repoPath, ref string) error
+	// ListFiles lists the files of the last commit of a branch.
+	ListFiles(ctx context.Context, repoPath, branchName string) ([]string, error)
+	// ReadFiles reads files of the last commit of a branch. A symbolic link is
+	// read as a file holding the link target.
+	ReadFiles(ctx context.Context, repoPath, branchName string, files []string) ([]entity.File, error)
+	DeleteLocalBranch(ctx context.Context, repoPath, branchName string) error
+	// ExcludeFiles rewrites the new commits of a branch without the files for
+	// which excluded returns true.
+	ExcludeFiles(ctx context.Context, repoPath, branchName string, excluded func(file string) bool) (*entity.CommitResult, error)
+	CleanWorkdir(ctx context.Context, repoPath string) error
+	// Commit commits all files of the working tree to the current branch.
+	Commit(ctx context.Context, repoPath, message string) (*entity.CommitResult, error)
+	// PushBranch force-pushes localBranch to remoteBranch of remoteURL.
+	PushBranch(ctx context.Context, repoPath, remoteURL, localBranch, remoteBranch string) error
 }
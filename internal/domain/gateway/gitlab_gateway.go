@@ -3,24 +3,93 @@ package gateway
 import (
 	"bytes"
 	"context"
+	"io"
+	"unicode/utf8"
 
 	"github.com/olegshirko/reposqueeze/internal/domain/entity"
 )
 
 // CommitAction represents a single file operation for the GitLab Commits API.
 type CommitAction struct {
-	Action   string `json:"action"` // "create", "delete", "move", "update", "chmod"
-	FilePath string `json:"file_path"`
-	Content  string `json:"content"`
-	Encoding string `json:"encoding"` // "text" or "base64"
+	Action          string // "create", "delete", "move", "update", "chmod"
+	FilePath        string
+	Content         []byte // Raw file content
+	Encoding        string // How Content is sent: "text" or "base64"
+	ExecuteFilemode bool   // Whether the file is executable
+}
+
+// binaryDetectionLimit is how many leading bytes are checked for NUL bytes, like git does.
+const binaryDetectionLimit = 8000
+
+// NewCreateAction returns an action that creates a file, choosing the "text"
+// encoding for valid UTF-8 without NUL bytes and "base64" for everything else.
+func NewCreateAction(filePath string, content []byte, executable bool) CommitAction {
+	encoding := "text"
+	head := content
+	if len(head) > binaryDetectionLimit {
+		head = head[:binaryDetectionLimit]
+	}
+	if bytes.IndexByte(head, 0) >= 0 || !utf8.Valid(content) {
+		encoding = "base64"
+	}
+	return CommitAction{
+		Action:          "create",
+		FilePath:        filePath,
+		Content:         content,
+		Encoding:        encoding,
+		ExecuteFilemode: executable,
+	}
+}
+
+// CreateProjectOptions describes a new GitLab project. Empty fields keep the GitLab defaults.
+type CreateProjectOptions struct {
+	Name          string
+	Path          string // URL slug of the project, derived from Name by default
+	NamespaceID   int    // Group or user namespace, the token owner's namespace by default
+	Visibility    string // "private", "internal" or "public"
+	Description   string
+	DefaultBranch string
+}
+
+// ArchiveOptions selects what DownloadRepoArchive downloads. Empty fields keep the GitLab defaults.
+type ArchiveOptions struct {
+	Ref    string               // Branch, tag or commit SHA, the default branch by default
+	Path   string               // Subdirectory to download, the whole repository by default
+	Format entity.ArchiveFormat // entity.ArchiveZip by default
 }
 
 // GitLabGateway defines the interface for interacting with the GitLab API.
+// Every method sends its requests with ctx, so cancelling ctx aborts them.
 type GitLabGateway interface {
-	CommitFilesViaAPI(projectID, branchName, commitMessage string, actions []CommitAction) error
+	CommitFilesViaAPI(ctx context.Context, projectID, branchName, commitMessage string, actions []CommitAction) (*entity.Commit, error)
 	CreateRemoteBranch(ctx context.Context, projectID, branchName, refSHA string) error
-	FindProjectByName(name string) (*entity.Project, error)
-	DeleteProject(projectID int) error
-	CreateProject(name string) (*entity.Project, error)
-	DownloadRepoArchive(projectID int, writer *bytes.Buffer) error
+	FindProjectByName(ctx context.Context, name string) (*entity.Project, error)
+	GetProject(ctx context.Context, projectID int) (*entity.Project, error)
+	// GetProjectByPath returns a project by its full path, e.g. "group/subgroup/project", or nil if it does not exist.
+	GetProjectByPath(ctx context.Context, fullPath string) (*entity.Project, error)
+	// GetNamespace returns a namespace by its full path, e.g. "group/subgroup", or nil if it does not exist.
+	GetNamespace(ctx context.Context, fullPath string) (*entity.Namespace, error)
+	DeleteProject(ctx context.Context, projectID int) error
+	CreateProject(ctx context.Context, options CreateProjectOptions) (*entity.Project, error)
+	RenameProject(ctx context.Context, projectID int, name, path string) (*entity.Project, error)
+	// SnapshotProjectSettings reads the settings that are lost when a project is deleted:
+	// members, CI/CD variables, protected branches, webhooks, labels, topics, avatar and
+	// description. Settings that cannot be read are listed in the Failures of the snapshot.
+	SnapshotProjectSettings(ctx context.Context, projectID int) (*entity.ProjectSettings, error)
+	// RestoreProjectSettings applies a snapshot to a project and returns what could not be applied.
+	RestoreProjectSettings(ctx context.Context, projectID int, settings *entity.ProjectSettings) ([]entity.SettingFailure, error)
+	GetBranch(ctx context.Context, projectID, branchName string) (*entity.Branch, error)
+	ListBranches(ctx context.Context, projectID int) ([]entity.Branch, error)
+	DeleteBranch(ctx context.Context, projectID int, branchName string) error
+	ListTags(ctx context.Context, projectID int) ([]entity.Tag, error)
+	DeleteTag(ctx context.Context, projectID int, tagName string) error
+	ListProtectedBranches(ctx context.Context, projectID int) ([]entity.ProtectedBranch, error)
+	// ProtectBranch creates a protected branch rule, replacing an existing rule with the same name.
+	ProtectBranch(ctx context.Context, projectID int, branch entity.ProtectedBranch) error
+	UnprotectBranch(ctx context.Context, projectID int, name string) error
+	// DownloadRepoArchive streams an archive of the repository into writer.
+	// If writer is an *os.File, an interrupted download can be restarted from scratch
+	// when the server does not support resuming with a Range request.
+	DownloadRepoArchive(ctx context.Context, projectID int, options ArchiveOptions, writer io.Writer) error
+	GetVersion(ctx context.Context) (*entity.GitLabVersion, error)
 }
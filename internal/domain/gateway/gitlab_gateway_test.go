@@ -0,0 +1,41 @@
+package gateway
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestNewCreateAction(t *testing.T) {
+	text := bytes.Repeat([]byte("line of text\n"), 1000) // longer than binaryDetectionLimit
+	late := append(bytes.Clone(text[:binaryDetectionLimit]), 0, 'x')
+
+	tests := []struct {
+		name         string
+		content      []byte
+		executable   bool
+		wantEncoding string
+	}{
+		{name: "text", content: []byte("package main\n"), wantEncoding: "text"},
+		{name: "empty", content: nil, wantEncoding: "text"},
+		{name: "multibyte text", content: []byte("привет, мир\n"), wantEncoding: "text"},
+		{name: "nul byte", content: []byte("PK\x03\x04\x00\x00"), wantEncoding: "base64"},
+		{name: "nul byte at the detection limit", content: append(bytes.Clone(text[:binaryDetectionLimit-1]), 0), wantEncoding: "base64"},
+		// Like git, only the head is checked for NUL bytes.
+		{name: "nul byte after the detection limit", content: late, wantEncoding: "text"},
+		{name: "invalid utf-8", content: []byte("caf\xe9\n"), wantEncoding: "base64"},
+		{name: "invalid utf-8 after the detection limit", content: append(bytes.Clone(text), 0xff), wantEncoding: "base64"},
+		{name: "executable", content: []byte("#!/bin/sh\necho hi\n"), executable: true, wantEncoding: "text"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			action := NewCreateAction("dir/file", tt.content, tt.executable)
+			if action.Encoding != tt.wantEncoding {
+				t.Errorf("encoding %s, want %s", action.Encoding, tt.wantEncoding)
+			}
+			if action.Action != "create" || action.FilePath != "dir/file" || !bytes.Equal(action.Content, tt.content) || action.ExecuteFilemode != tt.executable {
+				t.Errorf("action %s of %s with executable %t, want create of dir/file with executable %t and the content unchanged",
+					action.Action, action.FilePath, action.ExecuteFilemode, tt.executable)
+			}
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package archive
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// errTooLarge is returned by writeFile when a file exceeds the remaining size limit.
+var errTooLarge = errors.New("size limit exceeded")
+
+func extractDir(targetDir, relativePath string) error {
+	if err := checkNoSymlinks(targetDir, relativePath); err != nil {
+		return err
+	}
+	return os.MkdirAll(filepath.Join(targetDir, relativePath), 0o755)
+}
+
+// writeFile writes the content of a regular file entry. limit is the maximum
+// number of bytes to write, -1 means no limit.
+func writeFile(content io.Reader, targetDir, relativePath string, perm fs.FileMode, limit int64) (int64, error) {
+	destination, err := prepareDestination(targetDir, relativePath)
+	if err != nil {
+		return 0, err
+	}
+
+	if perm == 0 {
+		perm = 0o644
+	}
+	outputFile, err := os.OpenFile(destination, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return 0, err
+	}
+
+	if limit >= 0 {
+		// Read one byte more than allowed to detect that the limit is exceeded.
+		content = io.LimitReader(content, limit+1)
+	}
+	written, err := io.Copy(outputFile, content)
+	if closeErr := outputFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return written, err
+	}
+	if limit >= 0 && written > limit {
+		return written, errTooLarge
+	}
+	return written, nil
+}
+
+// createSymlink validates the target of a symlink entry and, if targetDir is
+// not empty, creates the link. Links that are absolute or point outside the
+// target directory are rejected.
+func createSymlink(name, target, targetDir, relativePath string) error {
+	resolved := filepath.Join(filepath.Dir(relativePath), filepath.FromSlash(target))
+	if target == "" || filepath.IsAbs(target) || !filepath.IsLocal(resolved) {
+		return fmt.Errorf("symlink %s points outside of the repository: %s", name, target)
+	}
+
+	if targetDir == "" {
+		return nil
+	}
+	destination, err := prepareDestination(targetDir, relativePath)
+	if err != nil {
+		return err
+	}
+	return os.Symlink(target, destination)
+}
+
+// prepareDestination creates the parent directories of an entry and removes
+// whatever is in its place, so the entry is never written through a symlink.
+func prepareDestination(targetDir, relativePath string) (string, error) {
+	if err := checkNoSymlinks(targetDir, filepath.Dir(relativePath)); err != nil {
+		return "", err
+	}
+	destination := filepath.Join(targetDir, relativePath)
+	if err := os.MkdirAll(filepath.Dir(destination), 0o755); err != nil {
+		return "", err
+	}
+	if info, err := os.Lstat(destination); err == nil && !info.IsDir() {
+		if err := os.Remove(destination); err != nil {
+			return "", err
+		}
+	}
+	return destination, nil
+}
+
+// checkNoSymlinks fails if any existing component of relativePath under
+// targetDir is a symlink.
+func checkNoSymlinks(targetDir, relativePath string) error {
+	current := targetDir
+	for _, part := range strings.Split(relativePath, string(filepath.Separator)) {
+		if part == "" || part == "." {
+			continue
+		}
+		current = filepath.Join(current, part)
+		info, err := os.Lstat(current)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("refusing to extract through symlink %s", current)
+		}
+	}
+	return nil
+}
+
+// archiveRoot returns the top-level directory shared by all entries
+// (with a trailing slash), or an empty string if there is none.
+func archiveRoot(names []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	root, _, found := strings.Cut(names[0], "/")
+	if !found || root == "" {
+		return ""
+	}
+	root += "/"
+	for _, name := range names {
+		if !strings.HasPrefix(name, root) {
+			return ""
+		}
+	}
+	return root
+}
+
+// entryPath returns the path of an entry relative to the target directory in
+// the OS format, or an empty string for the root entry itself.
+func entryPath(name, root string) (string, error) {
+	if strings.HasPrefix(name, "/") {
+		return "", fmt.Errorf("illegal absolute path in archive: %s", name)
+	}
+	// The whole name is checked too, so that ".." is never taken for the
+	// top-level directory.
+	if !filepath.IsLocal(filepath.FromSlash(name)) {
+		return "", fmt.Errorf("illegal path in archive: %s", name)
+	}
+	relativePath := strings.TrimSuffix(strings.TrimPrefix(name, root), "/")
+	if relativePath == "" {
+		return "", nil
+	}
+	relativePath = filepath.FromSlash(relativePath)
+	if !filepath.IsLocal(relativePath) {
+		return "", fmt.Errorf("illegal path in archive: %s", name)
+	}
+	return filepath.Clean(relativePath), nil
+}
@@ -0,0 +1,199 @@
+package archive
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/olegshirko/reposqueeze/internal/domain/entity"
+	"github.com/olegshirko/reposqueeze/internal/domain/gateway"
+)
+
+// testEntry is an entry of an archive built by a test.
+type testEntry struct {
+	Name string
+	Body string // Content of a file
+	Link string // Target of a symlink
+	Dir  bool
+}
+
+func file(name, body string) testEntry    { return testEntry{Name: name, Body: body} }
+func symlink(name, link string) testEntry { return testEntry{Name: name, Link: link} }
+func dir(name string) testEntry           { return testEntry{Name: name, Dir: true} }
+
+// extractCase is an archive and what extracting it must give.
+type extractCase struct {
+	name     string
+	entries  []testEntry
+	maxFiles int
+	maxBytes int64
+
+	wantErr   string            // Part of the error, empty if extraction succeeds
+	wantFiles map[string]string // Content of the extracted files
+	wantLinks map[string]string // Targets of the extracted symlinks
+	summary   entity.ArchiveSummary
+}
+
+// extractCases are the cases every archive format must pass.
+func extractCases() []extractCase {
+	return []extractCase{
+		{
+			name:      "strips the top-level directory",
+			entries:   []testEntry{dir("repo-main/"), file("repo-main/a.txt", "a"), dir("repo-main/src/"), file("repo-main/src/b.go", "bb")},
+			wantFiles: map[string]string{"a.txt": "a", "src/b.go": "bb"},
+			summary:   entity.ArchiveSummary{Files: 2, Bytes: 3},
+		},
+		{
+			name:      "creates parent directories",
+			entries:   []testEntry{file("repo-main/a/b/c.txt", "c"), file("repo-main/d.txt", "d")},
+			wantFiles: map[string]string{"a/b/c.txt": "c", "d.txt": "d"},
+			summary:   entity.ArchiveSummary{Files: 2, Bytes: 2},
+		},
+		{
+			name:      "keeps a single top-level file",
+			entries:   []testEntry{file("a.txt", "a")},
+			wantFiles: map[string]string{"a.txt": "a"},
+			summary:   entity.ArchiveSummary{Files: 1, Bytes: 1},
+		},
+		{
+			// GitLab puts the requested subdirectory under the top-level directory.
+			name:      "subdirectory archive",
+			entries:   []testEntry{dir("repo-main-0a1b2c-docs/"), dir("repo-main-0a1b2c-docs/docs/"), file("repo-main-0a1b2c-docs/docs/guide/intro.md", "intro")},
+			wantFiles: map[string]string{"docs/guide/intro.md": "intro"},
+			summary:   entity.ArchiveSummary{Files: 1, Bytes: 5},
+		},
+		{
+			name:      "creates symlinks inside the directory",
+			entries:   []testEntry{file("repo-main/a.txt", "a"), symlink("repo-main/link", "a.txt"), symlink("repo-main/src/up", "../a.txt")},
+			wantFiles: map[string]string{"a.txt": "a"},
+			wantLinks: map[string]string{"link": "a.txt", "src/up": "../a.txt"},
+			summary:   entity.ArchiveSummary{Files: 3, Symlinks: 2, Bytes: 1},
+		},
+		{
+			name:    "rejects a parent path",
+			entries: []testEntry{file("repo-main/a.txt", "a"), file("repo-main/../evil.txt", "evil")},
+			wantErr: "illegal path in archive",
+		},
+		{
+			name:    "rejects a parent path without a top-level directory",
+			entries: []testEntry{file("../evil.txt", "evil")},
+			wantErr: "illegal path in archive",
+		},
+		{
+			name:    "rejects a parent path inside a directory",
+			entries: []testEntry{file("repo-main/a/../../../evil.txt", "evil"), file("repo-main/b.txt", "b")},
+			wantErr: "illegal path in archive",
+		},
+		{
+			name:    "rejects an absolute path",
+			entries: []testEntry{file("/tmp/evil.txt", "evil")},
+			wantErr: "illegal absolute path in archive",
+		},
+		{
+			name:    "rejects a symlink out of the directory",
+			entries: []testEntry{file("repo-main/a.txt", "a"), symlink("repo-main/link", "../../evil.txt")},
+			wantErr: "points outside of the repository",
+		},
+		{
+			name:    "rejects an absolute symlink",
+			entries: []testEntry{file("repo-main/a.txt", "a"), symlink("repo-main/link", "/etc/passwd")},
+			wantErr: "points outside of the repository",
+		},
+		{
+			// The link itself is harmless, but a file written through it is not.
+			name:    "rejects writing through a symlinked directory",
+			entries: []testEntry{dir("repo-main/sub/"), symlink("repo-main/link", "sub"), file("repo-main/link/evil.txt", "evil")},
+			wantErr: "refusing to extract through symlink",
+		},
+		{
+			name:     "stops at the file limit",
+			entries:  []testEntry{file("repo-main/a.txt", "a"), file("repo-main/b.txt", "b"), file("repo-main/c.txt", "c")},
+			maxFiles: 2,
+			wantErr:  "archive has more than 2 files",
+		},
+		{
+			name:     "counts symlinks against the file limit",
+			entries:  []testEntry{file("repo-main/a.txt", "a"), symlink("repo-main/b", "a.txt"), symlink("repo-main/c", "a.txt")},
+			maxFiles: 2,
+			wantErr:  "archive has more than 2 files",
+		},
+		{
+			name:     "stops at the size limit",
+			entries:  []testEntry{file("repo-main/a.txt", strings.Repeat("a", 600)), file("repo-main/b.txt", strings.Repeat("b", 600))},
+			maxBytes: 1000,
+			wantErr:  "archive is larger than 1000 bytes uncompressed",
+		},
+		{
+			// A bomb compresses a lot of zeros into a few bytes.
+			name:     "stops a bomb at the size limit",
+			entries:  []testEntry{file("repo-main/bomb", strings.Repeat("\x00", 1<<20))},
+			maxBytes: 1 << 10,
+			wantErr:  "archive is larger than 1024 bytes uncompressed",
+		},
+		{
+			name:      "allows an archive at the limits",
+			entries:   []testEntry{file("repo-main/a.txt", strings.Repeat("a", 600)), file("repo-main/b.txt", strings.Repeat("b", 400))},
+			maxFiles:  2,
+			maxBytes:  1000,
+			wantFiles: map[string]string{"a.txt": strings.Repeat("a", 600), "b.txt": strings.Repeat("b", 400)},
+			summary:   entity.ArchiveSummary{Files: 2, Bytes: 1000},
+		},
+	}
+}
+
+// testExtract runs a case with an extractor that has the limits of the case.
+// It extracts into a directory of its own, so escaping files are found
+// next to it.
+func testExtract(t *testing.T, tt extractCase, extractor gateway.ArchiveExtractor, archive []byte) {
+	t.Helper()
+	base := t.TempDir()
+	targetDir := filepath.Join(base, "repo")
+	if err := os.Mkdir(targetDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	summary, err := extractor.Extract(context.Background(), bytes.NewReader(archive), int64(len(archive)), targetDir)
+	if _, statErr := os.Lstat(filepath.Join(base, "evil.txt")); statErr == nil {
+		t.Error("evil.txt has been written outside of the target directory")
+	}
+	if tt.wantErr != "" {
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Fatalf("Extract error = %v, want one containing %q", err, tt.wantErr)
+		}
+		return
+	}
+	if err != nil {
+		t.Fatalf("Extract: %v", err)
+	}
+	if *summary != tt.summary {
+		t.Errorf("summary = %+v, want %+v", *summary, tt.summary)
+	}
+
+	for name, want := range tt.wantFiles {
+		content, err := os.ReadFile(filepath.Join(targetDir, filepath.FromSlash(name)))
+		if err != nil {
+			t.Errorf("file %s: %v", name, err)
+		} else if string(content) != want {
+			t.Errorf("file %s holds %q, want %q", name, content, want)
+		}
+	}
+	for name, want := range tt.wantLinks {
+		link, err := os.Readlink(filepath.Join(targetDir, filepath.FromSlash(name)))
+		if err != nil {
+			t.Errorf("symlink %s: %v", name, err)
+		} else if link != want {
+			t.Errorf("symlink %s points to %q, want %q", name, link, want)
+		}
+	}
+
+	inspected, err := extractor.Inspect(context.Background(), bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		t.Fatalf("Inspect: %v", err)
+	}
+	if *inspected != tt.summary {
+		t.Errorf("inspected summary = %+v, want %+v", *inspected, tt.summary)
+	}
+}
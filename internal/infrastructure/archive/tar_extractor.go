@@ -0,0 +1,197 @@
+package archive
+
+import (
+	"archive/tar"
+	"compress/bzip2"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/olegshirko/reposqueeze/internal/domain/entity"
+	"github.com/olegshirko/reposqueeze/internal/domain/gateway"
+	"github.com/olegshirko/reposqueeze/internal/pkg/logger"
+)
+
+// Compression is the compression of a tar archive.
+type Compression string
+
+const (
+	CompressionNone  Compression = ""
+	CompressionGzip  Compression = "gzip"
+	CompressionBzip2 Compression = "bzip2"
+)
+
+// TarExtractor is an implementation of the ArchiveExtractor for tar archives,
+// plain or compressed.
+//
+// A tar archive can only be read from start to end, so it is read twice: the
+// first pass checks every entry like ZipExtractor does and finds the top-level
+// directory, the second one writes the entries. Nothing is written if the
+// first pass fails.
+type TarExtractor struct {
+	Compression Compression
+	MaxFiles    int   // Maximum number of files, 0 means no limit
+	MaxBytes    int64 // Maximum total uncompressed size, 0 means no limit
+	logger      logger.Logger
+}
+
+// NewTarExtractor creates a new instance of TarExtractor with the default limits.
+func NewTarExtractor(compression Compression, log logger.Logger) *TarExtractor {
+	return &TarExtractor{
+		Compression: compression,
+		MaxFiles:    DefaultMaxFiles,
+		MaxBytes:    DefaultMaxBytes,
+		logger:      log,
+	}
+}
+
+// NewExtractors creates an extractor with the default limits for every archive format.
+func NewExtractors(log logger.Logger) gateway.ArchiveExtractors {
+	return gateway.ArchiveExtractors{
+		entity.ArchiveZip:    NewZipExtractor(log),
+		entity.ArchiveTarGz:  NewTarExtractor(CompressionGzip, log),
+		entity.ArchiveTarBz2: NewTarExtractor(CompressionBzip2, log),
+		entity.ArchiveTar:    NewTarExtractor(CompressionNone, log),
+	}
+}
+
+// Extract unpacks the archive into targetDir, stripping the top-level directory.
+func (e *TarExtractor) Extract(ctx context.Context, archive io.ReaderAt, size int64, targetDir string) (*entity.ArchiveSummary, error) {
+	if targetDir == "" {
+		return nil, fmt.Errorf("target directory is empty")
+	}
+	summary, root, err := e.inspect(ctx, archive, size)
+	if err != nil {
+		return nil, err
+	}
+
+	err = e.each(ctx, archive, size, func(header *tar.Header, content io.Reader) error {
+		relativePath, err := entryPath(header.Name, root)
+		if err != nil || relativePath == "" {
+			return err
+		}
+		switch header.Typeflag {
+		case tar.TypeDir:
+			return extractDir(targetDir, relativePath)
+		case tar.TypeSymlink:
+			return createSymlink(header.Name, header.Linkname, targetDir, relativePath)
+		default:
+			// The sizes were checked by inspect and a tar entry cannot hold more than its header says.
+			if _, err := writeFile(content, targetDir, relativePath, header.FileInfo().Mode().Perm(), -1); err != nil {
+				return fmt.Errorf("failed to extract %s: %w", header.Name, err)
+			}
+			return nil
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	e.logger.Debugf("Extracted %d files (%d symlinks, %d bytes) into %s", summary.Files, summary.Symlinks, summary.Bytes, targetDir)
+	return summary, nil
+}
+
+// Inspect validates the archive and summarizes it without writing anything.
+func (e *TarExtractor) Inspect(ctx context.Context, archive io.ReaderAt, size int64) (*entity.ArchiveSummary, error) {
+	summary, _, err := e.inspect(ctx, archive, size)
+	return summary, err
+}
+
+// inspect checks every entry and returns the summary and the top-level directory.
+func (e *TarExtractor) inspect(ctx context.Context, archive io.ReaderAt, size int64) (*entity.ArchiveSummary, string, error) {
+	type entry struct {
+		name, linkname string
+		typeflag       byte
+	}
+	var entries []entry
+	summary := &entity.ArchiveSummary{}
+	err := e.each(ctx, archive, size, func(header *tar.Header, content io.Reader) error {
+		switch header.Typeflag {
+		case tar.TypeDir:
+		case tar.TypeReg, tar.TypeSymlink:
+			summary.Files++
+			if e.MaxFiles > 0 && summary.Files > e.MaxFiles {
+				return fmt.Errorf("archive has more than %d files", e.MaxFiles)
+			}
+			if header.Typeflag == tar.TypeSymlink {
+				summary.Symlinks++
+				if len(header.Linkname) > maxSymlinkTarget {
+					return fmt.Errorf("symlink %s has a target longer than %d bytes", header.Name, maxSymlinkTarget)
+				}
+				break
+			}
+			summary.Bytes += header.Size
+			if e.MaxBytes > 0 && summary.Bytes > e.MaxBytes {
+				return fmt.Errorf("archive is larger than %d bytes uncompressed", e.MaxBytes)
+			}
+		default:
+			return fmt.Errorf("unsupported entry type %q for %s", header.Typeflag, header.Name)
+		}
+		entries = append(entries, entry{name: header.Name, linkname: header.Linkname, typeflag: header.Typeflag})
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	names := make([]string, len(entries))
+	for i, entry := range entries {
+		names[i] = entry.name
+	}
+	root := archiveRoot(names)
+	for _, entry := range entries {
+		relativePath, err := entryPath(entry.name, root)
+		if err != nil {
+			return nil, "", err
+		}
+		if relativePath != "" && entry.typeflag == tar.TypeSymlink {
+			if err := createSymlink(entry.name, entry.linkname, "", relativePath); err != nil {
+				return nil, "", err
+			}
+		}
+	}
+	return summary, root, nil
+}
+
+// each calls fn for every entry of the archive except the pax global header,
+// which git archive uses to record the commit ID. It stops before the next
+// entry when ctx is cancelled.
+func (e *TarExtractor) each(ctx context.Context, archive io.ReaderAt, size int64, fn func(header *tar.Header, content io.Reader) error) error {
+	var reader io.Reader = io.NewSectionReader(archive, 0, size)
+	switch e.Compression {
+	case CompressionNone:
+	case CompressionGzip:
+		gzipReader, err := gzip.NewReader(reader)
+		if err != nil {
+			return fmt.Errorf("failed to open gzip archive: %w", err)
+		}
+		defer gzipReader.Close()
+		reader = gzipReader
+	case CompressionBzip2:
+		reader = bzip2.NewReader(reader)
+	default:
+		return fmt.Errorf("unknown compression %q", e.Compression)
+	}
+
+	tarReader := tar.NewReader(reader)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		header, err := tarReader.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read tar archive: %w", err)
+		}
+		if header.Typeflag == tar.TypeXGlobalHeader {
+			continue
+		}
+		if err := fn(header, tarReader); err != nil {
+			return err
+		}
+	}
+}
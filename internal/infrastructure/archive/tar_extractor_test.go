@@ -0,0 +1,160 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/olegshirko/reposqueeze/internal/testutil"
+)
+
+// tarArchive builds a tar archive of entries with the given compression.
+func tarArchive(t *testing.T, compression Compression, entries []testEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	writer := tar.NewWriter(&buf)
+	// git archive starts with a pax global header holding the commit ID.
+	global := &tar.Header{Typeflag: tar.TypeXGlobalHeader, PAXRecords: map[string]string{"comment": "0a1b2c"}}
+	if err := writer.WriteHeader(global); err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		header := &tar.Header{Name: entry.Name, Typeflag: tar.TypeReg, Mode: 0o644, Size: int64(len(entry.Body))}
+		switch {
+		case entry.Dir:
+			header.Typeflag, header.Mode, header.Size = tar.TypeDir, 0o755, 0
+		case entry.Link != "":
+			header.Typeflag, header.Linkname, header.Mode, header.Size = tar.TypeSymlink, entry.Link, 0o777, 0
+		}
+		if err := writer.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := writer.Write([]byte(entry.Body)); err != nil && header.Size > 0 {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return compress(t, compression, buf.Bytes())
+}
+
+// compress compresses a tar archive. The standard library cannot write
+// bzip2, so the bzip2 command is used for it.
+func compress(t *testing.T, compression Compression, archive []byte) []byte {
+	t.Helper()
+	switch compression {
+	case CompressionGzip:
+		var buf bytes.Buffer
+		writer := gzip.NewWriter(&buf)
+		writer.Write(archive)
+		if err := writer.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	case CompressionBzip2:
+		if _, err := exec.LookPath("bzip2"); err != nil {
+			t.Skip("bzip2 is not installed")
+		}
+		cmd := exec.Command("bzip2", "-c")
+		cmd.Stdin = bytes.NewReader(archive)
+		output, err := cmd.Output()
+		if err != nil {
+			t.Fatalf("bzip2: %v", err)
+		}
+		return output
+	}
+	return archive
+}
+
+// compressions are the compressions of the tar formats, by test name.
+var compressions = map[string]Compression{
+	"tar":     CompressionNone,
+	"tar.gz":  CompressionGzip,
+	"tar.bz2": CompressionBzip2,
+}
+
+func TestTarExtractor(t *testing.T) {
+	for format, compression := range compressions {
+		t.Run(format, func(t *testing.T) {
+			for _, tt := range extractCases() {
+				t.Run(tt.name, func(t *testing.T) {
+					extractor := NewTarExtractor(compression, testutil.Logger())
+					extractor.MaxFiles = tt.maxFiles
+					extractor.MaxBytes = tt.maxBytes
+					testExtract(t, tt, extractor, tarArchive(t, compression, tt.entries))
+				})
+			}
+		})
+	}
+}
+
+// The first pass checks the whole archive, so an invalid entry at the end
+// stops the extraction before anything is written.
+func TestTarExtractorWritesNothingOnInvalidArchive(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []testEntry
+		header  *tar.Header // Entry added after entries
+		wantErr string
+	}{
+		{
+			name:    "parent path",
+			entries: []testEntry{file("repo-main/a.txt", "a"), file("repo-main/../evil.txt", "evil")},
+			wantErr: "illegal path in archive",
+		},
+		{
+			name:    "symlink out of the directory",
+			entries: []testEntry{file("repo-main/a.txt", "a"), symlink("repo-main/link", "../../evil.txt")},
+			wantErr: "points outside of the repository",
+		},
+		{
+			name:    "hard link",
+			entries: []testEntry{file("repo-main/a.txt", "a")},
+			header:  &tar.Header{Name: "repo-main/hard", Typeflag: tar.TypeLink, Linkname: "/etc/passwd"},
+			wantErr: "unsupported entry type",
+		},
+		{
+			name:    "device",
+			entries: []testEntry{file("repo-main/a.txt", "a")},
+			header:  &tar.Header{Name: "repo-main/null", Typeflag: tar.TypeChar, Devmajor: 1, Devminor: 3},
+			wantErr: "unsupported entry type",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			archive := tarArchive(t, CompressionNone, tt.entries)
+			if tt.header != nil {
+				archive = appendHeader(t, archive, tt.header)
+			}
+			targetDir := t.TempDir()
+			_, err := NewTarExtractor(CompressionNone, testutil.Logger()).Extract(context.Background(), bytes.NewReader(archive), int64(len(archive)), targetDir)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Extract error = %v, want one containing %q", err, tt.wantErr)
+			}
+			if written, _ := os.ReadDir(targetDir); len(written) != 0 {
+				t.Errorf("%d entries have been written, want none", len(written))
+			}
+		})
+	}
+}
+
+// appendHeader replaces the end of a plain tar archive with an entry without content.
+func appendHeader(t *testing.T, archive []byte, header *tar.Header) []byte {
+	t.Helper()
+	// A tar archive ends with two empty blocks of 512 bytes.
+	buf := bytes.NewBuffer(archive[:len(archive)-1024])
+	writer := tar.NewWriter(buf)
+	if err := writer.WriteHeader(header); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,192 @@
+package archive
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/olegshirko/reposqueeze/internal/domain/entity"
+	"github.com/olegshirko/reposqueeze/internal/pkg/logger"
+)
+
+const (
+	// DefaultMaxFiles is the default limit on the number of files in an archive.
+	DefaultMaxFiles = 1000000
+	// DefaultMaxBytes is the default limit on the total uncompressed size of an archive.
+	DefaultMaxBytes = 20 << 30 // 20 GiB
+
+	// maxSymlinkTarget limits the size of a symlink entry, which holds the link target.
+	maxSymlinkTarget = 4096
+)
+
+// ZipExtractor is an implementation of the ArchiveExtractor for zip archives.
+//
+// Every entry is checked before anything is written: paths that are absolute or
+// escape the target directory are rejected, symlinks are only created when they
+// point inside the target directory, and files are never written through a
+// symlink. The limits protect against zip bombs.
+type ZipExtractor struct {
+	MaxFiles int   // Maximum number of files, 0 means no limit
+	MaxBytes int64 // Maximum total uncompressed size, 0 means no limit
+	logger   logger.Logger
+}
+
+// NewZipExtractor creates a new instance of ZipExtractor with the default limits.
+func NewZipExtractor(log logger.Logger) *ZipExtractor {
+	return &ZipExtractor{
+		MaxFiles: DefaultMaxFiles,
+		MaxBytes: DefaultMaxBytes,
+		logger:   log,
+	}
+}
+
+// Extract unpacks the archive into targetDir, stripping the top-level directory.
+func (e *ZipExtractor) Extract(ctx context.Context, archive io.ReaderAt, size int64, targetDir string) (*entity.ArchiveSummary, error) {
+	if targetDir == "" {
+		return nil, fmt.Errorf("target directory is empty")
+	}
+	summary, err := e.walk(ctx, archive, size, targetDir)
+	if err != nil {
+		return nil, err
+	}
+	e.logger.Debugf("Extracted %d files (%d symlinks, %d bytes) into %s", summary.Files, summary.Symlinks, summary.Bytes, targetDir)
+	return summary, nil
+}
+
+// Inspect validates the archive and summarizes it without writing anything.
+// Sizes are taken from the archive headers.
+func (e *ZipExtractor) Inspect(ctx context.Context, archive io.ReaderAt, size int64) (*entity.ArchiveSummary, error) {
+	return e.walk(ctx, archive, size, "")
+}
+
+// walk validates every entry and, if targetDir is not empty, writes it.
+// It stops before the next entry when ctx is cancelled.
+func (e *ZipExtractor) walk(ctx context.Context, archive io.ReaderAt, size int64, targetDir string) (*entity.ArchiveSummary, error) {
+	zipReader, err := zip.NewReader(archive, size)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open zip archive: %w", err)
+	}
+
+	if err := e.checkDeclaredSize(zipReader.File); err != nil {
+		return nil, err
+	}
+
+	names := make([]string, len(zipReader.File))
+	for i, file := range zipReader.File {
+		names[i] = file.Name
+	}
+	root := archiveRoot(names)
+	summary := &entity.ArchiveSummary{}
+	for _, file := range zipReader.File {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		relativePath, err := entryPath(file.Name, root)
+		if err != nil {
+			return nil, err
+		}
+		if relativePath == "" {
+			continue
+		}
+
+		mode := file.Mode()
+		if !mode.IsDir() {
+			summary.Files++
+			if e.MaxFiles > 0 && summary.Files > e.MaxFiles {
+				return nil, fmt.Errorf("archive has more than %d files", e.MaxFiles)
+			}
+		}
+
+		switch {
+		case mode.IsDir():
+			if targetDir != "" {
+				if err := extractDir(targetDir, relativePath); err != nil {
+					return nil, err
+				}
+			}
+		case mode&os.ModeSymlink != 0:
+			summary.Symlinks++
+			if err := e.extractSymlink(file, targetDir, relativePath); err != nil {
+				return nil, err
+			}
+		case mode.IsRegular():
+			if targetDir == "" {
+				summary.Bytes += int64(file.UncompressedSize64)
+				continue
+			}
+			written, err := e.extractFile(file, targetDir, relativePath, e.remaining(summary.Bytes))
+			if err != nil {
+				return nil, err
+			}
+			summary.Bytes += written
+		default:
+			return nil, fmt.Errorf("unsupported entry type %s for %s", mode.Type(), file.Name)
+		}
+	}
+
+	return summary, nil
+}
+
+// checkDeclaredSize rejects archives whose headers already exceed the size limit.
+// The limit is enforced again while writing, since headers can lie.
+func (e *ZipExtractor) checkDeclaredSize(files []*zip.File) error {
+	if e.MaxBytes <= 0 {
+		return nil
+	}
+	var total uint64
+	for _, file := range files {
+		total += file.UncompressedSize64
+		if total > uint64(e.MaxBytes) {
+			return fmt.Errorf("archive is larger than %d bytes uncompressed", e.MaxBytes)
+		}
+	}
+	return nil
+}
+
+// remaining returns how many bytes may still be written, or -1 for no limit.
+func (e *ZipExtractor) remaining(written int64) int64 {
+	if e.MaxBytes <= 0 {
+		return -1
+	}
+	return e.MaxBytes - written
+}
+
+// extractFile writes a regular file and closes it before returning.
+// limit is the maximum number of bytes to write, -1 means no limit.
+func (e *ZipExtractor) extractFile(file *zip.File, targetDir, relativePath string, limit int64) (int64, error) {
+	zippedFile, err := file.Open()
+	if err != nil {
+		return 0, err
+	}
+	defer zippedFile.Close()
+
+	written, err := writeFile(zippedFile, targetDir, relativePath, file.Mode().Perm(), limit)
+	if err != nil {
+		if errors.Is(err, errTooLarge) {
+			return written, fmt.Errorf("archive is larger than %d bytes uncompressed", e.MaxBytes)
+		}
+		return written, fmt.Errorf("failed to extract %s: %w", file.Name, err)
+	}
+	return written, nil
+}
+
+// extractSymlink validates a symlink entry and, if targetDir is not empty, creates it.
+// Links that are absolute or point outside the target directory are rejected.
+func (e *ZipExtractor) extractSymlink(file *zip.File, targetDir, relativePath string) error {
+	zippedFile, err := file.Open()
+	if err != nil {
+		return err
+	}
+	content, err := io.ReadAll(io.LimitReader(zippedFile, maxSymlinkTarget+1))
+	zippedFile.Close()
+	if err != nil {
+		return err
+	}
+	if len(content) > maxSymlinkTarget {
+		return fmt.Errorf("symlink %s has a target longer than %d bytes", file.Name, maxSymlinkTarget)
+	}
+	return createSymlink(file.Name, string(content), targetDir, relativePath)
+}
@@ -0,0 +1,97 @@
+package archive
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"context"
+	"hash/crc32"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/olegshirko/reposqueeze/internal/testutil"
+)
+
+// zipArchive builds a zip archive of entries.
+func zipArchive(t *testing.T, entries []testEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	writer := zip.NewWriter(&buf)
+	for _, entry := range entries {
+		header := &zip.FileHeader{Name: entry.Name, Method: zip.Deflate}
+		body := entry.Body
+		switch {
+		case entry.Dir:
+			header.SetMode(os.ModeDir | 0o755)
+		case entry.Link != "":
+			header.SetMode(os.ModeSymlink | 0o777)
+			body = entry.Link
+		default:
+			header.SetMode(0o644)
+		}
+		w, err := writer.CreateHeader(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestZipExtractor(t *testing.T) {
+	for _, tt := range extractCases() {
+		t.Run(tt.name, func(t *testing.T) {
+			extractor := NewZipExtractor(testutil.Logger())
+			extractor.MaxFiles = tt.maxFiles
+			extractor.MaxBytes = tt.maxBytes
+			testExtract(t, tt, extractor, zipArchive(t, tt.entries))
+		})
+	}
+}
+
+// A bomb may declare a small size in its headers and hold much more.
+func TestZipExtractorLyingHeader(t *testing.T) {
+	content := []byte(strings.Repeat("\x00", 1<<20))
+	var compressed bytes.Buffer
+	deflater, err := flate.NewWriter(&compressed, flate.BestCompression)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deflater.Write(content)
+	deflater.Close()
+
+	var buf bytes.Buffer
+	writer := zip.NewWriter(&buf)
+	header := &zip.FileHeader{
+		Name:               "repo-main/bomb",
+		Method:             zip.Deflate,
+		CRC32:              crc32.ChecksumIEEE(content),
+		CompressedSize64:   uint64(compressed.Len()),
+		UncompressedSize64: 10,
+	}
+	w, err := writer.CreateRaw(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(compressed.Bytes())
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	extractor := NewZipExtractor(testutil.Logger())
+	extractor.MaxBytes = 1 << 10
+	targetDir := t.TempDir()
+	archive := buf.Bytes()
+	if _, err := extractor.Extract(context.Background(), bytes.NewReader(archive), int64(len(archive)), targetDir); err == nil {
+		t.Fatal("Extract succeeded, want an error")
+	}
+	if info, err := os.Stat(targetDir + "/bomb"); err == nil && info.Size() > extractor.MaxBytes+1 {
+		t.Errorf("%d bytes have been written, want at most %d", info.Size(), extractor.MaxBytes+1)
+	}
+}
@@ -0,0 +1,34 @@
+package dryrun
+
+import (
+	"context"
+	"io"
+
+	"github.com/olegshirko/reposqueeze/internal/domain/entity"
+	"github.com/olegshirko/reposqueeze/internal/domain/gateway"
+)
+
+// RecordingArchiveExtractor is an ArchiveExtractor that inspects archives instead of extracting them.
+type RecordingArchiveExtractor struct {
+	next gateway.ArchiveExtractor
+	plan *Plan
+}
+
+// NewRecordingArchiveExtractor creates a RecordingArchiveExtractor that inspects through next.
+func NewRecordingArchiveExtractor(next gateway.ArchiveExtractor, plan *Plan) *RecordingArchiveExtractor {
+	return &RecordingArchiveExtractor{next: next, plan: plan}
+}
+
+func (e *RecordingArchiveExtractor) Extract(ctx context.Context, archive io.ReaderAt, size int64, targetDir string) (*entity.ArchiveSummary, error) {
+	summary, err := e.next.Inspect(ctx, archive, size)
+	if err != nil {
+		return nil, err
+	}
+	e.plan.AddFiles(summary.Files, summary.Bytes)
+	e.plan.record("git", "extract %d files (%d bytes) into %s", summary.Files, summary.Bytes, targetDir)
+	return summary, nil
+}
+
+func (e *RecordingArchiveExtractor) Inspect(ctx context.Context, archive io.ReaderAt, size int64) (*entity.ArchiveSummary, error) {
+	return e.next.Inspect(ctx, archive, size)
+}
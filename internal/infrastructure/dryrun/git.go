@@ -0,0 +1,140 @@
+package dryrun
+
+import (
+	"context"
+
+	"github.com/olegshirko/reposqueeze/internal/domain/entity"
+	"github.com/olegshirko/reposqueeze/internal/domain/gateway"
+)
+
+// RecordingGitGateway is a GitGateway that records changes instead of making them.
+type RecordingGitGateway struct {
+	next gateway.GitGateway
+	plan *Plan
+
+	// sources maps the recorded branches to their source branches, which
+	// stand in for them when their files are read.
+	sources map[string]string
+	// excluded holds the exclusion rules recorded for a branch, which are not
+	// applied to its source.
+	excluded map[string]func(file string) bool
+}
+
+// NewRecordingGitGateway creates a RecordingGitGateway that reads through next.
+func NewRecordingGitGateway(next gateway.GitGateway, plan *Plan) *RecordingGitGateway {
+	return &RecordingGitGateway{next: next, plan: plan, sources: make(map[string]string), excluded: make(map[string]func(string) bool)}
+}
+
+// plannedCommit stands for the commits that are not made.
+var plannedCommit = &entity.CommitResult{CommitSHA: "(planned)", TreeSHA: "(planned)"}
+
+func (g *RecordingGitGateway) CreateOrphanBranch(ctx context.Context, repository *entity.Repository, branch *entity.Branch, sourceBranch string) (*entity.CommitResult, error) {
+	g.plan.record("git", "create orphan branch '%s' in %s with one commit of the files of '%s'", branch.Name, repository.Path, sourceBranch)
+	g.sources[branch.Name] = sourceBranch
+	return plannedCommit, nil
+}
+
+func (g *RecordingGitGateway) CreateSquashedBranch(ctx context.Context, repository *entity.Repository, branch *entity.Branch, sourceBranch string, keep entity.KeepHistory) (*entity.CommitResult, error) {
+	g.plan.record("git", "create branch '%s' from '%s' in %s, squash the history before %s into one root commit and replay them on top",
+		branch.Name, sourceBranch, repository.Path, keep)
+	g.sources[branch.Name] = sourceBranch
+	return plannedCommit, nil
+}
+
+// branch returns the branch to read instead of branchName: its source if it
+// is a recorded branch, which does not exist.
+func (g *RecordingGitGateway) branch(branchName string) string {
+	source, ok := g.sources[branchName]
+	if !ok {
+		return branchName
+	}
+	if source == "" {
+		return "HEAD"
+	}
+	return source
+}
+
+func (g *RecordingGitGateway) CreateEmptyOrphanBranch(ctx context.Context, repository *entity.Repository, branch *entity.Branch, sourceBranch string) error {
+	g.plan.record("git", "create empty orphan branch '%s' in %s", branch.Name, repository.Path)
+	return nil
+}
+
+func (g *RecordingGitGateway) CurrentBranch(ctx context.Context, repoPath string) (string, error) {
+	return g.next.CurrentBranch(ctx, repoPath)
+}
+
+func (g *RecordingGitGateway) Checkout(ctx context.Context, repoPath, ref string) error {
+	g.plan.record("git", "check out '%s' in %s, discarding the changes", ref, repoPath)
+	return nil
+}
+
+func (g *RecordingGitGateway) ListFiles(ctx context.Context, repoPath, branchName string) ([]string, error) {
+	return g.next.ListFiles(ctx, repoPath, g.branch(branchName))
+}
+
+func (g *RecordingGitGateway) ReadFiles(ctx context.Context, repoPath, branchName string, files []string) ([]entity.File, error) {
+	return g.next.ReadFiles(ctx, repoPath, g.branch(branchName), files)
+}
+
+func (g *RecordingGitGateway) DeleteLocalBranch(ctx context.Context, repoPath, branchName string) error {
+	g.plan.record("git", "delete local branch '%s'", branchName)
+	return nil
+}
+
+// ExcludeFiles records the excluded files of the last commit of the branch as skipped.
+func (g *RecordingGitGateway) ExcludeFiles(ctx context.Context, repoPath, branchName string, excluded func(file string) bool) (*entity.CommitResult, error) {
+	files, err := g.ListFiles(ctx, repoPath, branchName)
+	if err != nil {
+		return nil, err
+	}
+	var skipped []string
+	for _, file := range files {
+		if excluded(file) {
+			skipped = append(skipped, file)
+		}
+	}
+	g.plan.record("git", "leave %d excluded files out of the new commits of '%s'", len(skipped), branchName)
+	g.plan.skip(skipped...)
+	g.excluded[branchName] = excluded
+	return plannedCommit, nil
+}
+
+func (g *RecordingGitGateway) CleanWorkdir(ctx context.Context, repoPath string) error {
+	g.plan.record("git", "remove all untracked and ignored files from %s", repoPath)
+	return nil
+}
+
+func (g *RecordingGitGateway) Commit(ctx context.Context, repoPath, message string) (*entity.CommitResult, error) {
+	g.plan.record("git", "commit all files in %s with message %q", repoPath, message)
+	return plannedCommit, nil
+}
+
+// PushBranch records the push and accounts the files of the last commit of
+// localBranch as committed.
+func (g *RecordingGitGateway) PushBranch(ctx context.Context, repoPath, remoteURL, localBranch, remoteBranch string) error {
+	files, err := g.ListFiles(ctx, repoPath, localBranch)
+	if err != nil {
+		return err
+	}
+	if excluded := g.excluded[localBranch]; excluded != nil {
+		kept := files[:0]
+		for _, file := range files {
+			if !excluded(file) {
+				kept = append(kept, file)
+			}
+		}
+		files = kept
+	}
+	contents, err := g.ReadFiles(ctx, repoPath, localBranch, files)
+	if err != nil {
+		return err
+	}
+	var size int64
+	for _, file := range contents {
+		size += int64(len(file.Content))
+	}
+	g.plan.AddFiles(len(contents), size)
+	g.plan.record("git", "force-push branch '%s' with %d files (%d bytes) to branch '%s' of %s", localBranch, len(contents), size, remoteBranch, remoteURL)
+	g.plan.addBranch(remoteBranch)
+	return nil
+}
@@ -0,0 +1,88 @@
+package dryrun
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/olegshirko/reposqueeze/internal/app/usecase"
+	"github.com/olegshirko/reposqueeze/internal/domain/entity"
+	"github.com/olegshirko/reposqueeze/internal/infrastructure/git"
+	"github.com/olegshirko/reposqueeze/internal/testutil"
+)
+
+func TestSquashDryRun(t *testing.T) {
+	repo := testutil.NewRepo(t)
+	repo.Commit("initial", map[string]string{
+		"main.go":       "package main\n",          // 13 bytes
+		"README.md":     "# App\n",                 // 6 bytes
+		"vendor/lib.go": "package lib\n",           // excluded
+		"build/app.log": "a log that is skipped\n", // excluded
+	})
+	gitLab := testutil.NewFakeGitLab()
+	project := gitLab.AddProject(42, "app")
+	project.DefaultBranch = "master"
+	project.HTTPURLToRepo = "https://gitlab.example.com/group/app.git"
+	gitLab.SetBranch(42, "master", "old")
+	gitLab.SetBranch(42, "feature", "feature")
+	gitLab.Tags[42] = []entity.Tag{{Name: "v1.0.0"}}
+	gitLab.Protected[42] = []entity.ProtectedBranch{{Name: "master"}}
+	plan := NewPlan()
+	log := testutil.Logger()
+	uc := usecase.NewSquashUseCase(NewRecordingGitGateway(git.NewOSExecGitGateway(log), plan), NewRecordingGitLabGateway(gitLab, plan), log)
+
+	result, err := uc.Execute(context.Background(), usecase.SquashInput{
+		RepoPath:       repo.Path,
+		Excludes:       []string{"vendor/", "*.log"},
+		DeleteBranches: true,
+		DeleteTags:     true,
+	})
+	if err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+
+	if len(gitLab.Calls) > 0 {
+		t.Errorf("dry run changed GitLab: %v", gitLab.Calls)
+	}
+	if plan.FilesCount != 2 || plan.BytesCount != 19 {
+		t.Errorf("plan counts %d files (%d bytes), want 2 (19 bytes)", plan.FilesCount, plan.BytesCount)
+	}
+	if result.Files != 2 {
+		t.Errorf("result counts %d files, want 2", result.Files)
+	}
+	wantSkipped := []string{"build/app.log", "vendor/lib.go"}
+	if skipped := slices.Sorted(slices.Values(plan.SkippedFiles)); !slices.Equal(skipped, wantSkipped) {
+		t.Errorf("skipped files %v, want %v", skipped, wantSkipped)
+	}
+	for _, want := range []string{
+		"unprotect branch 'master' of project 42",
+		"with 2 files (19 bytes) to branch 'master' of https://gitlab.example.com/group/app.git",
+		"protect branch 'master' of project 42",
+		"delete branch 'feature' of project 42",
+		"delete tag 'v1.0.0' of project 42",
+	} {
+		if !planHasStep(plan, want) {
+			t.Errorf("plan has no step %q:\n%s", want, planSteps(plan))
+		}
+	}
+	if branches := repo.Branches(); !slices.Equal(branches, []string{"master"}) {
+		t.Errorf("dry run left branches %v in the repository", branches)
+	}
+}
+
+// planHasStep reports whether a step of plan contains text.
+func planHasStep(plan *Plan, text string) bool {
+	return slices.ContainsFunc(plan.Steps, func(step Step) bool {
+		return strings.Contains(step.Description, text)
+	})
+}
+
+// planSteps lists the steps of plan, one per line, for test failures.
+func planSteps(plan *Plan) string {
+	var lines []string
+	for _, step := range plan.Steps {
+		lines = append(lines, "["+step.Target+"] "+step.Description)
+	}
+	return strings.Join(lines, "\n")
+}
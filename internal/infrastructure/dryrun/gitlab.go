@@ -0,0 +1,191 @@
+package dryrun
+
+import (
+	"context"
+	"io"
+	"strconv"
+
+	"github.com/olegshirko/reposqueeze/internal/domain/entity"
+	"github.com/olegshirko/reposqueeze/internal/domain/gateway"
+)
+
+// plannedProjectID is the ID given to projects that would have been created.
+const plannedProjectID = 0
+
+// RecordingGitLabGateway is a GitLabGateway that records changes instead of making them.
+type RecordingGitLabGateway struct {
+	next gateway.GitLabGateway
+	plan *Plan
+}
+
+// NewRecordingGitLabGateway creates a RecordingGitLabGateway that reads through next.
+func NewRecordingGitLabGateway(next gateway.GitLabGateway, plan *Plan) *RecordingGitLabGateway {
+	return &RecordingGitLabGateway{next: next, plan: plan}
+}
+
+func (g *RecordingGitLabGateway) CommitFilesViaAPI(ctx context.Context, projectID, branchName, commitMessage string, actions []gateway.CommitAction) (*entity.Commit, error) {
+	var size int64
+	for _, action := range actions {
+		size += int64(len(action.Content))
+	}
+	g.plan.AddFiles(len(actions), size)
+	g.plan.record("gitlab", "commit %d files (%d bytes) to branch '%s' of project %s", len(actions), size, branchName, g.projectLabel(projectID))
+	if projectID == strconv.Itoa(plannedProjectID) {
+		g.plan.addBranch(branchName)
+	}
+	return &entity.Commit{SHA: "(planned)", Message: commitMessage}, nil
+}
+
+func (g *RecordingGitLabGateway) CreateRemoteBranch(ctx context.Context, projectID, branchName, refSHA string) error {
+	g.plan.record("gitlab", "create branch '%s' at %s in project %s", branchName, refSHA, g.projectLabel(projectID))
+	return nil
+}
+
+func (g *RecordingGitLabGateway) FindProjectByName(ctx context.Context, name string) (*entity.Project, error) {
+	return g.next.FindProjectByName(ctx, name)
+}
+
+func (g *RecordingGitLabGateway) GetProject(ctx context.Context, projectID int) (*entity.Project, error) {
+	if projectID == plannedProjectID {
+		return &entity.Project{ID: plannedProjectID}, nil
+	}
+	return g.next.GetProject(ctx, projectID)
+}
+
+func (g *RecordingGitLabGateway) GetProjectByPath(ctx context.Context, fullPath string) (*entity.Project, error) {
+	return g.next.GetProjectByPath(ctx, fullPath)
+}
+
+func (g *RecordingGitLabGateway) DeleteProject(ctx context.Context, projectID int) error {
+	g.plan.record("gitlab", "DELETE project %d", projectID)
+	return nil
+}
+
+func (g *RecordingGitLabGateway) GetNamespace(ctx context.Context, fullPath string) (*entity.Namespace, error) {
+	return g.next.GetNamespace(ctx, fullPath)
+}
+
+func (g *RecordingGitLabGateway) CreateProject(ctx context.Context, options gateway.CreateProjectOptions) (*entity.Project, error) {
+	description := options.Name
+	if options.Path != "" {
+		description += " (path " + options.Path + ")"
+	}
+	if options.NamespaceID != 0 {
+		description += " in namespace " + strconv.Itoa(options.NamespaceID)
+	}
+	if options.Visibility != "" {
+		description += ", visibility " + options.Visibility
+	}
+	if options.DefaultBranch != "" {
+		description += ", default branch " + options.DefaultBranch
+	}
+	g.plan.record("gitlab", "create project %s", description)
+	path := options.Path
+	if path == "" {
+		path = options.Name
+	}
+	return &entity.Project{
+		ID:            plannedProjectID,
+		Name:          options.Name,
+		Path:          path,
+		HTTPURLToRepo: "(new project, https)",
+		SSHURLToRepo:  "(new project, ssh)",
+	}, nil
+}
+
+func (g *RecordingGitLabGateway) RenameProject(ctx context.Context, projectID int, name, path string) (*entity.Project, error) {
+	g.plan.record("gitlab", "rename project %d to %s (path %s)", projectID, name, path)
+	return &entity.Project{ID: projectID, Name: name, Path: path}, nil
+}
+
+func (g *RecordingGitLabGateway) SnapshotProjectSettings(ctx context.Context, projectID int) (*entity.ProjectSettings, error) {
+	if projectID == plannedProjectID {
+		return &entity.ProjectSettings{}, nil
+	}
+	return g.next.SnapshotProjectSettings(ctx, projectID)
+}
+
+func (g *RecordingGitLabGateway) RestoreProjectSettings(ctx context.Context, projectID int, settings *entity.ProjectSettings) ([]entity.SettingFailure, error) {
+	g.plan.record("gitlab", "restore settings of project %s: %d members, %d variables, %d protected branches, %d webhooks, %d labels, %d topics, avatar %t",
+		g.projectLabel(strconv.Itoa(projectID)), len(settings.Members), len(settings.Variables), len(settings.ProtectedBranches),
+		len(settings.Hooks), len(settings.Labels), len(settings.Topics), settings.Avatar != nil)
+	return nil, nil
+}
+
+func (g *RecordingGitLabGateway) GetBranch(ctx context.Context, projectID, branchName string) (*entity.Branch, error) {
+	if g.plan.hasBranch(branchName) {
+		return &entity.Branch{Name: branchName, CommitSHA: "(planned)"}, nil
+	}
+	if projectID == strconv.Itoa(plannedProjectID) {
+		return nil, nil
+	}
+	return g.next.GetBranch(ctx, projectID, branchName)
+}
+
+func (g *RecordingGitLabGateway) ListBranches(ctx context.Context, projectID int) ([]entity.Branch, error) {
+	if projectID == plannedProjectID {
+		return nil, nil
+	}
+	return g.next.ListBranches(ctx, projectID)
+}
+
+func (g *RecordingGitLabGateway) DeleteBranch(ctx context.Context, projectID int, branchName string) error {
+	g.plan.record("gitlab", "delete branch '%s' of project %d", branchName, projectID)
+	return nil
+}
+
+func (g *RecordingGitLabGateway) ListTags(ctx context.Context, projectID int) ([]entity.Tag, error) {
+	if projectID == plannedProjectID {
+		return nil, nil
+	}
+	return g.next.ListTags(ctx, projectID)
+}
+
+func (g *RecordingGitLabGateway) DeleteTag(ctx context.Context, projectID int, tagName string) error {
+	g.plan.record("gitlab", "delete tag '%s' of project %d", tagName, projectID)
+	return nil
+}
+
+func (g *RecordingGitLabGateway) ListProtectedBranches(ctx context.Context, projectID int) ([]entity.ProtectedBranch, error) {
+	if projectID == plannedProjectID {
+		return nil, nil
+	}
+	return g.next.ListProtectedBranches(ctx, projectID)
+}
+
+func (g *RecordingGitLabGateway) ProtectBranch(ctx context.Context, projectID int, branch entity.ProtectedBranch) error {
+	g.plan.record("gitlab", "protect branch '%s' of project %d", branch.Name, projectID)
+	return nil
+}
+
+func (g *RecordingGitLabGateway) UnprotectBranch(ctx context.Context, projectID int, name string) error {
+	g.plan.record("gitlab", "unprotect branch '%s' of project %d", name, projectID)
+	return nil
+}
+
+func (g *RecordingGitLabGateway) DownloadRepoArchive(ctx context.Context, projectID int, options gateway.ArchiveOptions, writer io.Writer) error {
+	format, ref := options.Format, options.Ref
+	if format == "" {
+		format = entity.ArchiveZip
+	}
+	if ref == "" {
+		ref = "the default branch"
+	}
+	what := "repository"
+	if options.Path != "" {
+		what = "directory " + options.Path
+	}
+	g.plan.record("gitlab", "download %s archive of the %s at %s of project %d", format, what, ref, projectID)
+	return g.next.DownloadRepoArchive(ctx, projectID, options, writer)
+}
+
+func (g *RecordingGitLabGateway) GetVersion(ctx context.Context) (*entity.GitLabVersion, error) {
+	return g.next.GetVersion(ctx)
+}
+
+func (g *RecordingGitLabGateway) projectLabel(projectID string) string {
+	if projectID == strconv.Itoa(plannedProjectID) {
+		return "(new)"
+	}
+	return projectID
+}
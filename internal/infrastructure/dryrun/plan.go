@@ -0,0 +1,64 @@
+// Package dryrun provides recording implementations of the gateways.
+// They forward read-only calls to the real gateways and record every call
+// that would change a repository or GitLab, so a use case can be walked
+// through without side effects and the recorded steps printed as a plan.
+package dryrun
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Step is a single action that would have been performed.
+type Step struct {
+	Target      string // "git" or "gitlab"
+	Description string
+}
+
+// Plan collects the steps recorded by the gateways of one dry run.
+type Plan struct {
+	mu           sync.Mutex
+	Steps        []Step
+	SkippedFiles []string
+	FilesCount   int
+	BytesCount   int64
+
+	branches map[string]bool // branches that would be created or replaced
+}
+
+// NewPlan creates an empty plan.
+func NewPlan() *Plan {
+	return &Plan{branches: map[string]bool{}}
+}
+
+func (p *Plan) record(target, format string, args ...interface{}) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.Steps = append(p.Steps, Step{Target: target, Description: fmt.Sprintf(format, args...)})
+}
+
+func (p *Plan) skip(files ...string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.SkippedFiles = append(p.SkippedFiles, files...)
+}
+
+// AddFiles accounts files that would be committed.
+func (p *Plan) AddFiles(count int, bytes int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.FilesCount += count
+	p.BytesCount += bytes
+}
+
+func (p *Plan) addBranch(name string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.branches[name] = true
+}
+
+func (p *Plan) hasBranch(name string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.branches[name]
+}
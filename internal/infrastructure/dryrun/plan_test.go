@@ -0,0 +1,72 @@
+package dryrun
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/olegshirko/reposqueeze/internal/app/usecase"
+	"github.com/olegshirko/reposqueeze/internal/infrastructure/git"
+	"github.com/olegshirko/reposqueeze/internal/testutil"
+)
+
+func TestCreateFromLocalDryRun(t *testing.T) {
+	tests := []struct {
+		transport usecase.Transport
+		upload    string // text of the upload step
+	}{
+		{usecase.TransportAPI, "commit 2 files (19 bytes) to branch 'main' of project (new)"},
+		{usecase.TransportPush, "with 2 files (19 bytes) to branch 'main' of (new project, https)"},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.transport), func(t *testing.T) {
+			repo := testutil.NewRepo(t)
+			repo.Commit("initial", map[string]string{
+				"main.go":       "package main\n", // 13 bytes
+				"README.md":     "# App\n",        // 6 bytes
+				"vendor/lib.go": "package lib\n",  // excluded
+			})
+			gitLab := testutil.NewFakeGitLab()
+			gitLab.AddProject(42, "app")
+			plan := NewPlan()
+			log := testutil.Logger()
+			uc := usecase.NewCreateAndPushOrphanBranchUseCase(NewRecordingGitGateway(git.NewOSExecGitGateway(log), plan), NewRecordingGitLabGateway(gitLab, plan), log)
+
+			_, err := uc.Execute(context.Background(), usecase.Input{
+				RepoPath:   repo.Path,
+				BranchName: "main",
+				Transport:  tt.transport,
+				Excludes:   []string{"vendor/"},
+			})
+			if err != nil {
+				t.Fatalf("Execute: %v", err)
+			}
+
+			if len(gitLab.Calls) > 0 {
+				t.Errorf("dry run changed GitLab: %v", gitLab.Calls)
+			}
+			if plan.FilesCount != 2 || plan.BytesCount != 19 {
+				t.Errorf("plan counts %d files (%d bytes), want 2 (19 bytes)", plan.FilesCount, plan.BytesCount)
+			}
+			if !slices.Equal(plan.SkippedFiles, []string{"vendor/lib.go"}) {
+				t.Errorf("skipped files %v, want [vendor/lib.go]", plan.SkippedFiles)
+			}
+			for _, want := range []string{
+				"create orphan branch",
+				"leave 1 excluded files out",
+				"rename project 42 to app-backup-",
+				"create project app (path app)",
+				tt.upload,
+				"DELETE project 42",
+				"delete local branch 'reposqueeze-orphan-",
+			} {
+				if !planHasStep(plan, want) {
+					t.Errorf("plan has no step %q:\n%s", want, planSteps(plan))
+				}
+			}
+			if branches := repo.Branches(); !slices.Equal(branches, []string{"master"}) {
+				t.Errorf("dry run left branches %v in the repository", branches)
+			}
+		})
+	}
+}
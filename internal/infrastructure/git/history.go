@@ -0,0 +1,289 @@
+package git
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/olegshirko/reposqueeze/internal/domain/entity"
+)
+
+// commitFormat prints what is needed to recreate a commit, separated by NUL bytes.
+// The message comes last, so it may contain anything but NUL.
+const commitFormat = "%T%x00%an%x00%ae%x00%ad%x00%cn%x00%ce%x00%cd%x00%B"
+
+// commitInfo holds the tree, the identities and the message of a commit.
+type commitInfo struct {
+	Tree    string
+	Env     []string // GIT_AUTHOR_* and GIT_COMMITTER_* variables that reproduce the identities and dates
+	Message string
+}
+
+// run runs git in repoPath with extra environment variables and stdin and returns its raw output.
+func (g *OSExecGitGateway) run(ctx context.Context, repoPath string, env []string, stdin string, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", append([]string{"-C", repoPath}, args...)...)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdin = strings.NewReader(stdin)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		err = commandError(ctx, cmd, stderr.Bytes(), err)
+		g.logger.Errorf("failed to run git %s: %v", args[0], err)
+		return "", err
+	}
+	return string(output), nil
+}
+
+// commandError returns the entity.GitCommandError for a git command that has
+// failed with err after printing output about it. A command killed because
+// ctx is done fails with the error of ctx as well, so that a cancelled run is
+// not reported as a failure of git.
+func commandError(ctx context.Context, cmd *exec.Cmd, output []byte, err error) error {
+	args := cmd.Args[1:]
+	if len(args) >= 2 && args[0] == "-C" {
+		args = args[2:]
+	}
+	exitCode := -1
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		exitCode = exitErr.ExitCode()
+		if output == nil {
+			// Output fills in the stderr of a command run without one.
+			output = exitErr.Stderr
+		}
+	}
+	gitErr := &entity.GitCommandError{Args: args, ExitCode: exitCode, Stderr: string(output), Err: err}
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return fmt.Errorf("%w: %w", ctxErr, gitErr)
+	}
+	return gitErr
+}
+
+// readCommit reads the tree, the author, the committer and the message of a commit.
+func (g *OSExecGitGateway) readCommit(ctx context.Context, repoPath, commit string) (*commitInfo, error) {
+	output, err := g.run(ctx, repoPath, nil, "", "log", "-1", "--no-show-signature", "--date=raw", "--format="+commitFormat, commit)
+	if err != nil {
+		return nil, err
+	}
+	fields := strings.SplitN(output, "\x00", 8)
+	if len(fields) != 8 {
+		return nil, fmt.Errorf("unexpected output of git log for commit %s", commit)
+	}
+	return &commitInfo{
+		Tree: fields[0],
+		Env: []string{
+			"GIT_AUTHOR_NAME=" + fields[1],
+			"GIT_AUTHOR_EMAIL=" + fields[2],
+			"GIT_AUTHOR_DATE=" + fields[3],
+			"GIT_COMMITTER_NAME=" + fields[4],
+			"GIT_COMMITTER_EMAIL=" + fields[5],
+			"GIT_COMMITTER_DATE=" + fields[6],
+		},
+		// git log adds a line break after the message.
+		Message: strings.TrimRight(fields[7], "\n") + "\n",
+	}, nil
+}
+
+// writeCommit creates a commit of tree with the identities and message of info.
+// An empty parent creates a root commit.
+func (g *OSExecGitGateway) writeCommit(ctx context.Context, repoPath string, info *commitInfo, tree, parent string) (string, error) {
+	args := []string{"commit-tree", tree}
+	if parent != "" {
+		args = append(args, "-p", parent)
+	}
+	output, err := g.run(ctx, repoPath, info.Env, info.Message, args...)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(output), nil
+}
+
+// describeCommit resolves a commit and sums up the files of its tree.
+func (g *OSExecGitGateway) describeCommit(ctx context.Context, repoPath, commit string) (*entity.CommitResult, error) {
+	output, err := g.run(ctx, repoPath, nil, "", "rev-parse", commit+"^{commit}", commit+"^{tree}")
+	if err != nil {
+		return nil, err
+	}
+	hashes := strings.Fields(output)
+	if len(hashes) != 2 {
+		return nil, fmt.Errorf("unexpected output of git rev-parse for commit %s", commit)
+	}
+	result := &entity.CommitResult{CommitSHA: hashes[0], TreeSHA: hashes[1]}
+
+	output, err = g.run(ctx, repoPath, nil, "", "ls-tree", "-r", "-l", "-z", "--full-tree", hashes[0])
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range strings.Split(output, "\x00") {
+		// Each line is "<mode> <type> <object> <size>\t<file>"; submodules have no size.
+		info, _, _ := strings.Cut(line, "\t")
+		fields := strings.Fields(info)
+		if len(fields) != 4 || fields[1] != "blob" {
+			continue
+		}
+		size, err := strconv.ParseInt(fields[3], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("unexpected output of git ls-tree for commit %s: %q", commit, info)
+		}
+		result.Files++
+		result.Bytes += size
+	}
+	return result, nil
+}
+
+// createBranch points a new branch at commit. It fails if the branch exists.
+func (g *OSExecGitGateway) createBranch(ctx context.Context, repoPath, branchName, commit string) error {
+	// An empty old value makes update-ref check that the ref does not exist yet.
+	_, err := g.run(ctx, repoPath, nil, "", "update-ref", "refs/heads/"+branchName, commit, "")
+	return err
+}
+
+// CreateSquashedBranch creates a branch whose root commit holds the files of
+// sourceBranch as they were before the kept commits, and replays the kept
+// commits on top of it with their original messages, authors and dates.
+// Only the first-parent history is followed, so a kept merge commit becomes an
+// ordinary commit with the merged files.
+func (g *OSExecGitGateway) CreateSquashedBranch(ctx context.Context, repository *entity.Repository, branch *entity.Branch, sourceBranch string, keep entity.KeepHistory) (*entity.CommitResult, error) {
+	source := sourceBranch
+	if source == "" {
+		source = "HEAD"
+	}
+
+	args := []string{"rev-list", "--first-parent"}
+	if keep.Last > 0 {
+		args = append(args, "--max-count="+strconv.Itoa(keep.Last))
+	}
+	if !keep.Since.IsZero() {
+		args = append(args, "--since="+keep.Since.Format(time.RFC3339))
+	}
+	output, err := g.run(ctx, repository.Path, nil, "", append(args, source, "--")...)
+	if err != nil {
+		return nil, err
+	}
+	kept := strings.Fields(output)
+	if len(kept) == 0 {
+		g.logger.Infof("No commits of %s to keep, squashing the whole history", source)
+		return g.CreateOrphanBranch(ctx, repository, branch, sourceBranch)
+	}
+	// rev-list lists the newest commit first.
+	for i, j := 0, len(kept)-1; i < j; i, j = i+1, j-1 {
+		kept[i], kept[j] = kept[j], kept[i]
+	}
+
+	// The parents of the oldest kept commit; none if the whole history is kept.
+	output, err = g.run(ctx, repository.Path, nil, "", "rev-parse", kept[0]+"^@")
+	if err != nil {
+		return nil, err
+	}
+	parent := ""
+	if parents := strings.Fields(output); len(parents) > 0 {
+		cutoff := parents[0]
+		info, err := g.readCommit(ctx, repository.Path, cutoff)
+		if err != nil {
+			return nil, err
+		}
+		info.Message = fmt.Sprintf("Initial commit on orphan branch\n\nSquashes the history up to commit %s.\n", cutoff)
+		if parent, err = g.writeCommit(ctx, repository.Path, info, info.Tree, ""); err != nil {
+			return nil, err
+		}
+		g.logger.Infof("Squashed the history up to commit %s into root commit %s", cutoff, parent)
+	}
+
+	for _, commit := range kept {
+		info, err := g.readCommit(ctx, repository.Path, commit)
+		if err != nil {
+			return nil, err
+		}
+		if parent, err = g.writeCommit(ctx, repository.Path, info, info.Tree, parent); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := g.createBranch(ctx, repository.Path, branch.Name, parent); err != nil {
+		return nil, err
+	}
+
+	g.logger.Infof("new commit SHA: %s, %d commits kept on top of the squashed history", parent, len(kept))
+	return g.describeCommit(ctx, repository.Path, parent)
+}
+
+// ExcludeFiles rewrites the commits of a branch without the files for which
+// excluded returns true. The branch must consist of new commits only, as made
+// by CreateOrphanBranch or CreateSquashedBranch. Trees are built in a temporary
+// index, so the index and the working tree are not touched. Paths are read
+// from stdin, so the command line does not grow with the number of files.
+func (g *OSExecGitGateway) ExcludeFiles(ctx context.Context, repoPath, branchName string, excluded func(file string) bool) (*entity.CommitResult, error) {
+	output, err := g.run(ctx, repoPath, nil, "", "rev-list", "--reverse", "--first-parent", "refs/heads/"+branchName)
+	if err != nil {
+		return nil, err
+	}
+
+	tempDir, err := os.MkdirTemp("", "reposqueeze-index-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tempDir)
+	indexEnv := []string{"GIT_INDEX_FILE=" + filepath.Join(tempDir, "index")}
+
+	// Commits up to the first one with excluded files are kept as they are.
+	parent, rewritten := "", false
+	removed := make(map[string]bool)
+	for _, commit := range strings.Fields(output) {
+		tree, err := g.run(ctx, repoPath, nil, "", "ls-tree", "-r", "-z", "--name-only", commit)
+		if err != nil {
+			return nil, err
+		}
+		var files []string
+		for _, file := range strings.Split(tree, "\x00") {
+			if file != "" && excluded(file) {
+				files = append(files, file)
+				removed[file] = true
+			}
+		}
+		if len(files) == 0 && !rewritten {
+			parent = commit
+			continue
+		}
+
+		info, err := g.readCommit(ctx, repoPath, commit)
+		if err != nil {
+			return nil, err
+		}
+		newTree := info.Tree
+		if len(files) > 0 {
+			if _, err := g.run(ctx, repoPath, indexEnv, "", "read-tree", commit); err != nil {
+				return nil, err
+			}
+			// update-index removes the entries without comparing them to the
+			// working tree, which may hold other versions of the files.
+			if _, err := g.run(ctx, repoPath, indexEnv, strings.Join(files, "\x00")+"\x00", "update-index", "--force-remove", "-z", "--stdin"); err != nil {
+				return nil, err
+			}
+			if newTree, err = g.run(ctx, repoPath, indexEnv, "", "write-tree"); err != nil {
+				return nil, err
+			}
+			newTree = strings.TrimSpace(newTree)
+		}
+		if parent, err = g.writeCommit(ctx, repoPath, info, newTree, parent); err != nil {
+			return nil, err
+		}
+		rewritten = true
+	}
+	if !rewritten {
+		return g.describeCommit(ctx, repoPath, parent)
+	}
+
+	if _, err := g.run(ctx, repoPath, nil, "", "update-ref", "refs/heads/"+branchName, parent); err != nil {
+		return nil, err
+	}
+	g.logger.Infof("new commit SHA without %d excluded files: %s", len(removed), parent)
+	return g.describeCommit(ctx, repoPath, parent)
+}
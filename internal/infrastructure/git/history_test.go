@@ -0,0 +1,112 @@
+package git
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/olegshirko/reposqueeze/internal/domain/entity"
+	"github.com/olegshirko/reposqueeze/internal/domain/gateway"
+	"github.com/olegshirko/reposqueeze/internal/testutil"
+)
+
+// backends returns both implementations of the git gateway.
+func backends() map[string]gateway.GitGateway {
+	return map[string]gateway.GitGateway{
+		"exec":   NewOSExecGitGateway(testutil.Logger()),
+		"native": NewNativeGitGateway(testutil.Logger()),
+	}
+}
+
+func excludeSecrets(file string) bool {
+	return strings.HasSuffix(file, ".secret")
+}
+
+// assertExcluded checks that no commit of branch holds an excluded file.
+func assertExcluded(t *testing.T, r *testutil.Repo, branch string, commits int) {
+	t.Helper()
+	history := strings.Fields(r.Git("rev-list", "refs/heads/"+branch))
+	if len(history) != commits {
+		t.Fatalf("branch %s has %d commits, want %d", branch, len(history), commits)
+	}
+	for _, commit := range history {
+		for _, file := range r.Files(commit) {
+			if excludeSecrets(file) {
+				t.Errorf("commit %s still holds %s", commit, file)
+			}
+		}
+	}
+}
+
+func TestExcludeFilesKeepsChangedHistory(t *testing.T) {
+	for name, git := range backends() {
+		t.Run(name, func(t *testing.T) {
+			r := testutil.NewRepo(t)
+			r.Commit("first", map[string]string{"a.txt": "a1", "key.secret": "v1"})
+			r.Commit("second", map[string]string{"key.secret": "v2"})
+			r.Commit("third", map[string]string{"key.secret": "v3"})
+			r.Commit("fourth", map[string]string{"a.txt": "a2", "key.secret": "v4"})
+
+			ctx := context.Background()
+			branch := &entity.Branch{Name: "squashed"}
+			keep := entity.KeepHistory{Last: 3}
+			if _, err := git.CreateSquashedBranch(ctx, &entity.Repository{Path: r.Path}, branch, "master", keep); err != nil {
+				t.Fatalf("CreateSquashedBranch: %v", err)
+			}
+			result, err := git.ExcludeFiles(ctx, r.Path, branch.Name, excludeSecrets)
+			if err != nil {
+				t.Fatalf("ExcludeFiles: %v", err)
+			}
+
+			// The root commit with the squashed history and the three kept ones.
+			assertExcluded(t, r, branch.Name, 4)
+			if files := r.Files(branch.Name); !slices.Equal(files, []string{"a.txt"}) {
+				t.Errorf("files = %v, want [a.txt]", files)
+			}
+			if result.Files != 1 || result.Bytes != 2 {
+				t.Errorf("result has %d files of %d bytes, want 1 of 2", result.Files, result.Bytes)
+			}
+		})
+	}
+}
+
+func TestExcludeFilesIgnoresCheckout(t *testing.T) {
+	for name, git := range backends() {
+		t.Run(name, func(t *testing.T) {
+			r := testutil.NewRepo(t)
+			r.Commit("first", map[string]string{"a.txt": "a", "key.secret": "master"})
+			r.Git("checkout", "--quiet", "-b", "feature")
+			r.Commit("feature", map[string]string{"b.txt": "b", "key.secret": "feature"})
+			r.Git("checkout", "--quiet", "master")
+			// Local edits, staged and not, to an excluded file and another one.
+			r.Write(map[string]string{"key.secret": "staged"})
+			r.Git("add", "key.secret")
+			r.Write(map[string]string{"key.secret": "edited", "a.txt": "edited"})
+			status := r.Git("status", "--porcelain")
+
+			ctx := context.Background()
+			branch := &entity.Branch{Name: "orphan"}
+			if _, err := git.CreateOrphanBranch(ctx, &entity.Repository{Path: r.Path}, branch, "feature"); err != nil {
+				t.Fatalf("CreateOrphanBranch: %v", err)
+			}
+			if _, err := git.ExcludeFiles(ctx, r.Path, branch.Name, excludeSecrets); err != nil {
+				t.Fatalf("ExcludeFiles: %v", err)
+			}
+
+			assertExcluded(t, r, branch.Name, 1)
+			if files := r.Files(branch.Name); !slices.Equal(files, []string{"a.txt", "b.txt"}) {
+				t.Errorf("files = %v, want [a.txt b.txt]", files)
+			}
+			if head := r.Git("symbolic-ref", "--short", "HEAD"); head != "master" {
+				t.Errorf("HEAD is at %s, want master", head)
+			}
+			if got := r.Git("status", "--porcelain"); got != status {
+				t.Errorf("status changed from\n%s\nto\n%s", status, got)
+			}
+			if got := r.Git("show", ":key.secret"); got != "staged" {
+				t.Errorf("staged key.secret = %q, want %q", got, "staged")
+			}
+		})
+	}
+}
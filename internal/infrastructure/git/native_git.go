@@ -0,0 +1,690 @@
+package git
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/format/index"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+
+	"github.com/olegshirko/reposqueeze/internal/domain/entity"
+	"github.com/olegshirko/reposqueeze/internal/pkg/logger"
+)
+
+// defaultSignature identifies the commits made by reposqueeze when no user is configured.
+var defaultSignature = object.Signature{Name: "reposqueeze", Email: "reposqueeze@localhost"}
+
+// NativeGitGateway is an implementation of the GitGateway that reads and writes
+// the object database directly, without the git executable. It does not depend
+// on the user's git config beyond user.name and user.email and runs no hooks.
+// New commits reuse the trees of the source commits, so building a branch
+// reads and writes no file of the working tree.
+type NativeGitGateway struct {
+	// HTTPToken authenticates pushes to HTTPS remotes.
+	HTTPToken string
+	logger    logger.Logger
+}
+
+// NewNativeGitGateway creates a new instance of NativeGitGateway.
+func NewNativeGitGateway(log logger.Logger) *NativeGitGateway {
+	return &NativeGitGateway{logger: log}
+}
+
+// open opens the repository at repoPath, unless ctx is done already, so that
+// a cancelled run stops before the next step.
+func (g *NativeGitGateway) open(ctx context.Context, repoPath string) (*gogit.Repository, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	repo, err := gogit.PlainOpenWithOptions(repoPath, &gogit.PlainOpenOptions{EnableDotGitCommonDir: true})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open repository %s: %w", repoPath, err)
+	}
+	return repo, nil
+}
+
+// wrapError turns the failure of an operation into an entity.GitError, the
+// counterpart of the entity.GitCommandError of OSExecGitGateway. A failure
+// after ctx is done fails with the error of ctx as well, so that a cancelled
+// run is not reported as a failure of git.
+func wrapError(ctx context.Context, operation string, err *error) {
+	var gitErr *entity.GitError
+	if *err == nil || errors.As(*err, &gitErr) {
+		return
+	}
+	gitErr = &entity.GitError{Operation: operation, Err: *err}
+	if ctxErr := ctx.Err(); ctxErr != nil && !errors.Is(*err, ctxErr) {
+		*err = fmt.Errorf("%w: %w", ctxErr, gitErr)
+		return
+	}
+	*err = gitErr
+}
+
+// CreateOrphanBranch creates a branch with a single commit that holds the tree
+// of sourceBranch, or of HEAD if sourceBranch is empty.
+func (g *NativeGitGateway) CreateOrphanBranch(ctx context.Context, repository *entity.Repository, branch *entity.Branch, sourceBranch string) (_ *entity.CommitResult, err error) {
+	defer wrapError(ctx, "create orphan branch", &err)
+	repo, err := g.open(ctx, repository.Path)
+	if err != nil {
+		return nil, err
+	}
+	source, err := resolveCommit(repo, sourceBranch)
+	if err != nil {
+		return nil, err
+	}
+
+	signature := g.signature(repo)
+	commit, err := writeCommit(repo, &object.Commit{
+		Author:    signature,
+		Committer: signature,
+		Message:   "Initial commit on orphan branch\n",
+		TreeHash:  source.TreeHash,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := createBranch(repo, branch.Name, commit); err != nil {
+		return nil, err
+	}
+
+	g.logger.Infof("new commit SHA: %s", commit)
+	return describeCommit(repo, commit)
+}
+
+// CreateSquashedBranch works like OSExecGitGateway.CreateSquashedBranch and
+// produces the same commits.
+func (g *NativeGitGateway) CreateSquashedBranch(ctx context.Context, repository *entity.Repository, branch *entity.Branch, sourceBranch string, keep entity.KeepHistory) (_ *entity.CommitResult, err error) {
+	defer wrapError(ctx, "create squashed branch", &err)
+	repo, err := g.open(ctx, repository.Path)
+	if err != nil {
+		return nil, err
+	}
+	tip, err := resolveCommit(repo, sourceBranch)
+	if err != nil {
+		return nil, err
+	}
+
+	// Walk the first-parent history from the newest commit; cutoff is the
+	// newest commit that is not kept, if any.
+	var kept []*object.Commit
+	cutoff := tip
+	for cutoff != nil {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if keep.Last > 0 && len(kept) == keep.Last {
+			break
+		}
+		if !keep.Since.IsZero() && cutoff.Committer.When.Before(keep.Since) {
+			break
+		}
+		kept = append(kept, cutoff)
+		if cutoff.NumParents() == 0 {
+			cutoff = nil
+			break
+		}
+		if cutoff, err = cutoff.Parent(0); err != nil {
+			return nil, err
+		}
+	}
+	if len(kept) == 0 {
+		g.logger.Infof("No commits of %s to keep, squashing the whole history", sourceBranch)
+		return g.CreateOrphanBranch(ctx, repository, branch, sourceBranch)
+	}
+
+	var parents []plumbing.Hash
+	if cutoff != nil {
+		root, err := writeCommit(repo, &object.Commit{
+			Author:    cutoff.Author,
+			Committer: cutoff.Committer,
+			Message:   fmt.Sprintf("Initial commit on orphan branch\n\nSquashes the history up to commit %s.\n", cutoff.Hash),
+			TreeHash:  cutoff.TreeHash,
+		})
+		if err != nil {
+			return nil, err
+		}
+		g.logger.Infof("Squashed the history up to commit %s into root commit %s", cutoff.Hash, root)
+		parents = []plumbing.Hash{root}
+	}
+
+	var head plumbing.Hash
+	for i := len(kept) - 1; i >= 0; i-- {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if head, err = writeCommit(repo, replayed(kept[i], kept[i].TreeHash, parents)); err != nil {
+			return nil, err
+		}
+		parents = []plumbing.Hash{head}
+	}
+	if err := createBranch(repo, branch.Name, head); err != nil {
+		return nil, err
+	}
+
+	g.logger.Infof("new commit SHA: %s, %d commits kept on top of the squashed history", head, len(kept))
+	return describeCommit(repo, head)
+}
+
+// CreateEmptyOrphanBranch makes an unborn branch current and empties the index.
+// The working tree is not touched.
+func (g *NativeGitGateway) CreateEmptyOrphanBranch(ctx context.Context, repository *entity.Repository, branch *entity.Branch, sourceBranch string) (err error) {
+	defer wrapError(ctx, "create empty orphan branch", &err)
+	repo, err := g.open(ctx, repository.Path)
+	if err != nil {
+		return err
+	}
+	name := plumbing.NewBranchReferenceName(branch.Name)
+	if _, err := repo.Storer.Reference(name); err == nil {
+		return fmt.Errorf("a branch named '%s' already exists", branch.Name)
+	}
+	if err := repo.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, name)); err != nil {
+		return err
+	}
+	return repo.Storer.SetIndex(&index.Index{Version: 2})
+}
+
+// CurrentBranch returns the name of the checked out branch, or the commit SHA if HEAD is detached.
+func (g *NativeGitGateway) CurrentBranch(ctx context.Context, repoPath string) (_ string, err error) {
+	defer wrapError(ctx, "read current branch", &err)
+	repo, err := g.open(ctx, repoPath)
+	if err != nil {
+		return "", err
+	}
+	head, err := repo.Storer.Reference(plumbing.HEAD)
+	if err != nil {
+		return "", fmt.Errorf("failed to read HEAD: %w", err)
+	}
+	if head.Type() == plumbing.SymbolicReference {
+		return head.Target().Short(), nil
+	}
+	return head.Hash().String(), nil
+}
+
+// Checkout checks out a branch or commit, discarding the changes of the index
+// and the working tree. Untracked files are left alone.
+func (g *NativeGitGateway) Checkout(ctx context.Context, repoPath, ref string) (err error) {
+	defer wrapError(ctx, "checkout", &err)
+	repo, err := g.open(ctx, repoPath)
+	if err != nil {
+		return err
+	}
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return err
+	}
+	options := &gogit.CheckoutOptions{Force: true}
+	if _, err := repo.Storer.Reference(plumbing.NewBranchReferenceName(ref)); err == nil {
+		options.Branch = plumbing.NewBranchReferenceName(ref)
+	} else {
+		commit, err := resolveCommit(repo, ref)
+		if err != nil {
+			return err
+		}
+		options.Hash = commit.Hash
+	}
+	if err := worktree.Checkout(options); err != nil {
+		return fmt.Errorf("failed to check out '%s': %w", ref, err)
+	}
+	return nil
+}
+
+// ListFiles lists the files of the last commit of a branch.
+func (g *NativeGitGateway) ListFiles(ctx context.Context, repoPath, branchName string) (_ []string, err error) {
+	defer wrapError(ctx, "list files", &err)
+	repo, err := g.open(ctx, repoPath)
+	if err != nil {
+		return nil, err
+	}
+	commit, err := resolveCommit(repo, branchName)
+	if err != nil {
+		return nil, err
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	walker := object.NewTreeWalker(tree, true, nil)
+	defer walker.Close()
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		name, e, err := walker.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if e.Mode != filemode.Dir {
+			files = append(files, name)
+		}
+	}
+	return files, nil
+}
+
+// ReadFiles reads files of the last commit of a branch.
+func (g *NativeGitGateway) ReadFiles(ctx context.Context, repoPath, branchName string, files []string) (_ []entity.File, err error) {
+	defer wrapError(ctx, "read files", &err)
+	repo, err := g.open(ctx, repoPath)
+	if err != nil {
+		return nil, err
+	}
+	commit, err := resolveCommit(repo, branchName)
+	if err != nil {
+		return nil, err
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]entity.File, 0, len(files))
+	for _, name := range files {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		file, err := tree.File(name)
+		if err != nil {
+			return nil, fmt.Errorf("file %s not found in branch '%s': %w", name, branchName, err)
+		}
+		reader, err := file.Reader()
+		if err != nil {
+			return nil, err
+		}
+		content, err := io.ReadAll(reader)
+		reader.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", name, err)
+		}
+		result = append(result, entity.File{Path: name, Content: content, Executable: file.Mode == filemode.Executable})
+	}
+	return result, nil
+}
+
+// DeleteLocalBranch deletes a local branch.
+func (g *NativeGitGateway) DeleteLocalBranch(ctx context.Context, repoPath, branchName string) (err error) {
+	defer wrapError(ctx, "delete branch", &err)
+	repo, err := g.open(ctx, repoPath)
+	if err != nil {
+		return err
+	}
+	name := plumbing.NewBranchReferenceName(branchName)
+	if _, err := repo.Storer.Reference(name); err != nil {
+		return fmt.Errorf("failed to delete local branch '%s': %w", branchName, err)
+	}
+	return repo.Storer.RemoveReference(name)
+}
+
+// ExcludeFiles works like OSExecGitGateway.ExcludeFiles, building the filtered
+// trees directly in the object database.
+func (g *NativeGitGateway) ExcludeFiles(ctx context.Context, repoPath, branchName string, excluded func(file string) bool) (_ *entity.CommitResult, err error) {
+	defer wrapError(ctx, "exclude files", &err)
+	repo, err := g.open(ctx, repoPath)
+	if err != nil {
+		return nil, err
+	}
+	name := plumbing.NewBranchReferenceName(branchName)
+	head, err := repo.Storer.Reference(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read branch '%s': %w", branchName, err)
+	}
+
+	var commits []*object.Commit
+	for hash := head.Hash(); ; {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		commit, err := repo.CommitObject(hash)
+		if err != nil {
+			return nil, err
+		}
+		commits = append(commits, commit)
+		if commit.NumParents() == 0 {
+			break
+		}
+		hash = commit.ParentHashes[0]
+	}
+
+	filter := &treeFilter{repo: repo, excluded: excluded, cache: map[string]plumbing.Hash{}, removed: map[string]bool{}}
+	var parents []plumbing.Hash
+	rewritten := false
+	for i := len(commits) - 1; i >= 0; i-- {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		commit := commits[i]
+		tree, changed, err := filter.filter(commit.TreeHash, "")
+		if err != nil {
+			return nil, err
+		}
+		if !changed && !rewritten {
+			parents = []plumbing.Hash{commit.Hash}
+			continue
+		}
+		hash, err := writeCommit(repo, replayed(commit, tree, parents))
+		if err != nil {
+			return nil, err
+		}
+		parents = []plumbing.Hash{hash}
+		rewritten = true
+	}
+	if !rewritten {
+		return describeCommit(repo, head.Hash())
+	}
+
+	if err := repo.Storer.SetReference(plumbing.NewHashReference(name, parents[0])); err != nil {
+		return nil, err
+	}
+	g.logger.Infof("new commit SHA without %d excluded files: %s", len(filter.removed), parents[0])
+	return describeCommit(repo, parents[0])
+}
+
+// CleanWorkdir removes every file of the working tree that is not in the index,
+// ignored files included, like git clean -fdx.
+func (g *NativeGitGateway) CleanWorkdir(ctx context.Context, repoPath string) (err error) {
+	defer wrapError(ctx, "clean", &err)
+	repo, err := g.open(ctx, repoPath)
+	if err != nil {
+		return err
+	}
+	idx, err := repo.Storer.Index()
+	if err != nil {
+		return err
+	}
+
+	tracked := make(map[string]bool)
+	for _, e := range idx.Entries {
+		tracked[e.Name] = true
+		for dir := path.Dir(e.Name); dir != "."; dir = path.Dir(dir) {
+			tracked[dir+"/"] = true
+		}
+	}
+	return cleanDir(repoPath, "", tracked)
+}
+
+func cleanDir(root, dir string, tracked map[string]bool) error {
+	entries, err := os.ReadDir(filepath.Join(root, filepath.FromSlash(dir)))
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		name := path.Join(dir, e.Name())
+		if name == ".git" || tracked[name] {
+			continue
+		}
+		if e.IsDir() && tracked[name+"/"] {
+			if err := cleanDir(root, name, tracked); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(root, filepath.FromSlash(name))); err != nil {
+			return fmt.Errorf("failed to clean workdir: %w", err)
+		}
+	}
+	return nil
+}
+
+// Commit stages all files of the working tree, except ignored ones, and commits them.
+func (g *NativeGitGateway) Commit(ctx context.Context, repoPath, message string) (_ *entity.CommitResult, err error) {
+	defer wrapError(ctx, "commit", &err)
+	repo, err := g.open(ctx, repoPath)
+	if err != nil {
+		return nil, err
+	}
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return nil, err
+	}
+	if err := worktree.AddWithOptions(&gogit.AddOptions{All: true}); err != nil {
+		return nil, fmt.Errorf("failed to stage files for commit: %w", err)
+	}
+	signature := g.signature(repo)
+	commit, err := worktree.Commit(message, &gogit.CommitOptions{Author: &signature, Committer: &signature})
+	if err != nil {
+		return nil, fmt.Errorf("failed to make commit: %w", err)
+	}
+	return describeCommit(repo, commit)
+}
+
+// PushBranch force-pushes localBranch to remoteBranch of remoteURL. Nothing is
+// written to the repository config. HTTPS remotes are authenticated with
+// HTTPToken, SSH remotes use the SSH agent.
+func (g *NativeGitGateway) PushBranch(ctx context.Context, repoPath, remoteURL, localBranch, remoteBranch string) (err error) {
+	defer wrapError(ctx, "push", &err)
+	repo, err := g.open(ctx, repoPath)
+	if err != nil {
+		return err
+	}
+	remote := gogit.NewRemote(repo.Storer, &config.RemoteConfig{Name: pushRemoteName, URLs: []string{remoteURL}})
+
+	refspec := config.RefSpec("+refs/heads/" + localBranch + ":refs/heads/" + remoteBranch)
+	options := &gogit.PushOptions{RemoteName: pushRemoteName, RefSpecs: []config.RefSpec{refspec}, Force: true}
+	if g.HTTPToken != "" && (strings.HasPrefix(remoteURL, "https://") || strings.HasPrefix(remoteURL, "http://")) {
+		// GitLab accepts a personal access token as the password of any user name.
+		options.Auth = &githttp.BasicAuth{Username: "oauth2", Password: g.HTTPToken}
+	}
+
+	g.logger.Infof("Pushing branch '%s' to branch '%s' of %s", localBranch, remoteBranch, remoteURL)
+	err = remote.PushContext(ctx, options)
+	if err != nil && !errors.Is(err, gogit.NoErrAlreadyUpToDate) {
+		return fmt.Errorf("failed to push branch '%s': %w", localBranch, err)
+	}
+	return nil
+}
+
+// signature returns the configured user, or defaultSignature, with the current time.
+// As with git, each of user.name and user.email is taken from the repository
+// config, else from the global config, else from the system config.
+func (g *NativeGitGateway) signature(repo *gogit.Repository) object.Signature {
+	var name, email string
+	if cfg, err := repo.Config(); err == nil {
+		name, email = cfg.User.Name, cfg.User.Email
+	}
+	for _, path := range userConfigPaths() {
+		if name != "" && email != "" {
+			break
+		}
+		cfg, err := readConfigFile(path)
+		if err != nil {
+			// A file that is missing or cannot be parsed does not hide the others.
+			continue
+		}
+		if name == "" {
+			name = cfg.User.Name
+		}
+		if email == "" {
+			email = cfg.User.Email
+		}
+	}
+
+	signature := defaultSignature
+	if name != "" && email != "" {
+		signature.Name, signature.Email = name, email
+	}
+	signature.When = time.Now()
+	return signature
+}
+
+// userConfigPaths returns the global and system config files that git reads,
+// the one that takes precedence first. Unlike go-git, it follows
+// GIT_CONFIG_GLOBAL, GIT_CONFIG_SYSTEM and GIT_CONFIG_NOSYSTEM.
+func userConfigPaths() []string {
+	var paths []string
+	if global, ok := os.LookupEnv("GIT_CONFIG_GLOBAL"); ok {
+		paths = append(paths, global)
+	} else {
+		home, _ := os.UserHomeDir()
+		configHome := os.Getenv("XDG_CONFIG_HOME")
+		if configHome == "" && home != "" {
+			configHome = filepath.Join(home, ".config")
+		}
+		if home != "" {
+			paths = append(paths, filepath.Join(home, ".gitconfig"))
+		}
+		if configHome != "" {
+			paths = append(paths, filepath.Join(configHome, "git", "config"))
+		}
+	}
+
+	if noSystem, _ := strconv.ParseBool(os.Getenv("GIT_CONFIG_NOSYSTEM")); !noSystem {
+		system := os.Getenv("GIT_CONFIG_SYSTEM")
+		if system == "" {
+			system = "/etc/gitconfig"
+		}
+		paths = append(paths, system)
+	}
+	return paths
+}
+
+// readConfigFile parses a git config file.
+func readConfigFile(path string) (*config.Config, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return config.ReadConfig(file)
+}
+
+// resolveCommit returns the commit of a revision, or of HEAD if it is empty.
+func resolveCommit(repo *gogit.Repository, revision string) (*object.Commit, error) {
+	if revision == "" {
+		revision = "HEAD"
+	}
+	hash, err := repo.ResolveRevision(plumbing.Revision(revision))
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve '%s': %w", revision, err)
+	}
+	return repo.CommitObject(*hash)
+}
+
+// describeCommit sums up the files of the tree of a commit.
+func describeCommit(repo *gogit.Repository, hash plumbing.Hash) (*entity.CommitResult, error) {
+	commit, err := repo.CommitObject(hash)
+	if err != nil {
+		return nil, err
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, err
+	}
+	result := &entity.CommitResult{CommitSHA: hash.String(), TreeSHA: commit.TreeHash.String()}
+	err = tree.Files().ForEach(func(file *object.File) error {
+		result.Files++
+		result.Bytes += file.Size
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// replayed returns a copy of commit with another tree and other parents.
+func replayed(commit *object.Commit, tree plumbing.Hash, parents []plumbing.Hash) *object.Commit {
+	return &object.Commit{
+		Author:       commit.Author,
+		Committer:    commit.Committer,
+		Message:      commit.Message,
+		Encoding:     commit.Encoding,
+		TreeHash:     tree,
+		ParentHashes: parents,
+	}
+}
+
+// writeCommit stores a commit object and returns its hash.
+func writeCommit(repo *gogit.Repository, commit *object.Commit) (plumbing.Hash, error) {
+	obj := repo.Storer.NewEncodedObject()
+	if err := commit.Encode(obj); err != nil {
+		return plumbing.ZeroHash, err
+	}
+	return repo.Storer.SetEncodedObject(obj)
+}
+
+// createBranch creates a branch at commit, which must not exist yet.
+func createBranch(repo *gogit.Repository, branchName string, commit plumbing.Hash) error {
+	name := plumbing.NewBranchReferenceName(branchName)
+	if _, err := repo.Storer.Reference(name); err == nil {
+		return fmt.Errorf("a branch named '%s' already exists", branchName)
+	}
+	return repo.Storer.SetReference(plumbing.NewHashReference(name, commit))
+}
+
+// treeFilter writes copies of trees without the excluded files.
+type treeFilter struct {
+	repo     *gogit.Repository
+	excluded func(file string) bool
+	cache    map[string]plumbing.Hash // filtered trees by directory and original hash
+	removed  map[string]bool          // paths of the removed files
+}
+
+// filter returns the hash of the tree at dir without the excluded files and
+// whether anything was removed. A directory left empty gets the zero hash.
+func (f *treeFilter) filter(hash plumbing.Hash, dir string) (plumbing.Hash, bool, error) {
+	key := dir + "\x00" + hash.String()
+	if filtered, ok := f.cache[key]; ok {
+		return filtered, filtered != hash, nil
+	}
+
+	tree, err := object.GetTree(f.repo.Storer, hash)
+	if err != nil {
+		return plumbing.ZeroHash, false, err
+	}
+	changed := false
+	entries := make([]object.TreeEntry, 0, len(tree.Entries))
+	for _, e := range tree.Entries {
+		name := path.Join(dir, e.Name)
+		if e.Mode == filemode.Dir {
+			subtree, subChanged, err := f.filter(e.Hash, name)
+			if err != nil {
+				return plumbing.ZeroHash, false, err
+			}
+			if subChanged {
+				changed = true
+				if subtree.IsZero() {
+					continue
+				}
+				e.Hash = subtree
+			}
+		} else if f.excluded(name) {
+			f.removed[name] = true
+			changed = true
+			continue
+		}
+		entries = append(entries, e)
+	}
+
+	filtered := hash
+	if changed {
+		if len(entries) == 0 && dir != "" {
+			filtered = plumbing.ZeroHash
+		} else {
+			obj := f.repo.Storer.NewEncodedObject()
+			if err := (&object.Tree{Entries: entries}).Encode(obj); err != nil {
+				return plumbing.ZeroHash, false, err
+			}
+			if filtered, err = f.repo.Storer.SetEncodedObject(obj); err != nil {
+				return plumbing.ZeroHash, false, err
+			}
+		}
+	}
+	f.cache[key] = filtered
+	return filtered, changed, nil
+}
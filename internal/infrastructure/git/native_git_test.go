@@ -0,0 +1,193 @@
+package git
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/olegshirko/reposqueeze/internal/domain/entity"
+	"github.com/olegshirko/reposqueeze/internal/domain/gateway"
+	"github.com/olegshirko/reposqueeze/internal/testutil"
+)
+
+// Both backends build the same trees from the same source branch, with the
+// identity git itself would use.
+func TestBackendsBuildSameTrees(t *testing.T) {
+	r := testutil.NewRepo(t)
+	r.Commit("first", map[string]string{"a.txt": "a1", "dir/b.txt": "b", "key.secret": "s1"})
+	r.Commit("second", map[string]string{"a.txt": "a2", "dir/c/d.txt": "d"})
+	r.Git("checkout", "--quiet", "-b", "feature")
+	r.Commit("third", map[string]string{"dir/b.txt": "b2", "key.secret": "s2"})
+	r.Git("checkout", "--quiet", "master")
+
+	// The name comes from the global config and the email from the repository
+	// config, so the backends must merge both like git does.
+	global := filepath.Join(t.TempDir(), "gitconfig")
+	if err := os.WriteFile(global, []byte("[user]\n\tname = Global User\n\temail = global@example.com\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GIT_CONFIG_GLOBAL", global)
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+	t.Setenv("HOME", t.TempDir())
+	r.Git("config", "--unset", "user.name")
+	const wantIdentity = "Global User <test@example.com>"
+
+	type build struct {
+		name string
+		// newCommit is set if the root commit is made by the user instead of
+		// being reproduced from the history.
+		newCommit bool
+		run       func(t *testing.T, backend string) string // Returns the branch it built
+	}
+	builds := []build{
+		{"orphan", true, func(t *testing.T, backend string) string {
+			branch := &entity.Branch{Name: "orphan-" + backend}
+			if _, err := backends()[backend].CreateOrphanBranch(context.Background(), &entity.Repository{Path: r.Path}, branch, "feature"); err != nil {
+				t.Fatalf("CreateOrphanBranch: %v", err)
+			}
+			return branch.Name
+		}},
+		{"squashed", false, func(t *testing.T, backend string) string {
+			branch := &entity.Branch{Name: "squashed-" + backend}
+			if _, err := backends()[backend].CreateSquashedBranch(context.Background(), &entity.Repository{Path: r.Path}, branch, "feature", entity.KeepHistory{Last: 1}); err != nil {
+				t.Fatalf("CreateSquashedBranch: %v", err)
+			}
+			return branch.Name
+		}},
+		{"excluded", false, func(t *testing.T, backend string) string {
+			git := backends()[backend]
+			branch := &entity.Branch{Name: "excluded-" + backend}
+			if _, err := git.CreateSquashedBranch(context.Background(), &entity.Repository{Path: r.Path}, branch, "feature", entity.KeepHistory{Last: 2}); err != nil {
+				t.Fatalf("CreateSquashedBranch: %v", err)
+			}
+			if _, err := git.ExcludeFiles(context.Background(), r.Path, branch.Name, excludeSecrets); err != nil {
+				t.Fatalf("ExcludeFiles: %v", err)
+			}
+			return branch.Name
+		}},
+	}
+	for _, b := range builds {
+		t.Run(b.name, func(t *testing.T) {
+			execBranch := b.run(t, "exec")
+			nativeBranch := b.run(t, "native")
+
+			execTrees := r.Git("log", "--format=%T", execBranch)
+			nativeTrees := r.Git("log", "--format=%T", nativeBranch)
+			if execTrees != nativeTrees {
+				t.Errorf("trees differ:\nexec:\n%s\nnative:\n%s", execTrees, nativeTrees)
+			}
+			if !b.newCommit {
+				return
+			}
+			for backend, branch := range map[string]string{"exec": execBranch, "native": nativeBranch} {
+				root := r.Git("rev-list", "--max-parents=0", branch)
+				if identity := r.Git("log", "-1", "--format=%cn <%ce>", root); identity != wantIdentity {
+					t.Errorf("%s backend commits as %s, want %s", backend, identity, wantIdentity)
+				}
+			}
+		})
+	}
+}
+
+// The native backend fails with entity.GitError, and with the error of the
+// context as well once it is cancelled, like the git commands of the exec backend.
+func TestNativeErrors(t *testing.T) {
+	r := testutil.NewRepo(t)
+	r.Commit("first", map[string]string{"a.txt": "a"})
+	git := NewNativeGitGateway(testutil.Logger())
+	repo := &entity.Repository{Path: r.Path}
+
+	_, err := git.CreateOrphanBranch(context.Background(), repo, &entity.Branch{Name: "orphan"}, "missing")
+	var gitErr *entity.GitError
+	if !errors.As(err, &gitErr) || gitErr.Operation != "create orphan branch" {
+		t.Errorf("CreateOrphanBranch from a missing branch: error %v is not an entity.GitError of create orphan branch", err)
+	}
+	if errors.Is(err, context.Canceled) {
+		t.Errorf("error %v of a run that was not cancelled is context.Canceled", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = git.CreateOrphanBranch(ctx, repo, &entity.Branch{Name: "cancelled"}, "master")
+	if !errors.Is(err, context.Canceled) || !errors.As(err, &gitErr) {
+		t.Errorf("cancelled CreateOrphanBranch: error %v is not context.Canceled and an entity.GitError", err)
+	}
+	if branches := r.Git("branch", "--list", "cancelled"); branches != "" {
+		t.Errorf("cancelled CreateOrphanBranch created branch %s", branches)
+	}
+}
+
+// Both backends force-push a branch under another name without leaving a
+// remote in the repository config.
+func TestPushBranch(t *testing.T) {
+	r := testutil.NewRepo(t)
+	first := r.Commit("first", map[string]string{"a.txt": "a"})
+	remote := filepath.Join(t.TempDir(), "remote.git")
+	r.Git("init", "--quiet", "--bare", remote)
+
+	for backend, git := range backends() {
+		t.Run(backend, func(t *testing.T) {
+			remoteBranch := "main-" + backend
+			if err := git.PushBranch(context.Background(), r.Path, remote, "master", remoteBranch); err != nil {
+				t.Fatalf("PushBranch: %v", err)
+			}
+			// Pushing the same commit again is not an error.
+			if err := git.PushBranch(context.Background(), r.Path, remote, "master", remoteBranch); err != nil {
+				t.Fatalf("PushBranch of an unchanged branch: %v", err)
+			}
+
+			// A squashed branch replaces the history of the remote branch.
+			branch := &entity.Branch{Name: "squashed-" + backend}
+			result, err := git.CreateOrphanBranch(context.Background(), &entity.Repository{Path: r.Path}, branch, "master")
+			if err != nil {
+				t.Fatalf("CreateOrphanBranch: %v", err)
+			}
+			if err := git.PushBranch(context.Background(), r.Path, remote, branch.Name, remoteBranch); err != nil {
+				t.Fatalf("force PushBranch: %v", err)
+			}
+			if head := r.Git("--git-dir", remote, "rev-parse", remoteBranch); head != result.CommitSHA || head == first {
+				t.Errorf("remote branch at %s, want the squashed commit %s", head, result.CommitSHA)
+			}
+			if remotes := r.Git("remote"); remotes != "" {
+				t.Errorf("PushBranch left the remotes %s", remotes)
+			}
+		})
+	}
+}
+
+// A push to an HTTP remote authenticates with the token like GitLab expects.
+func TestPushBranchSendsToken(t *testing.T) {
+	r := testutil.NewRepo(t)
+	r.Commit("first", map[string]string{"a.txt": "a"})
+	var authorization atomic.Value
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if header := req.Header.Get("Authorization"); header != "" {
+			authorization.Store(header)
+		}
+		http.Error(w, "forbidden", http.StatusForbidden)
+	}))
+	t.Cleanup(server.Close)
+
+	exec := NewOSExecGitGateway(testutil.Logger())
+	exec.HTTPToken = "secret"
+	native := NewNativeGitGateway(testutil.Logger())
+	native.HTTPToken = "secret"
+	want := "Basic " + base64.StdEncoding.EncodeToString([]byte("oauth2:secret"))
+	for backend, git := range map[string]gateway.GitGateway{"exec": exec, "native": native} {
+		t.Run(backend, func(t *testing.T) {
+			authorization.Store("")
+			if err := git.PushBranch(context.Background(), r.Path, server.URL+"/group/app.git", "master", "main"); err == nil {
+				t.Fatal("PushBranch to a server that refuses it succeeded")
+			}
+			if got := authorization.Load(); got != want {
+				t.Errorf("Authorization %q, want %q", got, want)
+			}
+		})
+	}
+}
@@ -2,9 +2,12 @@ package git
 
 import (
 	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
 	"os"
 	"os/exec"
-	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/olegshirko/reposqueeze/internal/domain/entity"
@@ -13,7 +16,10 @@ import (
 
 // OSExecGitGateway is an implementation of the GitGateway that uses os/exec.
 type OSExecGitGateway struct {
-	logger logger.Logger
+	// HTTPToken authenticates pushes to HTTPS remotes. It is passed to git
+	// through the environment, so it never shows up in the command line or .git/config.
+	HTTPToken string
+	logger    logger.Logger
 }
 
 // NewOSExecGitGateway creates a new instance of OSExecGitGateway.
@@ -21,49 +27,30 @@ func NewOSExecGitGateway(log logger.Logger) *OSExecGitGateway {
 	return &OSExecGitGateway{logger: log}
 }
 
-// CreateOrphanBranch creates a new orphan branch in the given repository.
-func (g *OSExecGitGateway) CreateOrphanBranch(ctx context.Context, repository *entity.Repository, branch *entity.Branch, sourceBranch string) error {
-	// Command 1: Create the orphan branch
-	args := []string{"checkout", "--orphan", branch.Name}
-	if sourceBranch != "" {
-		args = append(args, sourceBranch)
-	}
-	cmdCheckout := exec.Command("git", args...)
-	cmdCheckout.Dir = repository.Path
-	if output, err := cmdCheckout.CombinedOutput(); err != nil {
-		g.logger.Errorf("failed to create orphan branch: %w, output: %s", err, string(output))
-		return err
-	}
-
-	// Command 2: Stage all current files for the initial commit
-	cmdAdd := exec.Command("git", "add", ".")
-	cmdAdd.Dir = repository.Path
-	if output, err := cmdAdd.CombinedOutput(); err != nil {
-		g.logger.Errorf("failed to stage files for commit: %w, output: %s", err, string(output))
-		return err
+// CreateOrphanBranch creates a branch with a single commit that holds the tree
+// of sourceBranch, or of HEAD if sourceBranch is empty. The commit and the
+// branch are written with plumbing commands, so HEAD, the index and the working
+// tree stay as they are and only committed files are used.
+func (g *OSExecGitGateway) CreateOrphanBranch(ctx context.Context, repository *entity.Repository, branch *entity.Branch, sourceBranch string) (*entity.CommitResult, error) {
+	source := sourceBranch
+	if source == "" {
+		source = "HEAD"
 	}
 
-	// Command 3: Make an initial commit with the current files
-	cmdCommit := exec.Command("git", "commit", "-m", "Initial commit on orphan branch")
-	cmdCommit.Dir = repository.Path
-	if output, err := cmdCommit.CombinedOutput(); err != nil {
-		g.logger.Errorf("failed to make initial commit: %w, output: %s", err, string(output))
-		return err
-	}
-
-	// Command 4: Get the SHA of the new commit
-	cmdRevParse := exec.Command("git", "rev-parse", "HEAD")
-	cmdRevParse.Dir = repository.Path
-	output, err := cmdRevParse.CombinedOutput()
+	// Command 1: Make an initial commit with the files of the source
+	output, err := g.run(ctx, repository.Path, nil, "", "commit-tree", "-m", "Initial commit on orphan branch", source+"^{tree}")
 	if err != nil {
-		g.logger.Errorf("failed to get new commit SHA: %w, output: %s", err, string(output))
-		return err
+		return nil, err
 	}
+	commit := strings.TrimSpace(output)
 
-	// We are not returning the SHA, but we are logging it for debugging purposes.
-	g.logger.Infof("new commit SHA: %s", strings.TrimSpace(string(output)))
+	// Command 2: Point the new branch at the commit
+	if err := g.createBranch(ctx, repository.Path, branch.Name, commit); err != nil {
+		return nil, err
+	}
 
-	return nil
+	g.logger.Infof("new commit SHA: %s", commit)
+	return g.describeCommit(ctx, repository.Path, commit)
 }
 
 // CreateEmptyOrphanBranch creates a new orphan branch in the given repository.
@@ -73,15 +60,16 @@ func (g *OSExecGitGateway) CreateEmptyOrphanBranch(ctx context.Context, reposito
 	if sourceBranch != "" {
 		args = append(args, sourceBranch)
 	}
-	cmdCheckout := exec.Command("git", args...)
+	cmdCheckout := exec.CommandContext(ctx, "git", args...)
 	cmdCheckout.Dir = repository.Path
 	if output, err := cmdCheckout.CombinedOutput(); err != nil {
-		g.logger.Errorf("failed to create orphan branch: %w, output: %s", err, string(output))
+		err = commandError(ctx, cmdCheckout, output, err)
+		g.logger.Errorf("failed to create orphan branch: %v", err)
 		return err
 	}
 
 	// Command 2: Remove all files from the index to make the branch truly empty
-	cmdRm := exec.Command("git", "rm", "-rf", "--cached", ".")
+	cmdRm := exec.CommandContext(ctx, "git", "rm", "-rf", "--cached", ".")
 	cmdRm.Dir = repository.Path
 	if output, err := cmdRm.CombinedOutput(); err != nil {
 		// This command can fail if there are no files, which is fine for an orphan branch
@@ -91,22 +79,43 @@ func (g *OSExecGitGateway) CreateEmptyOrphanBranch(ctx context.Context, reposito
 	return nil
 }
 
-// ListFiles lists all tracked files in the repository.
-func (g *OSExecGitGateway) ListFiles(repoPath string) ([]string, error) {
-	cmd := exec.Command("git", "ls-files")
-	cmd.Dir = repoPath
-	output, err := cmd.CombinedOutput()
+// CurrentBranch returns the name of the checked out branch, or the commit SHA if HEAD is detached.
+func (g *OSExecGitGateway) CurrentBranch(ctx context.Context, repoPath string) (string, error) {
+	// symbolic-ref exits quietly with status 1 when HEAD is detached.
+	cmd := exec.CommandContext(ctx, "git", "-C", repoPath, "symbolic-ref", "--quiet", "--short", "HEAD")
+	output, err := cmd.Output()
+	if err == nil {
+		return strings.TrimSpace(string(output)), nil
+	}
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != 1 {
+		err = commandError(ctx, cmd, nil, err)
+		g.logger.Errorf("failed to read HEAD: %v", err)
+		return "", err
+	}
+	commit, err := g.run(ctx, repoPath, nil, "", "rev-parse", "--verify", "HEAD")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(commit), nil
+}
+
+// Checkout checks out a branch or commit, discarding the changes of the index
+// and the working tree. Untracked files are left alone.
+func (g *OSExecGitGateway) Checkout(ctx context.Context, repoPath, ref string) error {
+	_, err := g.run(ctx, repoPath, nil, "", "checkout", "--force", "--quiet", ref, "--")
+	return err
+}
+
+// ListFiles lists the files of the last commit of a branch.
+func (g *OSExecGitGateway) ListFiles(ctx context.Context, repoPath, branchName string) ([]string, error) {
+	output, err := g.run(ctx, repoPath, nil, "", "ls-tree", "-r", "-z", "--full-tree", "--name-only", branchName)
 	if err != nil {
-		g.logger.Errorf("failed to list files: %w, output: %s", err, string(output))
 		return nil, err
 	}
-	// The output is a newline-separated list of files.
-	// We need to split it into a slice of strings.
-	// Note: This will produce an empty string at the end if the output ends with a newline.
-	files := strings.Split(string(output), "\n")
-	// Filter out empty strings from the result.
+	// The output is a NUL-terminated list of files, so file names may contain line breaks.
 	var result []string
-	for _, file := range files {
+	for _, file := range strings.Split(output, "\x00") {
 		if file != "" {
 			result = append(result, file)
 		}
@@ -114,56 +123,144 @@ func (g *OSExecGitGateway) ListFiles(repoPath string) ([]string, error) {
 	return result, nil
 }
 
+// ReadFiles reads files of the last commit of a branch. All files are read by
+// a single git cat-file process.
+func (g *OSExecGitGateway) ReadFiles(ctx context.Context, repoPath, branchName string, files []string) ([]entity.File, error) {
+	// Command 1: Find the mode and the object of every file
+	output, err := g.run(ctx, repoPath, nil, "", "ls-tree", "-r", "-z", "--full-tree", branchName)
+	if err != nil {
+		return nil, err
+	}
+	type treeEntry struct{ mode, object string }
+	entries := make(map[string]treeEntry)
+	for _, line := range strings.Split(output, "\x00") {
+		// Each line is "<mode> <type> <object>\t<file>".
+		info, file, ok := strings.Cut(line, "\t")
+		fields := strings.Fields(info)
+		if !ok || len(fields) != 3 {
+			continue
+		}
+		entries[file] = treeEntry{mode: fields[0], object: fields[2]}
+	}
+
+	var request strings.Builder
+	for _, file := range files {
+		entry, ok := entries[file]
+		if !ok {
+			return nil, fmt.Errorf("file %s not found in branch '%s'", file, branchName)
+		}
+		request.WriteString(entry.object + "\n")
+	}
+
+	// Command 2: Read the objects, each printed as "<object> <type> <size>\n<content>\n"
+	output, err = g.run(ctx, repoPath, nil, request.String(), "cat-file", "--batch")
+	if err != nil {
+		return nil, err
+	}
+	result := make([]entity.File, 0, len(files))
+	for _, file := range files {
+		header, rest, ok := strings.Cut(output, "\n")
+		fields := strings.Fields(header)
+		if !ok || len(fields) != 3 {
+			return nil, fmt.Errorf("unexpected output of git cat-file for %s: %q", file, header)
+		}
+		size, err := strconv.Atoi(fields[2])
+		if err != nil || size+1 > len(rest) {
+			return nil, fmt.Errorf("unexpected output of git cat-file for %s: %q", file, header)
+		}
+		result = append(result, entity.File{
+			Path:       file,
+			Content:    []byte(rest[:size]),
+			Executable: entries[file].mode == "100755",
+		})
+		output = rest[size+1:]
+	}
+	return result, nil
+}
+
 // DeleteLocalBranch deletes a local branch.
-func (g *OSExecGitGateway) DeleteLocalBranch(repoPath, branchName string) error {
-	cmd := exec.Command("git", "-C", repoPath, "branch", "-D", branchName)
+func (g *OSExecGitGateway) DeleteLocalBranch(ctx context.Context, repoPath, branchName string) error {
+	cmd := exec.CommandContext(ctx, "git", "-C", repoPath, "branch", "-D", branchName)
 	if output, err := cmd.CombinedOutput(); err != nil {
-		g.logger.Errorf("failed to delete local branch '%s': %w, output: %s", branchName, err, string(output))
+		err = commandError(ctx, cmd, output, err)
+		g.logger.Errorf("failed to delete local branch '%s': %v", branchName, err)
 		return err
 	}
 	return nil
 }
 
-func (g *OSExecGitGateway) CleanWorkdir(repoPath string) error {
-	cmd := exec.Command("git", "clean", "-fdx")
+func (g *OSExecGitGateway) CleanWorkdir(ctx context.Context, repoPath string) error {
+	cmd := exec.CommandContext(ctx, "git", "clean", "-fdx")
 	cmd.Dir = repoPath
 	if output, err := cmd.CombinedOutput(); err != nil {
-		g.logger.Errorf("failed to clean workdir: %w, output: %s", err, string(output))
+		err = commandError(ctx, cmd, output, err)
+		g.logger.Errorf("failed to clean workdir: %v", err)
 		return err
 	}
 	return nil
 }
 
-// RemoveDirectory removes a directory from the repository.
-func (g *OSExecGitGateway) RemoveDirectory(repoPath, dirName string) error {
-	dirPath := filepath.Join(repoPath, dirName)
-	if _, err := os.Stat(dirPath); !os.IsNotExist(err) {
-		return os.RemoveAll(dirPath)
+func (g *OSExecGitGateway) Commit(ctx context.Context, repoPath, message string) (*entity.CommitResult, error) {
+	cmdAdd := exec.CommandContext(ctx, "git", "add", ".")
+	cmdAdd.Dir = repoPath
+	if output, err := cmdAdd.CombinedOutput(); err != nil {
+		err = commandError(ctx, cmdAdd, output, err)
+		g.logger.Errorf("failed to stage files for commit: %v", err)
+		return nil, err
 	}
-	return nil
-}
 
-// CheckoutBranch switches to a different local branch.
-func (g *OSExecGitGateway) CheckoutBranch(repoPath, branchName string) error {
-	cmd := exec.Command("git", "-C", repoPath, "checkout", branchName)
-	if output, err := cmd.CombinedOutput(); err != nil {
-		g.logger.Errorf("failed to checkout branch '%s': %w, output: %s", branchName, err, string(output))
-		return err
+	cmdCommit := exec.CommandContext(ctx, "git", "commit", "-m", message)
+	cmdCommit.Dir = repoPath
+	if output, err := cmdCommit.CombinedOutput(); err != nil {
+		err = commandError(ctx, cmdCommit, output, err)
+		g.logger.Errorf("failed to make commit: %v", err)
+		return nil, err
 	}
-	return nil
+
+	return g.describeCommit(ctx, repoPath, "HEAD")
 }
-func (g *OSExecGitGateway) Commit(repoPath, message string) error {
-	cmdAdd := exec.Command("git", "add", ".")
-	cmdAdd.Dir = repoPath
+
+// pushRemoteName is the temporary remote used by PushBranch.
+const pushRemoteName = "reposqueeze-push"
+
+// PushBranch force-pushes localBranch to remoteBranch of remoteURL.
+// The remote is added for the duration of the push only. HTTPS remotes are
+// authenticated with HTTPToken, SSH remotes use the user's SSH setup.
+func (g *OSExecGitGateway) PushBranch(ctx context.Context, repoPath, remoteURL, localBranch, remoteBranch string) error {
+	// A leftover remote from an interrupted run would make "remote add" fail.
+	cmdRemove := exec.CommandContext(ctx, "git", "-C", repoPath, "remote", "remove", pushRemoteName)
+	cmdRemove.CombinedOutput()
+
+	cmdAdd := exec.CommandContext(ctx, "git", "-C", repoPath, "remote", "add", pushRemoteName, remoteURL)
 	if output, err := cmdAdd.CombinedOutput(); err != nil {
-		g.logger.Errorf("failed to stage files for commit: %w, output: %s", err, string(output))
+		err = commandError(ctx, cmdAdd, output, err)
+		g.logger.Errorf("failed to add remote '%s': %v", remoteURL, err)
 		return err
 	}
+	defer func() {
+		// The remote is removed even if the push was cancelled.
+		cmd := exec.CommandContext(context.WithoutCancel(ctx), "git", "-C", repoPath, "remote", "remove", pushRemoteName)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			g.logger.Warnf("Warning: failed to remove remote '%s': %v, output: %s", pushRemoteName, err, string(output))
+		}
+	}()
 
-	cmdCommit := exec.Command("git", "commit", "-m", message)
-	cmdCommit.Dir = repoPath
-	if output, err := cmdCommit.CombinedOutput(); err != nil {
-		g.logger.Errorf("failed to make commit: %w, output: %s", err, string(output))
+	refspec := "refs/heads/" + localBranch + ":refs/heads/" + remoteBranch
+	cmdPush := exec.CommandContext(ctx, "git", "-C", repoPath, "push", "--force", pushRemoteName, refspec)
+	cmdPush.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	if g.HTTPToken != "" && (strings.HasPrefix(remoteURL, "https://") || strings.HasPrefix(remoteURL, "http://")) {
+		// GitLab accepts a personal access token as the password of any user name.
+		credentials := base64.StdEncoding.EncodeToString([]byte("oauth2:" + g.HTTPToken))
+		cmdPush.Env = append(cmdPush.Env,
+			"GIT_CONFIG_COUNT=1",
+			"GIT_CONFIG_KEY_0=http.extraHeader",
+			"GIT_CONFIG_VALUE_0=Authorization: Basic "+credentials,
+		)
+	}
+	g.logger.Infof("Pushing branch '%s' to branch '%s' of %s", localBranch, remoteBranch, remoteURL)
+	if output, err := cmdPush.CombinedOutput(); err != nil {
+		err = commandError(ctx, cmdPush, output, err)
+		g.logger.Errorf("failed to push branch '%s': %v", localBranch, err)
 		return err
 	}
 
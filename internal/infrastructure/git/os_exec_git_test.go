@@ -0,0 +1,45 @@
+package git
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/olegshirko/reposqueeze/internal/domain/entity"
+	"github.com/olegshirko/reposqueeze/internal/testutil"
+)
+
+func TestCommitCancelled(t *testing.T) {
+	r := testutil.NewRepo(t)
+	r.Commit("first", map[string]string{"a.txt": "a"})
+	r.Write(map[string]string{"b.txt": "b"})
+	// The hook keeps git commit running until the context is cancelled. It
+	// does not hold the output of git, so killing git ends the command.
+	hook := filepath.Join(r.Path, ".git", "hooks", "pre-commit")
+	if err := os.WriteFile(hook, []byte("#!/bin/sh\nexec sleep 30 </dev/null >/dev/null 2>&1\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(500*time.Millisecond, cancel)
+	start := time.Now()
+	_, err := NewOSExecGitGateway(testutil.Logger()).Commit(ctx, r.Path, "second")
+	if err == nil {
+		t.Fatal("Commit succeeded, want an error")
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("Commit returned after %v, want it to stop on cancellation", elapsed)
+	}
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("error %v is not context.Canceled", err)
+	}
+	var gitErr *entity.GitCommandError
+	if !errors.As(err, &gitErr) {
+		t.Errorf("error %v is not an entity.GitCommandError", err)
+	} else if gitErr.Args[0] != "commit" {
+		t.Errorf("failed command is git %v, want git commit", gitErr.Args)
+	}
+}
@@ -0,0 +1,218 @@
+package gitlab
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/olegshirko/reposqueeze/internal/domain/entity"
+	"github.com/olegshirko/reposqueeze/internal/domain/gateway"
+	"github.com/olegshirko/reposqueeze/internal/pkg/logger"
+)
+
+const (
+	// downloadAttempts is how many times an interrupted archive download is retried.
+	downloadAttempts = 3
+	// progressInterval is how often the download progress is logged.
+	progressInterval = 5 * time.Second
+)
+
+// interruptedError marks a download that failed while reading the response
+// body and can be continued from the bytes already written.
+type interruptedError struct {
+	err error
+}
+
+func (e *interruptedError) Error() string { return e.err.Error() }
+func (e *interruptedError) Unwrap() error { return e.err }
+
+// DownloadRepoArchive streams an archive of a project into writer.
+//
+// The archive is never held in memory. If the connection drops, the download is
+// resumed with a Range request; if the server answers with the full archive
+// instead, the writer is truncated and the download starts over, which is only
+// possible when writer supports Truncate and Seek (e.g. *os.File).
+func (g *HTTPGitLabGateway) DownloadRepoArchive(ctx context.Context, projectID int, options gateway.ArchiveOptions, writer io.Writer) error {
+	format := options.Format
+	if format == "" {
+		format = entity.ArchiveZip
+	}
+	query := url.Values{}
+	if options.Ref != "" {
+		query.Set("sha", options.Ref)
+	}
+	if options.Path != "" {
+		query.Set("path", options.Path)
+	}
+	path := fmt.Sprintf("/projects/%d/repository/archive.%s", projectID, format)
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+	progress := newProgressWriter(writer, g.logger, fmt.Sprintf("archive of project %d", projectID))
+
+	var err error
+	for attempt := 1; attempt <= downloadAttempts; attempt++ {
+		err = g.downloadArchive(ctx, path, progress)
+		if err == nil {
+			progress.finish()
+			return nil
+		}
+
+		var interrupted *interruptedError
+		if !errors.As(err, &interrupted) || ctx.Err() != nil {
+			return err
+		}
+		g.logger.Warnf("Archive download interrupted after %d bytes (attempt %d of %d): %v", progress.written, attempt, downloadAttempts, err)
+	}
+
+	g.logger.Errorf("failed to download archive of project %d: %v", projectID, err)
+	return err
+}
+
+// downloadArchive makes one attempt to download the archive, continuing from
+// the bytes already written to progress.
+func (g *HTTPGitLabGateway) downloadArchive(ctx context.Context, path string, progress *progressWriter) error {
+	req, err := g.newRequest(ctx, "GET", path, nil)
+	if err != nil {
+		g.logger.Errorf("failed to create gitlab api request: %v", err)
+		return err
+	}
+
+	offset := progress.written
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	// Failed requests are retried by the transport; only a body that breaks
+	// off is continued here.
+	resp, err := g.Client.Do(req)
+	if err != nil {
+		g.logger.Errorf("failed to send request to gitlab api: %v", err)
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && offset > 0:
+		start, ok := contentRangeStart(resp.Header.Get("Content-Range"))
+		if !ok || start != offset {
+			return fmt.Errorf("gitlab returned an unexpected range %q for offset %d", resp.Header.Get("Content-Range"), offset)
+		}
+		g.logger.Infof("Resuming archive download from byte %d", offset)
+		if resp.ContentLength >= 0 {
+			progress.total = offset + resp.ContentLength
+		}
+	case resp.StatusCode == http.StatusOK:
+		if offset > 0 {
+			g.logger.Infof("Server does not support resuming, restarting archive download")
+			if err := progress.rewind(); err != nil {
+				return err
+			}
+		}
+		progress.total = resp.ContentLength
+	default:
+		err := responseError(resp, "download archive")
+		g.logger.Error(err)
+		return err
+	}
+
+	if _, err := io.Copy(progress, resp.Body); err != nil {
+		if progress.writeErr != nil {
+			// The destination failed, not the connection: retrying will not help.
+			return progress.writeErr
+		}
+		return &interruptedError{err: err}
+	}
+
+	if progress.total >= 0 && progress.written != progress.total {
+		return &interruptedError{err: fmt.Errorf("archive is truncated: got %d of %d bytes", progress.written, progress.total)}
+	}
+
+	return nil
+}
+
+// contentRangeStart parses the first byte position of a "bytes start-end/size" header.
+func contentRangeStart(header string) (int64, bool) {
+	spec, ok := strings.CutPrefix(header, "bytes ")
+	if !ok {
+		return 0, false
+	}
+	start, _, ok := strings.Cut(spec, "-")
+	if !ok {
+		return 0, false
+	}
+	value, err := strconv.ParseInt(start, 10, 64)
+	return value, err == nil
+}
+
+// progressWriter counts the bytes written to the destination and logs the progress.
+type progressWriter struct {
+	writer   io.Writer
+	logger   logger.Logger
+	name     string
+	written  int64
+	total    int64 // -1 if unknown
+	writeErr error
+	started  time.Time
+	lastLog  time.Time
+}
+
+func newProgressWriter(writer io.Writer, log logger.Logger, name string) *progressWriter {
+	now := time.Now()
+	return &progressWriter{writer: writer, logger: log, name: name, total: -1, started: now, lastLog: now}
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	n, err := p.writer.Write(b)
+	p.written += int64(n)
+	if err != nil {
+		p.writeErr = err
+		return n, err
+	}
+	if time.Since(p.lastLog) >= progressInterval {
+		p.lastLog = time.Now()
+		p.report()
+	}
+	return n, nil
+}
+
+func (p *progressWriter) report() {
+	if p.total > 0 {
+		p.logger.Infof("Downloading %s: %.1f of %.1f MiB (%d%%)", p.name, mebibytes(p.written), mebibytes(p.total), p.written*100/p.total)
+		return
+	}
+	p.logger.Infof("Downloading %s: %.1f MiB", p.name, mebibytes(p.written))
+}
+
+func (p *progressWriter) finish() {
+	p.logger.Infof("Downloaded %s: %.1f MiB in %s", p.name, mebibytes(p.written), time.Since(p.started).Round(time.Millisecond))
+}
+
+// rewind discards everything written so far, if the destination allows it.
+func (p *progressWriter) rewind() error {
+	file, ok := p.writer.(interface {
+		io.Seeker
+		Truncate(size int64) error
+	})
+	if !ok {
+		return fmt.Errorf("cannot restart download of %s: destination cannot be truncated", p.name)
+	}
+	if err := file.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	p.written = 0
+	return nil
+}
+
+func mebibytes(n int64) float64 {
+	return float64(n) / (1 << 20)
+}
@@ -0,0 +1,200 @@
+package gitlab
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/olegshirko/reposqueeze/internal/domain/gateway"
+)
+
+const testArchive = "PK-archive-content-0123456789"
+
+// dropConnection sends the first n bytes of the archive announcing all of
+// them and then closes the connection.
+func dropConnection(w http.ResponseWriter, n int) {
+	w.Header().Set("Content-Length", strconv.Itoa(len(testArchive)))
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(testArchive[:n]))
+	w.(http.Flusher).Flush()
+	panic(http.ErrAbortHandler)
+}
+
+func TestDownloadRepoArchive(t *testing.T) {
+	tests := []struct {
+		name string
+		// respond answers the request number n, counted from 1.
+		respond     func(n int, w http.ResponseWriter, r *http.Request)
+		buffer      bool // download into a bytes.Buffer instead of a file
+		wantRanges  []string
+		wantArchive string
+		wantErr     string
+	}{
+		{
+			name: "complete",
+			respond: func(n int, w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(testArchive))
+			},
+			wantRanges:  []string{""},
+			wantArchive: testArchive,
+		},
+		{
+			name: "resumed",
+			respond: func(n int, w http.ResponseWriter, r *http.Request) {
+				if n == 1 {
+					dropConnection(w, 10)
+				}
+				w.Header().Set("Content-Range", fmt.Sprintf("bytes 10-%d/%d", len(testArchive)-1, len(testArchive)))
+				w.WriteHeader(http.StatusPartialContent)
+				w.Write([]byte(testArchive[10:]))
+			},
+			wantRanges:  []string{"", "bytes=10-"},
+			wantArchive: testArchive,
+		},
+		{
+			// The server ignores the Range header and sends the whole archive again.
+			name: "restarted",
+			respond: func(n int, w http.ResponseWriter, r *http.Request) {
+				if n == 1 {
+					dropConnection(w, 10)
+				}
+				w.Write([]byte(testArchive))
+			},
+			wantRanges:  []string{"", "bytes=10-"},
+			wantArchive: testArchive,
+		},
+		{
+			name: "restart without truncate",
+			respond: func(n int, w http.ResponseWriter, r *http.Request) {
+				if n == 1 {
+					dropConnection(w, 10)
+				}
+				w.Write([]byte(testArchive))
+			},
+			buffer:     true,
+			wantRanges: []string{"", "bytes=10-"},
+			wantErr:    "cannot restart download of archive of project 1: destination cannot be truncated",
+		},
+		{
+			name: "unexpected range",
+			respond: func(n int, w http.ResponseWriter, r *http.Request) {
+				if n == 1 {
+					dropConnection(w, 10)
+				}
+				w.Header().Set("Content-Range", fmt.Sprintf("bytes 0-%d/%d", len(testArchive)-1, len(testArchive)))
+				w.WriteHeader(http.StatusPartialContent)
+				w.Write([]byte(testArchive))
+			},
+			wantRanges: []string{"", "bytes=10-"},
+			wantErr:    `gitlab returned an unexpected range "bytes 0-28/29" for offset 10`,
+		},
+		{
+			name: "dropped every time",
+			respond: func(n int, w http.ResponseWriter, r *http.Request) {
+				if n == 1 {
+					dropConnection(w, 10)
+				}
+				w.Header().Set("Content-Range", fmt.Sprintf("bytes 10-%d/%d", len(testArchive)-1, len(testArchive)))
+				w.Header().Set("Content-Length", strconv.Itoa(len(testArchive)-10))
+				w.WriteHeader(http.StatusPartialContent)
+				w.(http.Flusher).Flush()
+				panic(http.ErrAbortHandler)
+			},
+			wantRanges: []string{"", "bytes=10-", "bytes=10-"},
+			wantErr:    "unexpected EOF",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ranges []string
+			g := testGateway(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/api/v4/projects/1/repository/archive.zip" || r.URL.Query().Get("sha") != "main" {
+					t.Errorf("request to %s", r.URL)
+				}
+				ranges = append(ranges, r.Header.Get("Range"))
+				tt.respond(len(ranges), w, r)
+			}))
+
+			var destination io.Writer = &bytes.Buffer{}
+			path := filepath.Join(t.TempDir(), "archive.zip")
+			if !tt.buffer {
+				file, err := os.Create(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer file.Close()
+				destination = file
+			}
+
+			err := g.DownloadRepoArchive(context.Background(), 1, gateway.ArchiveOptions{Ref: "main"}, destination)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("DownloadRepoArchive error = %v, want one containing %q", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatalf("DownloadRepoArchive: %v", err)
+			}
+			if !slices.Equal(ranges, tt.wantRanges) {
+				t.Errorf("Range headers %q, want %q", ranges, tt.wantRanges)
+			}
+			if tt.wantArchive == "" {
+				return
+			}
+			got, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.wantArchive {
+				t.Errorf("archive %q, want %q", got, tt.wantArchive)
+			}
+		})
+	}
+}
+
+// roundTripFunc answers requests without a server.
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+// A body that ends cleanly before the announced length, as a proxy may send
+// it, is retried and finally reported as truncated.
+func TestDownloadRepoArchiveTruncated(t *testing.T) {
+	g := testGateway(t, http.NotFoundHandler())
+	attempts := 0
+	g.Client.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		attempts++
+		return &http.Response{
+			StatusCode:    http.StatusOK,
+			ContentLength: int64(len(testArchive)),
+			Body:          io.NopCloser(strings.NewReader(testArchive[:10])),
+			Request:       req,
+		}, nil
+	})
+
+	file, err := os.Create(filepath.Join(t.TempDir(), "archive.zip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	err = g.DownloadRepoArchive(context.Background(), 1, gateway.ArchiveOptions{}, file)
+	var interrupted *interruptedError
+	if !errors.As(err, &interrupted) {
+		t.Fatalf("DownloadRepoArchive error = %v, want an interrupted download", err)
+	}
+	if want := "archive is truncated: got 10 of 29 bytes"; err.Error() != want {
+		t.Errorf("error %q, want %q", err, want)
+	}
+	if attempts != downloadAttempts {
+		t.Errorf("%d attempts, want %d", attempts, downloadAttempts)
+	}
+}
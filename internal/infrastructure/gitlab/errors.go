@@ -0,0 +1,116 @@
+package gitlab
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/olegshirko/reposqueeze/internal/domain/entity"
+)
+
+const (
+	// maxErrorBodySize is how much of an error response is read.
+	maxErrorBodySize = 64 << 10
+	// maxErrorBodyQuote is how much of an error response that is not JSON is quoted.
+	maxErrorBodyQuote = 512
+)
+
+// responseError returns the error for a response with an unexpected status,
+// of the entity type that matches the status code, with the message decoded
+// from the body. operation names the request in the message.
+func responseError(resp *http.Response, operation string) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBodySize))
+	return newResponseError(resp, operation, body)
+}
+
+// newResponseError is responseError for a body that has been read already.
+func newResponseError(resp *http.Response, operation string, body []byte) error {
+	err := entity.NewAPIError(&entity.APIError{
+		Operation:  operation,
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+		Message:    decodeMessage(body),
+	})
+	var rateLimited *entity.RateLimitedError
+	if errors.As(err, &rateLimited) {
+		rateLimited.RetryAfter, _ = serverWait(resp, time.Now())
+	}
+	return err
+}
+
+// decodeMessage extracts the message of a GitLab error response. GitLab
+// reports most errors as {"message": "..."}, validation errors as
+// {"message": {"field": ["..."]}} and OAuth errors as {"error": "...",
+// "error_description": "..."}. Other bodies are quoted as they are.
+func decodeMessage(body []byte) string {
+	var response struct {
+		Message          json.RawMessage `json:"message"`
+		Error            string          `json:"error"`
+		ErrorDescription string          `json:"error_description"`
+	}
+	if err := json.Unmarshal(body, &response); err == nil {
+		if message := decodeMessageField(response.Message); message != "" {
+			return message
+		}
+		if response.Error != "" {
+			if response.ErrorDescription != "" {
+				return response.Error + ": " + response.ErrorDescription
+			}
+			return response.Error
+		}
+	}
+
+	text := strings.TrimSpace(string(body))
+	if len(text) > maxErrorBodyQuote {
+		text = text[:maxErrorBodyQuote] + "..."
+	}
+	return text
+}
+
+// decodeMessageField renders the message field, which is a string, a list
+// of strings or a map from the invalid fields to their errors.
+func decodeMessageField(raw json.RawMessage) string {
+	if len(raw) == 0 {
+		return ""
+	}
+	var text string
+	if err := json.Unmarshal(raw, &text); err == nil {
+		return text
+	}
+	var list []string
+	if err := json.Unmarshal(raw, &list); err == nil {
+		return strings.Join(list, "; ")
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return string(raw)
+	}
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var parts []string
+	for _, name := range names {
+		var messages []string
+		if err := json.Unmarshal(fields[name], &messages); err != nil {
+			parts = append(parts, fmt.Sprintf("%s %s", name, fields[name]))
+			continue
+		}
+		for _, message := range messages {
+			parts = append(parts, name+" "+message)
+		}
+	}
+	return strings.Join(parts, "; ")
+}
+
+// isStatus reports whether err is a GitLab API error with the given status code.
+func isStatus(err error, statusCode int) bool {
+	var apiError *entity.APIError
+	return errors.As(err, &apiError) && apiError.StatusCode == statusCode
+}
@@ -6,10 +6,12 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/olegshirko/reposqueeze/internal/domain/entity"
 	"github.com/olegshirko/reposqueeze/internal/domain/gateway"
@@ -19,78 +21,139 @@ import (
 // HTTPGitLabGateway is an implementation of the GitLabGateway that uses net/http.
 type HTTPGitLabGateway struct {
 	Client *http.Client
-	Token  string
+	// Transport retries the requests of Client and limits how long they take.
+	// Its settings may be changed before the first request.
+	Transport  *RetryTransport
+	BaseURL    string // Root URL of the GitLab instance, e.g. https://gitlab.com
+	APIVersion string // REST API version, e.g. v4
+	Token      string
+
+	// DeletionTimeout limits how long DeleteProject waits for GitLab to remove a project.
+	DeletionTimeout time.Duration
+	// DeletionPollInterval is the delay between checks while waiting for a deletion.
+	DeletionPollInterval time.Duration
+	// PermanentlyRemove makes DeleteProject remove projects that GitLab only marked
+	// for delayed deletion, so their name and path can be reused at once.
+	PermanentlyRemove bool
+
 	logger logger.Logger
 }
 
 // NewHTTPGitLabGateway creates a new instance of HTTPGitLabGateway.
-func NewHTTPGitLabGateway(token string, log logger.Logger) *HTTPGitLabGateway {
+// baseURL is expected to be validated with config.NormalizeBaseURL.
+func NewHTTPGitLabGateway(baseURL, apiVersion, token string, log logger.Logger) *HTTPGitLabGateway {
+	transport := NewRetryTransport(http.DefaultTransport, log)
 	return &HTTPGitLabGateway{
-		Client: http.DefaultClient,
-		Token:  token,
+		Client:     &http.Client{Transport: transport},
+		Transport:  transport,
+		BaseURL:    strings.TrimRight(baseURL, "/"),
+		APIVersion: apiVersion,
+		Token:      token,
+
+		DeletionTimeout:      DefaultDeletionTimeout,
+		DeletionPollInterval: DefaultDeletionPollInterval,
+
 		logger: log,
 	}
 }
 
+// apiURL returns the absolute URL of an API endpoint.
+// path must start with a slash and may contain a query string.
+func (g *HTTPGitLabGateway) apiURL(path string) string {
+	return g.BaseURL + "/api/" + g.APIVersion + path
+}
+
+// newRequest builds an authenticated request to the GitLab API.
+func (g *HTTPGitLabGateway) newRequest(ctx context.Context, method, path string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, g.apiURL(path), body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("PRIVATE-TOKEN", g.Token)
+	return req, nil
+}
+
 // commitPayload is the structure for the GitLab Commits API request body.
 type commitPayload struct {
-	Branch        string                 `json:"branch"`
-	CommitMessage string                 `json:"commit_message"`
-	Actions       []gateway.CommitAction `json:"actions"`
+	Branch        string                `json:"branch"`
+	CommitMessage string                `json:"commit_message"`
+	Actions       []commitActionPayload `json:"actions"`
+}
+
+// commitActionPayload is a single action of the GitLab Commits API request body.
+type commitActionPayload struct {
+	Action          string `json:"action"`
+	FilePath        string `json:"file_path"`
+	Content         string `json:"content"`
+	Encoding        string `json:"encoding"`
+	ExecuteFilemode bool   `json:"execute_filemode,omitempty"`
 }
 
 // CommitFilesViaAPI creates a new commit in a GitLab repository with a set of file actions.
-func (g *HTTPGitLabGateway) CommitFilesViaAPI(projectID, branchName, commitMessage string, actions []gateway.CommitAction) error {
+func (g *HTTPGitLabGateway) CommitFilesViaAPI(ctx context.Context, projectID, branchName, commitMessage string, actions []gateway.CommitAction) (*entity.Commit, error) {
 	// 1. Prepare the API payload
-	for i := range actions {
-		actions[i].Content = base64.StdEncoding.EncodeToString([]byte(actions[i].Content))
-		actions[i].Encoding = "base64"
+	// Content is only base64-encoded for actions that ask for it.
+	encoded := make([]commitActionPayload, len(actions))
+	for i, action := range actions {
+		content := string(action.Content)
+		if action.Encoding == "base64" {
+			content = base64.StdEncoding.EncodeToString(action.Content)
+		}
+		encoded[i] = commitActionPayload{
+			Action:          action.Action,
+			FilePath:        action.FilePath,
+			Content:         content,
+			Encoding:        action.Encoding,
+			ExecuteFilemode: action.ExecuteFilemode,
+		}
 	}
 
 	payload := commitPayload{
 		Branch:        branchName,
 		CommitMessage: commitMessage,
-		Actions:       actions,
+		Actions:       encoded,
 	}
 
 	payloadBytes, err := json.Marshal(payload)
 	if err != nil {
-		g.logger.Errorf("failed to marshal gitlab commit payload: %w", err)
-		return err
+		g.logger.Errorf("failed to marshal gitlab commit payload: %v", err)
+		return nil, err
 	}
 
-	// 2. Construct the API endpoint URL
+	// 2. Create the HTTP request
 	// We need to URL-encode the project ID in case it contains slashes (e.g., "group/project")
-	apiURL := fmt.Sprintf("https://gitlab.com/api/v4/projects/%s/repository/commits", url.PathEscape(projectID))
-
-	// 3. Create the HTTP request
-	req, err := http.NewRequest("POST", apiURL, bytes.NewBuffer(payloadBytes))
+	path := fmt.Sprintf("/projects/%s/repository/commits", url.PathEscape(projectID))
+	req, err := g.newRequest(ctx, "POST", path, bytes.NewBuffer(payloadBytes))
 	if err != nil {
-		g.logger.Errorf("failed to create gitlab api request: %w", err)
-		return err
+		g.logger.Errorf("failed to create gitlab api request: %v", err)
+		return nil, err
 	}
 
-	// 4. Set necessary headers
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("PRIVATE-TOKEN", g.Token)
-
-	// 5. Send the request
+	// 3. Send the request
 	resp, err := g.Client.Do(req)
 	if err != nil {
-		g.logger.Errorf("failed to send request to gitlab api: %w", err)
-		return err
+		g.logger.Errorf("failed to send request to gitlab api: %v", err)
+		return nil, err
 	}
 	defer resp.Body.Close()
 
-	// 6. Check the response status code
+	// 4. Check the response status code
 	if resp.StatusCode != http.StatusCreated {
-		body, _ := ioutil.ReadAll(resp.Body)
-		err := fmt.Errorf("gitlab api returned non-201 status: %s, body: %s", resp.Status, string(body))
+		err := responseError(resp, "create commit")
 		g.logger.Error(err)
-		return err
+		return nil, err
 	}
 
-	return nil
+	var commit entity.Commit
+	if err := json.NewDecoder(resp.Body).Decode(&commit); err != nil {
+		g.logger.Errorf("failed to decode gitlab commit: %v", err)
+		return nil, err
+	}
+
+	return &commit, nil
 }
 
 type createBranchPayload struct {
@@ -107,36 +170,29 @@ func (g *HTTPGitLabGateway) CreateRemoteBranch(ctx context.Context, projectID, b
 
 	payloadBytes, err := json.Marshal(payload)
 	if err != nil {
-		g.logger.Errorf("failed to marshal gitlab create branch payload: %w", err)
+		g.logger.Errorf("failed to marshal gitlab create branch payload: %v", err)
 		return err
 	}
 
-	// 2. Construct the API endpoint URL
-	apiURL := fmt.Sprintf("https://gitlab.com/api/v4/projects/%s/repository/branches", url.PathEscape(projectID))
-
-	// 3. Create the HTTP request
-	req, err := http.NewRequestWithContext(ctx, "POST", apiURL, bytes.NewBuffer(payloadBytes))
+	// 2. Create the HTTP request
+	path := fmt.Sprintf("/projects/%s/repository/branches", url.PathEscape(projectID))
+	req, err := g.newRequest(ctx, "POST", path, bytes.NewBuffer(payloadBytes))
 	if err != nil {
-		g.logger.Errorf("failed to create gitlab api request: %w", err)
+		g.logger.Errorf("failed to create gitlab api request: %v", err)
 		return err
 	}
 
-	// 4. Set necessary headers
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("PRIVATE-TOKEN", g.Token) // Используем токен из структуры
-
-	// 5. Send the request
+	// 3. Send the request
 	resp, err := g.Client.Do(req)
 	if err != nil {
-		g.logger.Errorf("failed to send request to gitlab api: %w", err)
+		g.logger.Errorf("failed to send request to gitlab api: %v", err)
 		return err
 	}
 	defer resp.Body.Close()
 
-	// 6. Check the response status code
+	// 4. Check the response status code
 	if resp.StatusCode != http.StatusCreated {
-		body, _ := ioutil.ReadAll(resp.Body)
-		err := fmt.Errorf("gitlab api returned non-201 status for create branch: %s, body: %s", resp.Status, string(body))
+		err := responseError(resp, "create branch")
 		g.logger.Error(err)
 		return err
 	}
@@ -144,34 +200,31 @@ func (g *HTTPGitLabGateway) CreateRemoteBranch(ctx context.Context, projectID, b
 	return nil
 }
 
-func (g *HTTPGitLabGateway) FindProjectByName(projectName string) (*entity.Project, error) {
-	apiURL := fmt.Sprintf("https://gitlab.com/api/v4/projects?owned=true&search=%s", url.QueryEscape(projectName))
+func (g *HTTPGitLabGateway) FindProjectByName(ctx context.Context, projectName string) (*entity.Project, error) {
+	path := fmt.Sprintf("/projects?owned=true&search=%s", url.QueryEscape(projectName))
 
-	req, err := http.NewRequest("GET", apiURL, nil)
+	req, err := g.newRequest(ctx, "GET", path, nil)
 	if err != nil {
-		g.logger.Errorf("failed to create gitlab api request: %w", err)
+		g.logger.Errorf("failed to create gitlab api request: %v", err)
 		return nil, err
 	}
 
-	req.Header.Set("PRIVATE-TOKEN", g.Token)
-
 	resp, err := g.Client.Do(req)
 	if err != nil {
-		g.logger.Errorf("failed to send request to gitlab api: %w", err)
+		g.logger.Errorf("failed to send request to gitlab api: %v", err)
 		return nil, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		body, _ := ioutil.ReadAll(resp.Body)
-		err := fmt.Errorf("gitlab api returned non-200 status for find project: %s, body: %s", resp.Status, string(body))
+		err := responseError(resp, "find project")
 		g.logger.Error(err)
 		return nil, err
 	}
 
 	var projects []entity.Project
 	if err := json.NewDecoder(resp.Body).Decode(&projects); err != nil {
-		g.logger.Errorf("failed to decode gitlab projects: %w", err)
+		g.logger.Errorf("failed to decode gitlab projects: %v", err)
 		return nil, err
 	}
 
@@ -187,7 +240,12 @@ func (g *HTTPGitLabGateway) FindProjectByName(projectName string) (*entity.Proje
 	}
 
 	if len(matchingProjects) > 1 {
-		err := fmt.Errorf("found multiple projects with name %s, please specify the full path", projectName)
+		paths := make([]string, 0, len(matchingProjects))
+		for _, p := range matchingProjects {
+			paths = append(paths, p.PathWithNamespace)
+		}
+		err := entity.ValidationFailed("found multiple projects with name %s (%s), please specify the full path",
+			projectName, strings.Join(paths, ", "))
 		g.logger.Error(err)
 		return nil, err
 	}
@@ -195,22 +253,43 @@ func (g *HTTPGitLabGateway) FindProjectByName(projectName string) (*entity.Proje
 	return nil, nil // Not found
 }
 
-func (g *HTTPGitLabGateway) DeleteProject(projectID int) error {
-	apiURL := fmt.Sprintf("https://gitlab.com/api/v4/projects/%s", strconv.Itoa(projectID))
-
-	req, err := http.NewRequest("DELETE", apiURL, nil)
+// DeleteProject deletes a project and waits until GitLab has actually removed it
+// or marked it for deletion, see waitForDeletion.
+func (g *HTTPGitLabGateway) DeleteProject(ctx context.Context, projectID int) error {
+	project, err := g.GetProject(ctx, projectID)
 	if err != nil {
-		g.logger.Errorf("failed to create gitlab api request: %w", err)
+		return err
+	}
+	if project == nil {
+		g.logger.Infof("Project %d does not exist, nothing to delete", projectID)
+		return nil
+	}
+
+	if err := g.sendDeleteProject(ctx, projectID, ""); err != nil {
 		return err
 	}
 
-	req.Header.Set("PRIVATE-TOKEN", g.Token)
+	return g.waitForDeletion(ctx, project)
+}
+
+// sendDeleteProject sends the DELETE request for a project. query is appended to the URL as is.
+func (g *HTTPGitLabGateway) sendDeleteProject(ctx context.Context, projectID int, query string) error {
+	path := fmt.Sprintf("/projects/%s", strconv.Itoa(projectID))
+	if query != "" {
+		path += "?" + query
+	}
+
+	req, err := g.newRequest(ctx, "DELETE", path, nil)
+	if err != nil {
+		g.logger.Errorf("failed to create gitlab api request: %v", err)
+		return err
+	}
 
 	// Log request details
-	g.logger.Infof("Deleting project. Request URL: %s", apiURL)
+	g.logger.Infof("Deleting project. Request URL: %s", req.URL)
 	g.logger.Info("Request Headers:")
 	for name, values := range req.Header {
-		if name != "PRIVATE-TOKEN" {
+		if name != http.CanonicalHeaderKey("PRIVATE-TOKEN") {
 			for _, value := range values {
 				g.logger.Infof("  %s: %s", name, value)
 			}
@@ -219,18 +298,18 @@ func (g *HTTPGitLabGateway) DeleteProject(projectID int) error {
 
 	resp, err := g.Client.Do(req)
 	if err != nil {
-		g.logger.Errorf("failed to send request to gitlab api: %w", err)
+		g.logger.Errorf("failed to send request to gitlab api: %v", err)
 		return err
 	}
 	defer resp.Body.Close()
 
 	// Log response details
-	body, _ := ioutil.ReadAll(resp.Body)
+	body, _ := io.ReadAll(resp.Body)
 	g.logger.Infof("GitLab API Response Status: %s", resp.Status)
 	g.logger.Infof("GitLab API Response Body: %s", string(body))
 
-	if resp.StatusCode != http.StatusAccepted {
-		err := fmt.Errorf("gitlab api returned non-202 status for delete project: %s, body: %s", resp.Status, string(body))
+	if resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusNoContent {
+		err := newResponseError(resp, "delete project", body)
 		g.logger.Error(err)
 		return err
 	}
@@ -238,85 +317,259 @@ func (g *HTTPGitLabGateway) DeleteProject(projectID int) error {
 	return nil
 }
 
+// GetProject returns a project by its ID, or nil if it does not exist.
+func (g *HTTPGitLabGateway) GetProject(ctx context.Context, projectID int) (*entity.Project, error) {
+	return g.getProject(ctx, strconv.Itoa(projectID))
+}
+
+// GetProjectByPath returns a project by its full path with namespace, or nil if it does not exist.
+func (g *HTTPGitLabGateway) GetProjectByPath(ctx context.Context, fullPath string) (*entity.Project, error) {
+	return g.getProject(ctx, url.PathEscape(strings.Trim(fullPath, "/")))
+}
+
+// getProject fetches a project by its ID or URL-encoded full path.
+func (g *HTTPGitLabGateway) getProject(ctx context.Context, id string) (*entity.Project, error) {
+	req, err := g.newRequest(ctx, "GET", "/projects/"+id, nil)
+	if err != nil {
+		g.logger.Errorf("failed to create gitlab api request: %v", err)
+		return nil, err
+	}
+
+	resp, err := g.Client.Do(req)
+	if err != nil {
+		g.logger.Errorf("failed to send request to gitlab api: %v", err)
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil // Not found
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		err := responseError(resp, "get project")
+		g.logger.Error(err)
+		return nil, err
+	}
+
+	var project entity.Project
+	if err := json.NewDecoder(resp.Body).Decode(&project); err != nil {
+		g.logger.Errorf("failed to decode gitlab project: %v", err)
+		return nil, err
+	}
+
+	return &project, nil
+}
+
+// GetNamespace returns a namespace by its full path, or nil if it does not exist.
+func (g *HTTPGitLabGateway) GetNamespace(ctx context.Context, fullPath string) (*entity.Namespace, error) {
+	path := "/namespaces/" + url.PathEscape(strings.Trim(fullPath, "/"))
+	req, err := g.newRequest(ctx, "GET", path, nil)
+	if err != nil {
+		g.logger.Errorf("failed to create gitlab api request: %v", err)
+		return nil, err
+	}
+
+	resp, err := g.Client.Do(req)
+	if err != nil {
+		g.logger.Errorf("failed to send request to gitlab api: %v", err)
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil // Not found
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		err := responseError(resp, "get namespace")
+		g.logger.Error(err)
+		return nil, err
+	}
+
+	var namespace entity.Namespace
+	if err := json.NewDecoder(resp.Body).Decode(&namespace); err != nil {
+		g.logger.Errorf("failed to decode gitlab namespace: %v", err)
+		return nil, err
+	}
+
+	return &namespace, nil
+}
+
 type createProjectPayload struct {
-	Name string `json:"name"`
+	Name          string `json:"name"`
+	Path          string `json:"path,omitempty"`
+	NamespaceID   int    `json:"namespace_id,omitempty"`
+	Visibility    string `json:"visibility,omitempty"`
+	Description   string `json:"description,omitempty"`
+	DefaultBranch string `json:"default_branch,omitempty"`
 }
 
-func (g *HTTPGitLabGateway) CreateProject(name string) (*entity.Project, error) {
+// CreateProject creates a project. Options that are not set keep the GitLab defaults.
+func (g *HTTPGitLabGateway) CreateProject(ctx context.Context, options gateway.CreateProjectOptions) (*entity.Project, error) {
 	payload := createProjectPayload{
-		Name: name,
+		Name:          options.Name,
+		Path:          options.Path,
+		NamespaceID:   options.NamespaceID,
+		Visibility:    options.Visibility,
+		Description:   options.Description,
+		DefaultBranch: options.DefaultBranch,
 	}
 
 	payloadBytes, err := json.Marshal(payload)
 	if err != nil {
-		g.logger.Errorf("failed to marshal gitlab create project payload: %w", err)
+		g.logger.Errorf("failed to marshal gitlab create project payload: %v", err)
 		return nil, err
 	}
 
-	apiURL := "https://gitlab.com/api/v4/projects"
-
-	req, err := http.NewRequest("POST", apiURL, bytes.NewBuffer(payloadBytes))
+	req, err := g.newRequest(ctx, "POST", "/projects", bytes.NewBuffer(payloadBytes))
 	if err != nil {
-		g.logger.Errorf("failed to create gitlab api request: %w", err)
+		g.logger.Errorf("failed to create gitlab api request: %v", err)
 		return nil, err
 	}
 
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("PRIVATE-TOKEN", g.Token)
-
 	resp, err := g.Client.Do(req)
 	if err != nil {
-		g.logger.Errorf("failed to send request to gitlab api: %w", err)
+		g.logger.Errorf("failed to send request to gitlab api: %v", err)
 		return nil, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusCreated {
-		body, _ := ioutil.ReadAll(resp.Body)
-		err := fmt.Errorf("gitlab api returned non-201 status for create project: %s, body: %s", resp.Status, string(body))
+		err := responseError(resp, "create project")
 		g.logger.Error(err)
 		return nil, err
 	}
 
 	var project entity.Project
 	if err := json.NewDecoder(resp.Body).Decode(&project); err != nil {
-		g.logger.Errorf("failed to decode gitlab project: %w", err)
+		g.logger.Errorf("failed to decode gitlab project: %v", err)
 		return nil, err
 	}
 
 	return &project, nil
 }
 
-func (g *HTTPGitLabGateway) DownloadRepoArchive(projectID int, writer *bytes.Buffer) error {
-	apiURL := fmt.Sprintf("https://gitlab.com/api/v4/projects/%d/repository/archive.zip", projectID)
+type renameProjectPayload struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+}
 
-	req, err := http.NewRequest("GET", apiURL, nil)
+// RenameProject changes the name and the path of a project, which frees the old
+// name and path in the namespace for a new project.
+func (g *HTTPGitLabGateway) RenameProject(ctx context.Context, projectID int, name, path string) (*entity.Project, error) {
+	payload := renameProjectPayload{
+		Name: name,
+		Path: path,
+	}
+
+	payloadBytes, err := json.Marshal(payload)
 	if err != nil {
-		g.logger.Errorf("failed to create gitlab api request: %w", err)
-		return err
+		g.logger.Errorf("failed to marshal gitlab rename project payload: %v", err)
+		return nil, err
 	}
 
-	req.Header.Set("PRIVATE-TOKEN", g.Token)
+	req, err := g.newRequest(ctx, "PUT", fmt.Sprintf("/projects/%d", projectID), bytes.NewBuffer(payloadBytes))
+	if err != nil {
+		g.logger.Errorf("failed to create gitlab api request: %v", err)
+		return nil, err
+	}
 
 	resp, err := g.Client.Do(req)
 	if err != nil {
-		g.logger.Errorf("failed to send request to gitlab api: %w", err)
-		return err
+		g.logger.Errorf("failed to send request to gitlab api: %v", err)
+		return nil, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		body, _ := ioutil.ReadAll(resp.Body)
-		err := fmt.Errorf("gitlab api returned non-200 status for download archive: %s, body: %s", resp.Status, string(body))
+		err := responseError(resp, "rename project")
 		g.logger.Error(err)
-		return err
+		return nil, err
+	}
+
+	var project entity.Project
+	if err := json.NewDecoder(resp.Body).Decode(&project); err != nil {
+		g.logger.Errorf("failed to decode gitlab project: %v", err)
+		return nil, err
+	}
+
+	return &project, nil
+}
+
+// branchResponse is the subset of the GitLab Branches API response we use.
+type branchResponse struct {
+	Name   string `json:"name"`
+	Commit struct {
+		ID      string `json:"id"`
+		Message string `json:"message"`
+	} `json:"commit"`
+}
+
+// GetBranch returns a remote branch with its head commit, or nil if the branch does not exist.
+func (g *HTTPGitLabGateway) GetBranch(ctx context.Context, projectID, branchName string) (*entity.Branch, error) {
+	path := fmt.Sprintf("/projects/%s/repository/branches/%s", url.PathEscape(projectID), url.PathEscape(branchName))
+
+	req, err := g.newRequest(ctx, "GET", path, nil)
+	if err != nil {
+		g.logger.Errorf("failed to create gitlab api request: %v", err)
+		return nil, err
 	}
 
-	_, err = writer.ReadFrom(resp.Body)
+	resp, err := g.Client.Do(req)
 	if err != nil {
-		g.logger.Errorf("failed to read response body: %w", err)
-		return err
+		g.logger.Errorf("failed to send request to gitlab api: %v", err)
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil // Not found
 	}
 
-	return nil
+	if resp.StatusCode != http.StatusOK {
+		err := responseError(resp, "get branch")
+		g.logger.Error(err)
+		return nil, err
+	}
+
+	var branch branchResponse
+	if err := json.NewDecoder(resp.Body).Decode(&branch); err != nil {
+		g.logger.Errorf("failed to decode gitlab branch: %v", err)
+		return nil, err
+	}
+
+	return &entity.Branch{Name: branch.Name, CommitSHA: branch.Commit.ID, CommitMessage: branch.Commit.Message}, nil
+}
+
+// GetVersion returns the version of the GitLab instance.
+// It is used as a startup probe for the base URL, the API version and the token.
+func (g *HTTPGitLabGateway) GetVersion(ctx context.Context) (*entity.GitLabVersion, error) {
+	req, err := g.newRequest(ctx, "GET", "/version", nil)
+	if err != nil {
+		g.logger.Errorf("failed to create gitlab api request: %v", err)
+		return nil, err
+	}
+
+	resp, err := g.Client.Do(req)
+	if err != nil {
+		g.logger.Errorf("failed to send request to gitlab api: %v", err)
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		err := responseError(resp, "version")
+		g.logger.Error(err)
+		return nil, err
+	}
+
+	var version entity.GitLabVersion
+	if err := json.NewDecoder(resp.Body).Decode(&version); err != nil {
+		err = fmt.Errorf("failed to decode gitlab version from %s, is it a gitlab instance? %w", req.URL, err)
+		g.logger.Error(err)
+		return nil, err
+	}
+
+	return &version, nil
 }
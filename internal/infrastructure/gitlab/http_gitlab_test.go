@@ -0,0 +1,43 @@
+package gitlab
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/olegshirko/reposqueeze/internal/domain/entity"
+)
+
+func TestFindProjectByName(t *testing.T) {
+	// The search also finds projects whose name only contains the searched one.
+	g := testGateway(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("search") {
+		case "app":
+			w.Write([]byte(`[{"id":1,"name":"app","path_with_namespace":"group/app"},{"id":2,"name":"app-backup","path_with_namespace":"group/app-backup"}]`))
+		case "lib":
+			w.Write([]byte(`[{"id":3,"name":"lib","path_with_namespace":"group/lib"},{"id":4,"name":"lib","path_with_namespace":"other/lib"}]`))
+		default:
+			w.Write([]byte(`[{"id":5,"name":"tools-old","path_with_namespace":"group/tools-old"}]`))
+		}
+	}))
+	ctx := context.Background()
+
+	project, err := g.FindProjectByName(ctx, "app")
+	if err != nil || project == nil || project.ID != 1 {
+		t.Errorf("FindProjectByName(app) = %+v, %v, want project 1", project, err)
+	}
+	project, err = g.FindProjectByName(ctx, "tools")
+	if err != nil || project != nil {
+		t.Errorf("FindProjectByName(tools) = %+v, %v, want no project", project, err)
+	}
+
+	_, err = g.FindProjectByName(ctx, "lib")
+	var validation *entity.ValidationFailedError
+	if !errors.As(err, &validation) {
+		t.Fatalf("FindProjectByName of an ambiguous name: error %v is not an entity.ValidationFailedError", err)
+	}
+	if want := "found multiple projects with name lib (group/lib, other/lib), please specify the full path"; err.Error() != want {
+		t.Errorf("error %q, want %q", err, want)
+	}
+}
@@ -0,0 +1,70 @@
+package gitlab
+
+import (
+	"context"
+	"net/url"
+	"time"
+
+	"github.com/olegshirko/reposqueeze/internal/domain/entity"
+)
+
+const (
+	// DefaultDeletionTimeout is how long DeleteProject waits for a project to disappear.
+	DefaultDeletionTimeout = 2 * time.Minute
+	// DefaultDeletionPollInterval is the delay between checks of a project being deleted.
+	DefaultDeletionPollInterval = 2 * time.Second
+)
+
+// waitForDeletion polls a project after a DELETE request was accepted.
+//
+// GitLab deletes projects asynchronously, so the project can still exist for a
+// while and keep its name and path taken. On instances with delayed deletion the
+// project is only marked for deletion; in that case it is removed permanently if
+// PermanentlyRemove is set, otherwise the marked project is accepted as deleted.
+// A zero DeletionTimeout disables waiting.
+func (g *HTTPGitLabGateway) waitForDeletion(ctx context.Context, project *entity.Project) error {
+	if g.DeletionTimeout <= 0 {
+		return nil
+	}
+
+	deadline := time.Now().Add(g.DeletionTimeout)
+	permanentRemovalSent := false
+	for {
+		current, err := g.GetProject(ctx, project.ID)
+		if err != nil {
+			return err
+		}
+		if current == nil {
+			g.logger.Infof("Project %s (id %d) has been deleted", project.Name, project.ID)
+			return nil
+		}
+
+		if current.MarkedForDeletion() {
+			if !g.PermanentlyRemove {
+				g.logger.Infof("Project %s (id %d) is marked for deletion", project.Name, project.ID)
+				if current.Path == project.Path {
+					g.logger.Warnf("Project path %s stays taken until the delayed deletion runs, use --permanently-remove to free it now", current.PathWithNamespace)
+				}
+				return nil
+			}
+			if !permanentRemovalSent {
+				query := "permanently_remove=true&full_path=" + url.QueryEscape(current.PathWithNamespace)
+				if err := g.sendDeleteProject(ctx, project.ID, query); err != nil {
+					return err
+				}
+				permanentRemovalSent = true
+			}
+		}
+
+		if time.Now().After(deadline) {
+			// The project keeps its name and path taken, like an object that exists already.
+			err := entity.Conflict("project %s (id %d) was not deleted within %s", project.Name, project.ID, g.DeletionTimeout)
+			g.logger.Error(err)
+			return err
+		}
+		g.logger.Debugf("Waiting for project %s (id %d) to be deleted", project.Name, project.ID)
+		if err := sleep(ctx, g.DeletionPollInterval); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package gitlab
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/olegshirko/reposqueeze/internal/domain/entity"
+)
+
+// deletionServer serves a single project that GitLab deletes asynchronously.
+type deletionServer struct {
+	// marked makes a plain DELETE only mark the project for delayed deletion,
+	// so it is only removed by a DELETE with permanently_remove.
+	marked bool
+	// goneAfter is the number of GET requests that still find the project
+	// after the DELETE that removes it, -1 if it never disappears.
+	goneAfter int
+
+	mu                sync.Mutex
+	removing          bool
+	isMarked          bool
+	deletes           int
+	permanentRemovals int
+}
+
+func (s *deletionServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r.URL.Path != "/api/v4/projects/7" {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		if s.removing && s.goneAfter == 0 {
+			http.NotFound(w, r)
+			return
+		}
+		if s.removing && s.goneAfter > 0 {
+			s.goneAfter--
+		}
+		project := entity.Project{ID: 7, Name: "app", Path: "app", PathWithNamespace: "group/app"}
+		if s.isMarked {
+			project.MarkedForDeletionOn = "2026-10-23"
+		}
+		json.NewEncoder(w).Encode(project)
+	case http.MethodDelete:
+		s.deletes++
+		if r.URL.Query().Get("permanently_remove") == "true" {
+			s.permanentRemovals++
+			if r.URL.Query().Get("full_path") != "group/app" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			s.removing = true
+		} else if s.marked {
+			s.isMarked = true
+		} else {
+			s.removing = true
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+func TestDeleteProjectWaitsForDeletion(t *testing.T) {
+	tests := []struct {
+		name              string
+		marked            bool
+		goneAfter         int
+		permanentlyRemove bool
+		wantConflict      bool // The deadline expires
+		wantDeletes       int
+		wantPermanent     int
+	}{
+		{name: "deleted at once", wantDeletes: 1},
+		{name: "deleted after polls", goneAfter: 3, wantDeletes: 1},
+		{name: "marked for deletion", marked: true, goneAfter: -1, wantDeletes: 1},
+		{name: "permanently removed", marked: true, goneAfter: 2, permanentlyRemove: true, wantDeletes: 2, wantPermanent: 1},
+		{name: "not deleted in time", goneAfter: -1, wantConflict: true, wantDeletes: 1},
+		{
+			name:              "not permanently removed in time",
+			marked:            true,
+			goneAfter:         -1,
+			permanentlyRemove: true,
+			wantConflict:      true,
+			wantDeletes:       2,
+			wantPermanent:     1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gitlab := &deletionServer{marked: tt.marked, goneAfter: tt.goneAfter}
+			g := testGateway(t, gitlab)
+			g.DeletionTimeout = time.Second
+			if tt.wantConflict {
+				g.DeletionTimeout = 50 * time.Millisecond
+			}
+			g.DeletionPollInterval = 5 * time.Millisecond
+			g.PermanentlyRemove = tt.permanentlyRemove
+
+			err := g.DeleteProject(context.Background(), 7)
+			var conflict *entity.ConflictError
+			if tt.wantConflict != errors.As(err, &conflict) || (!tt.wantConflict && err != nil) {
+				t.Errorf("DeleteProject error = %v, want a ConflictError: %t", err, tt.wantConflict)
+			}
+			if gitlab.deletes != tt.wantDeletes || gitlab.permanentRemovals != tt.wantPermanent {
+				t.Errorf("sent %d DELETE requests with %d permanent removals, want %d with %d",
+					gitlab.deletes, gitlab.permanentRemovals, tt.wantDeletes, tt.wantPermanent)
+			}
+		})
+	}
+}
+
+func TestDeleteProjectWithoutWaiting(t *testing.T) {
+	gitlab := &deletionServer{goneAfter: -1}
+	g := testGateway(t, gitlab)
+	g.DeletionTimeout = 0
+
+	if err := g.DeleteProject(context.Background(), 7); err != nil {
+		t.Fatalf("DeleteProject: %v", err)
+	}
+	if gitlab.deletes != 1 {
+		t.Errorf("sent %d DELETE requests, want 1", gitlab.deletes)
+	}
+}
+
+func TestDeleteProjectOfMissingProject(t *testing.T) {
+	gitlab := &deletionServer{}
+	g := testGateway(t, gitlab)
+
+	if err := g.DeleteProject(context.Background(), 8); err != nil {
+		t.Fatalf("DeleteProject: %v", err)
+	}
+	if gitlab.deletes != 0 {
+		t.Errorf("sent %d DELETE requests for a missing project", gitlab.deletes)
+	}
+}
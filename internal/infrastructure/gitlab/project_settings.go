@@ -0,0 +1,325 @@
+package gitlab
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/olegshirko/reposqueeze/internal/domain/entity"
+)
+
+// settingsPageSize is the page size used to list project settings.
+const settingsPageSize = 100
+
+// sendJSON sends body as JSON, if it is not nil, and decodes the response into out, if it is not nil.
+func (g *HTTPGitLabGateway) sendJSON(ctx context.Context, method, path string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(payload)
+	}
+
+	req, err := g.newRequest(ctx, method, path, reader)
+	if err != nil {
+		return err
+	}
+	_, err = g.do(req, out)
+	return err
+}
+
+// do sends a request and decodes a JSON response into out, if it is not nil.
+func (g *HTTPGitLabGateway) do(req *http.Request, out interface{}) (http.Header, error) {
+	resp, err := g.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, newResponseError(resp, req.Method+" "+req.URL.Path, data)
+	}
+	if out != nil && len(data) > 0 {
+		if err := json.Unmarshal(data, out); err != nil {
+			return nil, fmt.Errorf("failed to decode response of %s %s: %w", req.Method, req.URL.Path, err)
+		}
+	}
+	return resp.Header, nil
+}
+
+// getAll fetches every page of a list endpoint.
+func getAll[T any](ctx context.Context, g *HTTPGitLabGateway, path string) ([]T, error) {
+	separator := "?"
+	if strings.Contains(path, "?") {
+		separator = "&"
+	}
+
+	var items []T
+	for page := "1"; page != ""; {
+		req, err := g.newRequest(ctx, "GET", fmt.Sprintf("%s%sper_page=%d&page=%s", path, separator, settingsPageSize, page), nil)
+		if err != nil {
+			return nil, err
+		}
+		var pageItems []T
+		header, err := g.do(req, &pageItems)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, pageItems...)
+		page = header.Get("X-Next-Page")
+	}
+	return items, nil
+}
+
+// SnapshotProjectSettings reads the settings of a project that are lost when it is deleted.
+// A setting that cannot be read, e.g. for lack of permissions, is recorded as a failure
+// and does not stop the snapshot.
+func (g *HTTPGitLabGateway) SnapshotProjectSettings(ctx context.Context, projectID int) (*entity.ProjectSettings, error) {
+	project, err := g.GetProject(ctx, projectID)
+	if err != nil {
+		return nil, err
+	}
+	if project == nil {
+		return nil, entity.NotFound("project %d not found", projectID)
+	}
+
+	settings := &entity.ProjectSettings{
+		Description: project.Description,
+		Topics:      project.Topics,
+	}
+	unreadable := func(setting string, err error) {
+		settings.Failures = append(settings.Failures, entity.SettingFailure{Setting: setting, Reason: "could not be read: " + err.Error()})
+	}
+	base := fmt.Sprintf("/projects/%d", projectID)
+
+	if settings.Members, err = getAll[entity.ProjectMember](ctx, g, base+"/members"); err != nil {
+		unreadable("members", err)
+	}
+
+	variables, err := getAll[entity.CIVariable](ctx, g, base+"/variables")
+	if err != nil {
+		unreadable("variables", err)
+	}
+	for _, variable := range variables {
+		if variable.Hidden || variable.Value == nil {
+			settings.Failures = append(settings.Failures, entity.SettingFailure{
+				Setting: "variable", Item: variable.Key, Reason: "the value is hidden and cannot be read", Unreadable: true,
+			})
+			continue
+		}
+		settings.Variables = append(settings.Variables, variable)
+	}
+
+	if settings.ProtectedBranches, err = g.ListProtectedBranches(ctx, projectID); err != nil {
+		unreadable("protected branches", err)
+	}
+
+	hooks, err := getAll[map[string]interface{}](ctx, g, base+"/hooks")
+	if err != nil {
+		unreadable("webhooks", err)
+	}
+	for _, attributes := range hooks {
+		hookURL, _ := attributes["url"].(string)
+		settings.Hooks = append(settings.Hooks, entity.ProjectHook{URL: hookURL, Attributes: attributes})
+	}
+
+	if settings.Labels, err = getAll[entity.Label](ctx, g, base+"/labels?include_ancestor_groups=false"); err != nil {
+		unreadable("labels", err)
+	}
+
+	if project.AvatarURL != "" {
+		if settings.Avatar, err = g.downloadAvatar(ctx, project); err != nil {
+			unreadable("avatar", err)
+		}
+	}
+
+	return settings, nil
+}
+
+// downloadAvatar fetches the avatar image of a project through the API
+// and falls back to the avatar URL on GitLab versions without the endpoint.
+func (g *HTTPGitLabGateway) downloadAvatar(ctx context.Context, project *entity.Project) (*entity.ProjectAvatar, error) {
+	filename := "avatar.png"
+	if u, err := url.Parse(project.AvatarURL); err == nil && strings.Trim(u.Path, "/") != "" {
+		filename = path.Base(u.Path)
+	}
+
+	req, err := g.newRequest(ctx, "GET", fmt.Sprintf("/projects/%d/avatar", project.ID), nil)
+	if err != nil {
+		return nil, err
+	}
+	content, err := g.download(req)
+	if err == nil {
+		return &entity.ProjectAvatar{Filename: filename, Content: content}, nil
+	}
+	if !isStatus(err, http.StatusNotFound) {
+		return nil, err
+	}
+
+	req, err = http.NewRequestWithContext(ctx, "GET", project.AvatarURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(project.AvatarURL, g.BaseURL+"/") {
+		// The token is only sent to the GitLab instance itself.
+		req.Header.Set("PRIVATE-TOKEN", g.Token)
+	}
+	if content, err = g.download(req); err != nil {
+		return nil, err
+	}
+	return &entity.ProjectAvatar{Filename: filename, Content: content}, nil
+}
+
+// download sends a request and returns the response body.
+func (g *HTTPGitLabGateway) download(req *http.Request) ([]byte, error) {
+	resp, err := g.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, newResponseError(resp, "download "+req.URL.Path, data)
+	}
+	return data, nil
+}
+
+// RestoreProjectSettings applies a snapshot to a project. Every setting is
+// applied on its own; the ones that fail are returned and do not stop the others.
+// Protected branches come last, so they cannot block the other steps.
+func (g *HTTPGitLabGateway) RestoreProjectSettings(ctx context.Context, projectID int, settings *entity.ProjectSettings) ([]entity.SettingFailure, error) {
+	var failures []entity.SettingFailure
+	failed := func(setting, item string, err error) {
+		failures = append(failures, entity.SettingFailure{Setting: setting, Item: item, Reason: err.Error()})
+	}
+	base := fmt.Sprintf("/projects/%d", projectID)
+
+	if settings.Description != "" || len(settings.Topics) > 0 {
+		payload := map[string]interface{}{"description": settings.Description, "topics": settings.Topics}
+		if err := g.sendJSON(ctx, "PUT", base, payload, nil); err != nil {
+			failed("description and topics", "", err)
+		}
+	}
+
+	if settings.Avatar != nil {
+		if err := g.uploadAvatar(ctx, projectID, settings.Avatar); err != nil {
+			failed("avatar", "", err)
+		}
+	}
+
+	for _, label := range settings.Labels {
+		err := g.sendJSON(ctx, "POST", base+"/labels", label, nil)
+		if err != nil && !isStatus(err, http.StatusConflict) {
+			failed("label", label.Name, err)
+		}
+	}
+
+	for _, member := range settings.Members {
+		payload := map[string]interface{}{"user_id": member.ID, "access_level": member.AccessLevel}
+		if member.ExpiresAt != "" {
+			payload["expires_at"] = member.ExpiresAt
+		}
+		// The creator of the project is a member already, which is a conflict.
+		err := g.sendJSON(ctx, "POST", base+"/members", payload, nil)
+		if err != nil && !isStatus(err, http.StatusConflict) {
+			failed("member", member.Username, err)
+		}
+	}
+
+	for _, variable := range settings.Variables {
+		payload := map[string]interface{}{
+			"key":               variable.Key,
+			"value":             *variable.Value,
+			"variable_type":     variable.VariableType,
+			"protected":         variable.Protected,
+			"masked":            variable.Masked,
+			"raw":               variable.Raw,
+			"environment_scope": variable.EnvironmentScope,
+		}
+		if variable.Description != "" {
+			payload["description"] = variable.Description
+		}
+		if err := g.sendJSON(ctx, "POST", base+"/variables", payload, nil); err != nil {
+			failed("variable", variable.Key+" ("+variable.EnvironmentScope+")", err)
+		}
+	}
+
+	for _, hook := range settings.Hooks {
+		if err := g.sendJSON(ctx, "POST", base+"/hooks", hookPayload(hook), nil); err != nil {
+			failed("webhook", hook.URL, err)
+			continue
+		}
+		failures = append(failures, entity.SettingFailure{
+			Setting: "webhook", Item: hook.URL,
+			Reason:     "created without its secret token, which GitLab does not return; set it again if the hook used one",
+			Unreadable: true,
+		})
+	}
+
+	for _, branch := range settings.ProtectedBranches {
+		if err := g.ProtectBranch(ctx, projectID, branch); err != nil {
+			failed("protected branch", branch.Name, err)
+		}
+	}
+
+	return failures, nil
+}
+
+// hookReadOnlyAttributes are the attributes of a hook that cannot be sent back.
+var hookReadOnlyAttributes = []string{
+	"id", "project_id", "created_at", "alert_status", "disabled_until",
+	"url_variables", "custom_headers",
+}
+
+func hookPayload(hook entity.ProjectHook) map[string]interface{} {
+	payload := make(map[string]interface{}, len(hook.Attributes))
+	for name, value := range hook.Attributes {
+		payload[name] = value
+	}
+	for _, name := range hookReadOnlyAttributes {
+		delete(payload, name)
+	}
+	return payload
+}
+
+// uploadAvatar sets the avatar of a project with a multipart request.
+func (g *HTTPGitLabGateway) uploadAvatar(ctx context.Context, projectID int, avatar *entity.ProjectAvatar) error {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, err := writer.CreateFormFile("avatar", avatar.Filename)
+	if err != nil {
+		return err
+	}
+	if _, err := part.Write(avatar.Content); err != nil {
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+
+	req, err := g.newRequest(ctx, "PUT", "/projects/"+strconv.Itoa(projectID), &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	_, err = g.do(req, nil)
+	return err
+}
@@ -0,0 +1,246 @@
+package gitlab
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/olegshirko/reposqueeze/internal/domain/entity"
+)
+
+// apiServer answers the requests of a gateway with the handler of the route,
+// keyed by the method and the escaped path below /api/v4, and records them.
+// Requests without a route get 404.
+type apiServer struct {
+	routes map[string]http.HandlerFunc
+
+	mu       sync.Mutex
+	requests []string
+}
+
+func (s *apiServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	route := r.Method + " " + strings.TrimPrefix(r.URL.EscapedPath(), "/api/v4")
+	s.mu.Lock()
+	s.requests = append(s.requests, route)
+	s.mu.Unlock()
+	if handler := s.routes[route]; handler != nil {
+		handler(w, r)
+		return
+	}
+	reply(http.StatusNotFound, `{"message":"404 Not Found"}`)(w, r)
+}
+
+// reply returns a handler that answers with a status code and a JSON body.
+func reply(statusCode int, body string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(statusCode)
+		w.Write([]byte(body))
+	}
+}
+
+// count returns how many requests were sent to a route.
+func (s *apiServer) count(route string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for _, request := range s.requests {
+		if request == route {
+			n++
+		}
+	}
+	return n
+}
+
+func TestGetAllFollowsNextPage(t *testing.T) {
+	var queries []string
+	g := testGateway(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries = append(queries, r.URL.RawQuery)
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		if page < 3 {
+			w.Header().Set("X-Next-Page", strconv.Itoa(page+1))
+		}
+		fmt.Fprintf(w, `[{"name":"label%d"}]`, page)
+	}))
+
+	labels, err := getAll[entity.Label](context.Background(), g, "/projects/1/labels?include_ancestor_groups=false")
+	if err != nil {
+		t.Fatalf("getAll: %v", err)
+	}
+	var names []string
+	for _, label := range labels {
+		names = append(names, label.Name)
+	}
+	if !slices.Equal(names, []string{"label1", "label2", "label3"}) {
+		t.Errorf("labels %v, want the ones of pages 1 to 3", names)
+	}
+	want := []string{
+		"include_ancestor_groups=false&per_page=100&page=1",
+		"include_ancestor_groups=false&per_page=100&page=2",
+		"include_ancestor_groups=false&per_page=100&page=3",
+	}
+	if !slices.Equal(queries, want) {
+		t.Errorf("queries %v, want %v", queries, want)
+	}
+}
+
+func TestSnapshotProjectSettings(t *testing.T) {
+	server := &apiServer{routes: map[string]http.HandlerFunc{
+		"GET /projects/1":         reply(http.StatusOK, `{"id":1,"name":"app","description":"An app","topics":["go"]}`),
+		"GET /projects/1/members": reply(http.StatusOK, `[{"id":7,"username":"dev","access_level":30}]`),
+		"GET /projects/1/variables": reply(http.StatusOK, `[
+			{"key":"PLAIN","value":"1","environment_scope":"*"},
+			{"key":"HIDDEN","value":null,"hidden":true,"environment_scope":"*"}
+		]`),
+		"GET /projects/1/protected_branches": reply(http.StatusOK, `[{"name":"main","allow_force_push":false}]`),
+		"GET /projects/1/hooks":              reply(http.StatusOK, `[{"id":5,"url":"https://ci.example.com/hook","push_events":true}]`),
+		"GET /projects/1/labels":             reply(http.StatusForbidden, `{"message":"403 Forbidden"}`),
+	}}
+	g := testGateway(t, server)
+
+	settings, err := g.SnapshotProjectSettings(context.Background(), 1)
+	if err != nil {
+		t.Fatalf("SnapshotProjectSettings: %v", err)
+	}
+	if settings.Description != "An app" || len(settings.Members) != 1 || len(settings.ProtectedBranches) != 1 || len(settings.Hooks) != 1 {
+		t.Errorf("snapshot %+v misses settings", settings)
+	}
+	if len(settings.Variables) != 1 || settings.Variables[0].Key != "PLAIN" {
+		t.Errorf("variables %+v, want only PLAIN", settings.Variables)
+	}
+	want := []entity.SettingFailure{
+		{Setting: "variable", Item: "HIDDEN", Reason: "the value is hidden and cannot be read", Unreadable: true},
+		{Setting: "labels", Reason: "could not be read: gitlab api returned 403 Forbidden for GET /api/v4/projects/1/labels: 403 Forbidden"},
+	}
+	if !slices.Equal(settings.Failures, want) {
+		t.Errorf("failures %+v, want %+v", settings.Failures, want)
+	}
+
+	var notFound *entity.NotFoundError
+	if _, err := g.SnapshotProjectSettings(context.Background(), 2); !errors.As(err, &notFound) {
+		t.Errorf("snapshot of a missing project: error %v is not an entity.NotFoundError", err)
+	}
+}
+
+func TestRestoreProjectSettings(t *testing.T) {
+	var hook map[string]interface{}
+	server := &apiServer{routes: map[string]http.HandlerFunc{
+		"PUT /projects/2": reply(http.StatusOK, `{}`),
+		// The label exists already and the creator is a member already.
+		"POST /projects/2/labels":  reply(http.StatusConflict, `{"message":"Label already exists"}`),
+		"POST /projects/2/members": reply(http.StatusConflict, `{"message":"Member already exists"}`),
+		"POST /projects/2/hooks": func(w http.ResponseWriter, r *http.Request) {
+			json.NewDecoder(r.Body).Decode(&hook)
+			reply(http.StatusCreated, `{}`)(w, r)
+		},
+		"POST /projects/2/protected_branches": reply(http.StatusCreated, `{}`),
+	}}
+	g := testGateway(t, server)
+
+	settings := &entity.ProjectSettings{
+		Description: "An app",
+		Labels:      []entity.Label{{Name: "bug", Color: "#ff0000"}},
+		Members:     []entity.ProjectMember{{ID: 7, Username: "owner", AccessLevel: 50}},
+		Hooks: []entity.ProjectHook{{
+			URL:        "https://ci.example.com/hook",
+			Attributes: map[string]interface{}{"id": 5.0, "url": "https://ci.example.com/hook", "push_events": true},
+		}},
+		ProtectedBranches: []entity.ProtectedBranch{{Name: "main"}},
+	}
+	failures, err := g.RestoreProjectSettings(context.Background(), 2, settings)
+	if err != nil {
+		t.Fatalf("RestoreProjectSettings: %v", err)
+	}
+	want := []entity.SettingFailure{{
+		Setting: "webhook", Item: "https://ci.example.com/hook",
+		Reason:     "created without its secret token, which GitLab does not return; set it again if the hook used one",
+		Unreadable: true,
+	}}
+	if !slices.Equal(failures, want) {
+		t.Errorf("failures %+v, want only the secret of the webhook", failures)
+	}
+	if _, ok := hook["id"]; ok || hook["push_events"] != true {
+		t.Errorf("webhook created with %v, want its settings without the id", hook)
+	}
+}
+
+// A rule that exists already is replaced, as GitLab refuses to create it twice.
+func TestProtectBranchReplacesRule(t *testing.T) {
+	var payloads []map[string]interface{}
+	server := &apiServer{routes: map[string]http.HandlerFunc{
+		"POST /projects/2/protected_branches": func(w http.ResponseWriter, r *http.Request) {
+			var payload map[string]interface{}
+			json.NewDecoder(r.Body).Decode(&payload)
+			payloads = append(payloads, payload)
+			if len(payloads) == 1 {
+				reply(http.StatusConflict, `{"message":"Protected branch 'main' already exists"}`)(w, r)
+				return
+			}
+			reply(http.StatusCreated, `{}`)(w, r)
+		},
+		"DELETE /projects/2/protected_branches/release%2F%2A": reply(http.StatusNoContent, ""),
+	}}
+	g := testGateway(t, server)
+
+	err := g.ProtectBranch(context.Background(), 2, entity.ProtectedBranch{
+		Name:             "release/*",
+		PushAccessLevels: []entity.AccessLevel{{AccessLevel: 40}},
+		AllowForcePush:   true,
+	})
+	if err != nil {
+		t.Fatalf("ProtectBranch: %v", err)
+	}
+	if len(payloads) != 2 || server.count("DELETE /projects/2/protected_branches/release%2F%2A") != 1 {
+		t.Fatalf("requests %v, want a conflict, the removal of the rule and the rule again", server.requests)
+	}
+	if payloads[1]["name"] != "release/*" || payloads[1]["push_access_level"] != 40.0 || payloads[1]["allow_force_push"] != true {
+		t.Errorf("rule created again with %v", payloads[1])
+	}
+}
+
+func TestDownloadAvatarSendsTokenOnlyToGitLab(t *testing.T) {
+	var tokens []string
+	avatar := func(w http.ResponseWriter, r *http.Request) {
+		tokens = append(tokens, r.Header.Get("PRIVATE-TOKEN"))
+		w.Write([]byte("png"))
+	}
+	server := &apiServer{routes: map[string]http.HandlerFunc{
+		// Older GitLab versions have no avatar endpoint and serve the uploads.
+		"GET /projects/1/avatar": reply(http.StatusNotFound, `{"message":"404 Not Found"}`),
+	}}
+	gitLab := http.NewServeMux()
+	gitLab.Handle("/api/", server)
+	gitLab.HandleFunc("/uploads/", avatar)
+	g := testGateway(t, gitLab)
+	cdn := httptest.NewServer(http.HandlerFunc(avatar))
+	t.Cleanup(cdn.Close)
+
+	tests := []struct {
+		avatarURL string
+		wantToken string
+	}{
+		{g.BaseURL + "/uploads/project/avatar/1/logo.png", "token"},
+		{cdn.URL + "/uploads/project/avatar/1/logo.png", ""},
+	}
+	for _, tt := range tests {
+		tokens = nil
+		got, err := g.downloadAvatar(context.Background(), &entity.Project{ID: 1, AvatarURL: tt.avatarURL})
+		if err != nil {
+			t.Fatalf("downloadAvatar from %s: %v", tt.avatarURL, err)
+		}
+		if got.Filename != "logo.png" || string(got.Content) != "png" {
+			t.Errorf("avatar %s with %q, want logo.png with png", got.Filename, got.Content)
+		}
+		if !slices.Equal(tokens, []string{tt.wantToken}) {
+			t.Errorf("download from %s sent tokens %q, want %q", tt.avatarURL, tokens, tt.wantToken)
+		}
+	}
+}
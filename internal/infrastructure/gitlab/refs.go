@@ -0,0 +1,111 @@
+package gitlab
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/olegshirko/reposqueeze/internal/domain/entity"
+)
+
+// ListBranches returns all branches of a project.
+func (g *HTTPGitLabGateway) ListBranches(ctx context.Context, projectID int) ([]entity.Branch, error) {
+	branches, err := getAll[branchResponse](ctx, g, fmt.Sprintf("/projects/%d/repository/branches", projectID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list branches of project %d: %w", projectID, err)
+	}
+
+	result := make([]entity.Branch, 0, len(branches))
+	for _, branch := range branches {
+		result = append(result, entity.Branch{Name: branch.Name, CommitSHA: branch.Commit.ID})
+	}
+	return result, nil
+}
+
+// DeleteBranch deletes a branch. GitLab refuses to delete the default branch and protected branches.
+func (g *HTTPGitLabGateway) DeleteBranch(ctx context.Context, projectID int, branchName string) error {
+	path := fmt.Sprintf("/projects/%d/repository/branches/%s", projectID, url.PathEscape(branchName))
+	if err := g.sendJSON(ctx, "DELETE", path, nil, nil); err != nil {
+		return fmt.Errorf("failed to delete branch %s: %w", branchName, err)
+	}
+	return nil
+}
+
+// ListTags returns all tags of a project.
+func (g *HTTPGitLabGateway) ListTags(ctx context.Context, projectID int) ([]entity.Tag, error) {
+	// Tags are listed in the same shape as branches: a name and the commit.
+	tags, err := getAll[branchResponse](ctx, g, fmt.Sprintf("/projects/%d/repository/tags", projectID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tags of project %d: %w", projectID, err)
+	}
+
+	result := make([]entity.Tag, 0, len(tags))
+	for _, tag := range tags {
+		result = append(result, entity.Tag{Name: tag.Name, CommitSHA: tag.Commit.ID})
+	}
+	return result, nil
+}
+
+// DeleteTag deletes a tag. GitLab refuses to delete protected tags.
+func (g *HTTPGitLabGateway) DeleteTag(ctx context.Context, projectID int, tagName string) error {
+	path := fmt.Sprintf("/projects/%d/repository/tags/%s", projectID, url.PathEscape(tagName))
+	if err := g.sendJSON(ctx, "DELETE", path, nil, nil); err != nil {
+		return fmt.Errorf("failed to delete tag %s: %w", tagName, err)
+	}
+	return nil
+}
+
+// ListProtectedBranches returns the protected branch rules of a project.
+func (g *HTTPGitLabGateway) ListProtectedBranches(ctx context.Context, projectID int) ([]entity.ProtectedBranch, error) {
+	return getAll[entity.ProtectedBranch](ctx, g, fmt.Sprintf("/projects/%d/protected_branches", projectID))
+}
+
+// ProtectBranch creates a protected branch rule. A rule with the same name,
+// e.g. the one GitLab creates for the default branch of a new project, is replaced.
+func (g *HTTPGitLabGateway) ProtectBranch(ctx context.Context, projectID int, branch entity.ProtectedBranch) error {
+	payload := map[string]interface{}{
+		"name":                         branch.Name,
+		"allow_force_push":             branch.AllowForcePush,
+		"code_owner_approval_required": branch.CodeOwnerApprovalRequired,
+	}
+	addAccessLevels(payload, "push", branch.PushAccessLevels)
+	addAccessLevels(payload, "merge", branch.MergeAccessLevels)
+	addAccessLevels(payload, "unprotect", branch.UnprotectAccessLevels)
+
+	path := fmt.Sprintf("/projects/%d/protected_branches", projectID)
+	err := g.sendJSON(ctx, "POST", path, payload, nil)
+	if !isStatus(err, http.StatusConflict) {
+		return err
+	}
+	if err := g.UnprotectBranch(ctx, projectID, branch.Name); err != nil {
+		return err
+	}
+	return g.sendJSON(ctx, "POST", path, payload, nil)
+}
+
+// UnprotectBranch removes a protected branch rule. name is the name of the rule, which may be a wildcard.
+func (g *HTTPGitLabGateway) UnprotectBranch(ctx context.Context, projectID int, name string) error {
+	return g.sendJSON(ctx, "DELETE", fmt.Sprintf("/projects/%d/protected_branches/%s", projectID, url.PathEscape(name)), nil, nil)
+}
+
+// addAccessLevels sets the role of an action as <action>_access_level and the
+// users and groups as allowed_to_<action>, which only GitLab Premium supports.
+func addAccessLevels(payload map[string]interface{}, action string, levels []entity.AccessLevel) {
+	var allowed []map[string]int
+	for _, level := range levels {
+		switch {
+		case level.UserID != nil:
+			allowed = append(allowed, map[string]int{"user_id": *level.UserID})
+		case level.GroupID != nil:
+			allowed = append(allowed, map[string]int{"group_id": *level.GroupID})
+		default:
+			if _, ok := payload[action+"_access_level"]; !ok {
+				payload[action+"_access_level"] = level.AccessLevel
+			}
+		}
+	}
+	if len(allowed) > 0 {
+		payload["allowed_to_"+action] = allowed
+	}
+}
@@ -0,0 +1,60 @@
+package gitlab
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"testing"
+
+	"github.com/olegshirko/reposqueeze/internal/domain/entity"
+)
+
+func TestRefs(t *testing.T) {
+	var deleted []string
+	g := testGateway(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method + " " + r.URL.EscapedPath() {
+		case "GET /api/v4/projects/1/repository/branches":
+			w.Write([]byte(`[{"name":"master","commit":{"id":"a1"}},{"name":"feature/x","commit":{"id":"b2"}}]`))
+		case "GET /api/v4/projects/1/repository/tags":
+			w.Write([]byte(`[{"name":"v1.0.0","commit":{"id":"c3"}}]`))
+		case "DELETE /api/v4/projects/1/repository/branches/feature%2Fx", "DELETE /api/v4/projects/1/repository/tags/v1.0.0":
+			deleted = append(deleted, r.URL.EscapedPath())
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"message":"404 Not Found"}`))
+		}
+	}))
+	ctx := context.Background()
+
+	branches, err := g.ListBranches(ctx, 1)
+	if err != nil {
+		t.Fatalf("ListBranches: %v", err)
+	}
+	if want := []entity.Branch{{Name: "master", CommitSHA: "a1"}, {Name: "feature/x", CommitSHA: "b2"}}; !slices.Equal(branches, want) {
+		t.Errorf("branches %+v, want %+v", branches, want)
+	}
+	tags, err := g.ListTags(ctx, 1)
+	if err != nil {
+		t.Fatalf("ListTags: %v", err)
+	}
+	if want := []entity.Tag{{Name: "v1.0.0", CommitSHA: "c3"}}; !slices.Equal(tags, want) {
+		t.Errorf("tags %+v, want %+v", tags, want)
+	}
+
+	// Names with slashes are sent as a single path segment.
+	if err := g.DeleteBranch(ctx, 1, "feature/x"); err != nil {
+		t.Errorf("DeleteBranch: %v", err)
+	}
+	if err := g.DeleteTag(ctx, 1, "v1.0.0"); err != nil {
+		t.Errorf("DeleteTag: %v", err)
+	}
+	if len(deleted) != 2 {
+		t.Errorf("deleted %v, want the branch and the tag", deleted)
+	}
+	var notFound *entity.NotFoundError
+	if err := g.DeleteTag(ctx, 1, "missing"); !errors.As(err, &notFound) {
+		t.Errorf("DeleteTag of a missing tag: error %v is not an entity.NotFoundError", err)
+	}
+}
@@ -0,0 +1,305 @@
+package gitlab
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/olegshirko/reposqueeze/internal/pkg/logger"
+)
+
+const (
+	// DefaultRequestTimeout is how long one attempt waits for the response headers.
+	DefaultRequestTimeout = 30 * time.Second
+	// DefaultMaxAttempts is how many times a request is sent at most.
+	DefaultMaxAttempts = 5
+	// DefaultMinBackoff is the delay before the first retry.
+	DefaultMinBackoff = 500 * time.Millisecond
+	// DefaultMaxBackoff caps the exponential delay between retries.
+	DefaultMaxBackoff = 30 * time.Second
+	// DefaultMaxRetryWait is the longest wait asked by the server that is honored.
+	DefaultMaxRetryWait = 2 * time.Minute
+	// DefaultBreakerThreshold is how many failures in a row open the circuit breaker.
+	DefaultBreakerThreshold = 5
+	// DefaultBreakerCooldown is how long an open circuit breaker rejects requests.
+	DefaultBreakerCooldown = 30 * time.Second
+)
+
+// ErrCircuitOpen is returned without sending a request while the circuit breaker is open.
+var ErrCircuitOpen = errors.New("gitlab instance keeps failing, requests are suspended")
+
+// errAttemptTimeout cancels an attempt whose response headers did not arrive in time.
+var errAttemptTimeout = errors.New("attempt timed out")
+
+// RetryTransport is an http.RoundTripper shared by all requests of the gateway.
+//
+// A request is retried with exponential backoff and jitter when GitLab is rate
+// limiting (429) or unavailable (503), and, if it is idempotent, also after a
+// network error, a timeout or a 502 or 504 response. On 429 and 503 the wait
+// asked by the Retry-After or RateLimit-Reset headers is honored instead of the
+// backoff. A request with a body is only retried if the body can be replayed.
+//
+// Timeout bounds the wait for the response headers of each attempt; reading
+// the body, e.g. of a large archive, is not limited.
+type RetryTransport struct {
+	Next         http.RoundTripper
+	Timeout      time.Duration // 0 means no timeout
+	MaxAttempts  int
+	MinBackoff   time.Duration
+	MaxBackoff   time.Duration
+	MaxRetryWait time.Duration // A longer wait asked by the server fails the request
+	Breaker      *CircuitBreaker
+
+	logger logger.Logger
+}
+
+// NewRetryTransport creates a RetryTransport with the default settings on top of next.
+func NewRetryTransport(next http.RoundTripper, log logger.Logger) *RetryTransport {
+	return &RetryTransport{
+		Next:         next,
+		Timeout:      DefaultRequestTimeout,
+		MaxAttempts:  DefaultMaxAttempts,
+		MinBackoff:   DefaultMinBackoff,
+		MaxBackoff:   DefaultMaxBackoff,
+		MaxRetryWait: DefaultMaxRetryWait,
+		Breaker:      NewCircuitBreaker(DefaultBreakerThreshold, DefaultBreakerCooldown),
+		logger:       log,
+	}
+}
+
+// RoundTrip sends the request, retrying it as described on RetryTransport.
+func (t *RetryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	replayable := req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+	idempotent := replayable && isIdempotent(req.Method)
+
+	for attempt := 1; ; attempt++ {
+		if err := t.Breaker.allow(); err != nil {
+			return nil, err
+		}
+		if attempt > 1 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+
+		resp, err := t.send(req)
+		if req.Context().Err() != nil {
+			// Cancelled by the caller, which is not a failure of the instance.
+			t.Breaker.release()
+			return resp, err
+		}
+		t.Breaker.record(err != nil || resp.StatusCode >= 500)
+
+		retry := false
+		var reason string
+		if err != nil {
+			retry, reason = idempotent, err.Error()
+		} else {
+			switch resp.StatusCode {
+			case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+				// GitLab has not handled the request, so it is safe to send again.
+				retry, reason = replayable, resp.Status
+			case http.StatusBadGateway, http.StatusGatewayTimeout:
+				retry, reason = idempotent, resp.Status
+			}
+		}
+		if !retry || attempt >= t.MaxAttempts {
+			return resp, err
+		}
+
+		delay := t.backoff(attempt)
+		if resp != nil {
+			if wait, ok := serverWait(resp, time.Now()); ok {
+				if wait > t.MaxRetryWait {
+					t.logger.Warnf("GitLab asks to wait %s before retrying %s %s, giving up", wait.Round(time.Second), req.Method, req.URL.Path)
+					return resp, nil
+				}
+				delay = wait
+			}
+			// The body is dropped, so the connection can be reused.
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+			resp.Body.Close()
+		}
+
+		t.logger.Warnf("GitLab request %s %s failed (%s), retrying in %s (attempt %d of %d)",
+			req.Method, req.URL.Path, reason, delay.Round(time.Millisecond), attempt+1, t.MaxAttempts)
+		if err := sleep(req.Context(), delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// send makes one attempt. The attempt is cancelled if the response headers
+// do not arrive within Timeout.
+func (t *RetryTransport) send(req *http.Request) (*http.Response, error) {
+	if t.Timeout <= 0 {
+		return t.Next.RoundTrip(req)
+	}
+
+	ctx, cancel := context.WithCancelCause(req.Context())
+	timer := time.AfterFunc(t.Timeout, func() { cancel(errAttemptTimeout) })
+	resp, err := t.Next.RoundTrip(req.WithContext(ctx))
+	timer.Stop()
+	if err != nil {
+		if context.Cause(ctx) == errAttemptTimeout {
+			err = fmt.Errorf("no response within %s: %w", t.Timeout, err)
+		}
+		cancel(nil)
+		return nil, err
+	}
+	// The attempt stays alive until the body is closed.
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: func() { cancel(nil) }}
+	return resp, nil
+}
+
+// backoff returns the delay before retry number attempt: an exponential delay
+// capped at MaxBackoff, of which a random half is taken, so that clients do
+// not retry in lockstep.
+func (t *RetryTransport) backoff(attempt int) time.Duration {
+	delay := t.MaxBackoff
+	if shift := attempt - 1; shift < 30 {
+		if d := t.MinBackoff << shift; d > 0 && d < delay {
+			delay = d
+		}
+	}
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// serverWait returns how long a 429 or 503 response asks to wait, from the
+// Retry-After header or GitLab's RateLimit-Reset header.
+func serverWait(resp *http.Response, now time.Time) (time.Duration, bool) {
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+		return 0, false
+	}
+	if value := resp.Header.Get("Retry-After"); value != "" {
+		if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+			return time.Duration(seconds) * time.Second, true
+		}
+		if date, err := http.ParseTime(value); err == nil {
+			return max(date.Sub(now), 0), true
+		}
+	}
+	// RateLimit-Reset is the Unix time at which the rate limit is lifted.
+	if value := resp.Header.Get("RateLimit-Reset"); value != "" {
+		if reset, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return max(time.Unix(reset, 0).Sub(now), 0), true
+		}
+	}
+	if value := resp.Header.Get("RateLimit-ResetTime"); value != "" {
+		if date, err := http.ParseTime(value); err == nil {
+			return max(date.Sub(now), 0), true
+		}
+	}
+	return 0, false
+}
+
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// sleep waits for d or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// cancelOnClose releases the context of an attempt when the body is closed.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// CircuitBreaker stops sending requests to an instance that keeps failing.
+//
+// After Threshold failures in a row (network errors and 5xx responses) the
+// breaker opens and rejects every request with ErrCircuitOpen for Cooldown.
+// Then a single request is let through: if it succeeds the breaker closes,
+// otherwise it opens again.
+type CircuitBreaker struct {
+	Threshold int
+	Cooldown  time.Duration
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	probing   bool // A request is testing the instance after the cooldown
+}
+
+// NewCircuitBreaker creates a closed CircuitBreaker.
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{Threshold: threshold, Cooldown: cooldown}
+}
+
+// allow reports whether a request may be sent. A nil breaker allows everything.
+func (b *CircuitBreaker) allow() error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.Threshold <= 0 || b.failures < b.Threshold {
+		return nil
+	}
+	if wait := time.Until(b.openUntil); wait > 0 {
+		return fmt.Errorf("%w for %s after %d failures", ErrCircuitOpen, wait.Round(time.Second), b.failures)
+	}
+	if b.probing {
+		return fmt.Errorf("%w until the instance answers again", ErrCircuitOpen)
+	}
+	b.probing = true
+	return nil
+}
+
+// release ends a request whose outcome says nothing about the instance.
+func (b *CircuitBreaker) release() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
+
+// record counts the outcome of a request.
+func (b *CircuitBreaker) record(failed bool) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+	if !failed {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.Threshold > 0 && b.failures >= b.Threshold {
+		b.openUntil = time.Now().Add(b.Cooldown)
+	}
+}
@@ -0,0 +1,228 @@
+package gitlab
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/olegshirko/reposqueeze/internal/testutil"
+)
+
+// testTransport returns a RetryTransport with short delays and no breaker.
+func testTransport() *RetryTransport {
+	t := NewRetryTransport(http.DefaultTransport, testutil.Logger())
+	t.MaxAttempts = 3
+	t.MinBackoff = 10 * time.Millisecond
+	t.MaxBackoff = 20 * time.Millisecond
+	t.Breaker = nil
+	return t
+}
+
+// testGateway returns a gateway for the API served by handler, sending its
+// requests through testTransport.
+func testGateway(t *testing.T, handler http.Handler) *HTTPGitLabGateway {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	g := NewHTTPGitLabGateway(server.URL, "v4", "token", testutil.Logger())
+	g.Transport = testTransport()
+	g.Client.Transport = g.Transport
+	return g
+}
+
+// countingServer answers with the status codes returned by respond for the
+// request number n, counted from 1, and counts the requests.
+func countingServer(t *testing.T, respond func(n int, w http.ResponseWriter)) (*httptest.Server, *atomic.Int32) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		respond(int(requests.Add(1)), w)
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func send(t *testing.T, transport http.RoundTripper, method, url string) (*http.Response, error) {
+	t.Helper()
+	var body io.Reader
+	if method == http.MethodPost {
+		body = strings.NewReader(`{"name":"app"}`)
+	}
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := (&http.Client{Transport: transport}).Do(req)
+	if err == nil {
+		t.Cleanup(func() { resp.Body.Close() })
+	}
+	return resp, err
+}
+
+func TestRetryTransportHonorsRetryAfter(t *testing.T) {
+	server, requests := countingServer(t, func(n int, w http.ResponseWriter) {
+		if n == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	transport := testTransport()
+
+	start := time.Now()
+	resp, err := send(t, transport, http.MethodGet, server.URL)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK || requests.Load() != 2 {
+		t.Errorf("status %d after %d requests, want 200 after 2", resp.StatusCode, requests.Load())
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("retried after %s, want the second of Retry-After instead of the backoff", elapsed)
+	}
+}
+
+func TestRetryTransportGivesUpOnLongRetryAfter(t *testing.T) {
+	server, requests := countingServer(t, func(n int, w http.ResponseWriter) {
+		w.Header().Set("Retry-After", "600")
+		w.WriteHeader(http.StatusTooManyRequests)
+	})
+	transport := testTransport()
+	transport.MaxRetryWait = time.Minute
+
+	resp, err := send(t, transport, http.MethodGet, server.URL)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	if resp.StatusCode != http.StatusTooManyRequests || requests.Load() != 1 {
+		t.Errorf("status %d after %d requests, want 429 after 1", resp.StatusCode, requests.Load())
+	}
+}
+
+func TestRetryTransportRetries(t *testing.T) {
+	tests := []struct {
+		name         string
+		method       string
+		status       int
+		wantRequests int32
+	}{
+		{"503 until the attempt limit", http.MethodGet, http.StatusServiceUnavailable, 3},
+		{"502 of an idempotent request", http.MethodGet, http.StatusBadGateway, 3},
+		{"503 of a replayable POST", http.MethodPost, http.StatusServiceUnavailable, 3},
+		{"502 of a POST", http.MethodPost, http.StatusBadGateway, 1},
+		{"500", http.MethodGet, http.StatusInternalServerError, 1},
+		{"404", http.MethodGet, http.StatusNotFound, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, requests := countingServer(t, func(n int, w http.ResponseWriter) {
+				w.WriteHeader(tt.status)
+			})
+
+			resp, err := send(t, testTransport(), tt.method, server.URL)
+			if err != nil {
+				t.Fatalf("request failed: %v", err)
+			}
+			if resp.StatusCode != tt.status || requests.Load() != tt.wantRequests {
+				t.Errorf("status %d after %d requests, want %d after %d", resp.StatusCode, requests.Load(), tt.status, tt.wantRequests)
+			}
+		})
+	}
+}
+
+func TestRetryTransportRetriesAfterTimeout(t *testing.T) {
+	server, requests := countingServer(t, func(n int, w http.ResponseWriter) {
+		if n == 1 {
+			time.Sleep(500 * time.Millisecond)
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	transport := testTransport()
+	transport.Timeout = 100 * time.Millisecond
+
+	resp, err := send(t, transport, http.MethodGet, server.URL)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK || requests.Load() != 2 {
+		t.Errorf("status %d after %d requests, want 200 after 2", resp.StatusCode, requests.Load())
+	}
+
+	// A POST that timed out may have been handled, so it is not sent again.
+	requests.Store(0)
+	if _, err := send(t, transport, http.MethodPost, server.URL); err == nil || !strings.Contains(err.Error(), "no response within") {
+		t.Errorf("POST error = %v, want a timeout", err)
+	}
+	if requests.Load() != 1 {
+		t.Errorf("POST was sent %d times, want 1", requests.Load())
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	var failing atomic.Bool
+	failing.Store(true)
+	server, requests := countingServer(t, func(n int, w http.ResponseWriter) {
+		if failing.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	transport := testTransport()
+	transport.Breaker = NewCircuitBreaker(2, 100*time.Millisecond)
+
+	for range 2 {
+		if _, err := send(t, transport, http.MethodGet, server.URL); err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+	}
+	// Open: the request is rejected without reaching the server.
+	if _, err := send(t, transport, http.MethodGet, server.URL); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("error of an open breaker = %v, want ErrCircuitOpen", err)
+	}
+	if requests.Load() != 2 {
+		t.Errorf("%d requests reached the server, want 2", requests.Load())
+	}
+
+	// Half-open after the cooldown: a failing probe opens the breaker again.
+	time.Sleep(150 * time.Millisecond)
+	if _, err := send(t, transport, http.MethodGet, server.URL); err != nil {
+		t.Fatalf("probe failed: %v", err)
+	}
+	if _, err := send(t, transport, http.MethodGet, server.URL); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("error after a failed probe = %v, want ErrCircuitOpen", err)
+	}
+
+	// A successful probe closes it.
+	time.Sleep(150 * time.Millisecond)
+	failing.Store(false)
+	for i := range 2 {
+		resp, err := send(t, transport, http.MethodGet, server.URL)
+		if err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("request %d after the cooldown: %v", i+1, err)
+		}
+	}
+	if requests.Load() != 5 {
+		t.Errorf("%d requests reached the server, want 5", requests.Load())
+	}
+}
+
+func TestCircuitBreakerProbesOnce(t *testing.T) {
+	b := NewCircuitBreaker(1, 0)
+	b.record(true)
+	if err := b.allow(); err != nil {
+		t.Fatalf("first request after the cooldown: %v", err)
+	}
+	// A second request waits for the outcome of the probe.
+	if err := b.allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("request during the probe: error %v, want ErrCircuitOpen", err)
+	}
+	b.record(false)
+	if err := b.allow(); err != nil {
+		t.Errorf("request after a successful probe: %v", err)
+	}
+}
@@ -0,0 +1,218 @@
+// Package ignore matches file paths against gitignore-style patterns.
+//
+// Supported syntax: blank lines and lines starting with "#" are skipped, "!"
+// negates a pattern, a trailing "/" matches directories only, a pattern with
+// a "/" elsewhere is anchored to the repository root, "*", "?" and "[...]"
+// match within one path segment and "**" matches any number of segments.
+//
+// Unlike git, a negated pattern can re-include a file inside an excluded
+// directory, because only files are ever matched.
+package ignore
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// FileName is the name of the ignore file in the repository root.
+const FileName = ".squeezeignore"
+
+type rule struct {
+	pattern string
+	negate  bool
+	dirOnly bool
+	re      *regexp.Regexp
+}
+
+// Matcher decides which files are excluded. The last matching rule wins.
+type Matcher struct {
+	rules []rule
+}
+
+// New creates an empty Matcher that excludes nothing.
+func New() *Matcher {
+	return &Matcher{}
+}
+
+// Add adds a gitignore-style pattern.
+func (m *Matcher) Add(pattern string) error {
+	pattern = strings.TrimRight(pattern, " \t\r")
+	if pattern == "" || strings.HasPrefix(pattern, "#") {
+		return nil
+	}
+
+	r := rule{pattern: pattern}
+	if strings.HasPrefix(pattern, "!") {
+		r.negate = true
+		pattern = pattern[1:]
+	} else if strings.HasPrefix(pattern, `\!`) || strings.HasPrefix(pattern, `\#`) {
+		pattern = pattern[1:]
+	}
+	if strings.HasSuffix(pattern, "/") {
+		r.dirOnly = true
+		pattern = strings.TrimRight(pattern, "/")
+	}
+	if pattern == "" {
+		return fmt.Errorf("invalid pattern %q", r.pattern)
+	}
+
+	re, err := compile(pattern)
+	if err != nil {
+		return fmt.Errorf("invalid pattern %q: %w", r.pattern, err)
+	}
+	r.re = re
+	m.rules = append(m.rules, r)
+	return nil
+}
+
+// AddExclude adds a pattern that excludes matching files.
+func (m *Matcher) AddExclude(pattern string) error {
+	return m.Add(pattern)
+}
+
+// AddInclude adds a pattern that re-includes matching files.
+func (m *Matcher) AddInclude(pattern string) error {
+	return m.Add("!" + pattern)
+}
+
+// Read adds the patterns of an ignore file, one per line.
+func (m *Matcher) Read(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if err := m.Add(scanner.Text()); err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// Empty reports whether the matcher has no rules and so excludes nothing.
+func (m *Matcher) Empty() bool {
+	return len(m.rules) == 0
+}
+
+// Excluded reports whether a file, given by its slash-separated path relative
+// to the repository root, is excluded.
+func (m *Matcher) Excluded(file string) bool {
+	file = strings.TrimPrefix(file, "/")
+	excluded := false
+	for _, r := range m.rules {
+		if r.matches(file) {
+			excluded = !r.negate
+		}
+	}
+	return excluded
+}
+
+// Filter splits files into the kept and the excluded ones.
+func (m *Matcher) Filter(files []string) (kept, excluded []string) {
+	for _, file := range files {
+		if m.Excluded(file) {
+			excluded = append(excluded, file)
+		} else {
+			kept = append(kept, file)
+		}
+	}
+	return kept, excluded
+}
+
+// matches reports whether the rule matches the file itself or one of its parent directories.
+func (r rule) matches(file string) bool {
+	if !r.dirOnly && r.re.MatchString(file) {
+		return true
+	}
+	for i := 0; i < len(file); i++ {
+		if file[i] == '/' && r.re.MatchString(file[:i]) {
+			return true
+		}
+	}
+	return false
+}
+
+// compile translates a pattern into a regular expression matching whole paths.
+func compile(pattern string) (*regexp.Regexp, error) {
+	anchored := strings.Contains(pattern, "/")
+	pattern = strings.TrimPrefix(pattern, "/")
+
+	var b strings.Builder
+	b.WriteString("^")
+	if !anchored {
+		// A pattern without a slash matches at any depth.
+		b.WriteString("(?:.*/)?")
+	}
+
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch {
+		case strings.HasPrefix(pattern[i:], "**/"):
+			b.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(pattern[i:], "**") && i+2 == len(pattern):
+			b.WriteString(".*")
+			i++
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		case c == '[':
+			end := classEnd(pattern, i)
+			if end < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := pattern[i+1 : end]
+			negated := strings.HasPrefix(class, "!") || strings.HasPrefix(class, "^")
+			if negated {
+				class = class[1:]
+			}
+			class = strings.ReplaceAll(class, `\`, `\\`)
+			if strings.HasPrefix(class, "]") {
+				class = `\` + class
+			}
+			if negated {
+				// Like "*", a negated class stays within one segment.
+				class = "^/" + class
+			}
+			b.WriteString("[" + class + "]")
+			i = end
+		case c == '\\' && i+1 < len(pattern):
+			i++
+			b.WriteString(regexp.QuoteMeta(string(pattern[i])))
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+
+	return regexp.Compile(b.String())
+}
+
+// classEnd returns the index of the "]" closing the character class opened at
+// pattern[start], or -1 if the class is not closed. A "]" right after the
+// opening bracket or its negation is a member of the class, and the "]" of a
+// "[:name:]" class name does not close it.
+func classEnd(pattern string, start int) int {
+	i := start + 1
+	if i < len(pattern) && (pattern[i] == '!' || pattern[i] == '^') {
+		i++
+	}
+	if i < len(pattern) && pattern[i] == ']' {
+		i++
+	}
+	for ; i < len(pattern); i++ {
+		if pattern[i] == ']' {
+			return i
+		}
+		if strings.HasPrefix(pattern[i:], "[:") {
+			if end := strings.Index(pattern[i+2:], ":]"); end >= 0 {
+				i += 2 + end + 1
+			}
+		}
+	}
+	return -1
+}
@@ -0,0 +1,206 @@
+package ignore
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestExcluded(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns []string
+		excluded []string
+		kept     []string
+	}{
+		{
+			name:     "directory",
+			patterns: []string{"vendor/"},
+			excluded: []string{"vendor/a.go", "vendor/x/y.go", "lib/vendor/b.go"},
+			kept:     []string{"vendor", "vendors/a.go", "lib/vendor.go"},
+		},
+		{
+			name:     "anchored to the root",
+			patterns: []string{"/root.txt"},
+			excluded: []string{"root.txt", "/root.txt"},
+			kept:     []string{"dir/root.txt", "rootXtxt", "root.txt.bak"},
+		},
+		{
+			name:     "slash in the middle anchors",
+			patterns: []string{"a/*.log"},
+			excluded: []string{"a/x.log"},
+			kept:     []string{"a/b/x.log", "b/a/x.log"},
+		},
+		{
+			name:     "any directories in the middle",
+			patterns: []string{"a/**/b"},
+			excluded: []string{"a/b", "a/x/b", "a/x/y/b", "a/b/c.txt"},
+			kept:     []string{"x/a/b", "a/xb", "a/x/bc"},
+		},
+		{
+			name:     "any leading directories",
+			patterns: []string{"**/x"},
+			excluded: []string{"x", "a/x", "a/b/x", "x/y.txt"},
+			kept:     []string{"ax", "a/xy"},
+		},
+		{
+			name:     "everything inside",
+			patterns: []string{"build/**"},
+			excluded: []string{"build/a", "build/a/b.o"},
+			kept:     []string{"build", "src/build/a"},
+		},
+		{
+			name:     "extension with an exception",
+			patterns: []string{"*.log", "!keep.log"},
+			excluded: []string{"a.log", "dir/b.log"},
+			kept:     []string{"keep.log", "dir/keep.log", "log", "a.log.txt"},
+		},
+		{
+			name:     "the last matching pattern wins",
+			patterns: []string{"!keep.log", "*.log"},
+			excluded: []string{"keep.log", "a.log"},
+		},
+		{
+			name:     "question mark",
+			patterns: []string{"file?.txt"},
+			excluded: []string{"file1.txt", "dir/fileA.txt"},
+			kept:     []string{"file.txt", "file10.txt", "file/.txt"},
+		},
+		{
+			name:     "character class",
+			patterns: []string{"[ab].txt"},
+			excluded: []string{"a.txt", "b.txt"},
+			kept:     []string{"c.txt", "ab.txt"},
+		},
+		{
+			name:     "negated character class",
+			patterns: []string{"[!a]"},
+			excluded: []string{"b", "dir/c"},
+			kept:     []string{"a", "dir/a", "bb"},
+		},
+		{
+			name:     "negated character class does not match a slash",
+			patterns: []string{"a[!b]c", "x[^y]z"},
+			excluded: []string{"acc", "xzz"},
+			kept:     []string{"abc", "a/c", "x/z"},
+		},
+		{
+			name:     "named character class",
+			patterns: []string{"[[:alpha:]]*"},
+			excluded: []string{"a", "abc1", "dir/Xy"},
+			kept:     []string{"1abc", "_a", "1dir/2"},
+		},
+		{
+			name:     "leading bracket is a member of the class",
+			patterns: []string{"[]a]", "x[!]]"},
+			excluded: []string{"]", "a", "dir/]", "xy"},
+			kept:     []string{"b", "]a", "x]", "x/"},
+		},
+		{
+			name:     "unterminated character class is literal",
+			patterns: []string{"a[b"},
+			excluded: []string{"a[b"},
+			kept:     []string{"ab"},
+		},
+		{
+			name:     "escaped hash",
+			patterns: []string{`\#file`},
+			excluded: []string{"#file", "dir/#file"},
+			kept:     []string{"file"},
+		},
+		{
+			name:     "escaped exclamation mark",
+			patterns: []string{`\!important`},
+			excluded: []string{"!important"},
+			kept:     []string{"important"},
+		},
+		{
+			name:     "escaped wildcard",
+			patterns: []string{`\*.txt`},
+			excluded: []string{"*.txt"},
+			kept:     []string{"a.txt"},
+		},
+		{
+			name:     "comments, blank lines and trailing spaces",
+			patterns: []string{"# *.txt", "", "   ", "*.tmp  "},
+			excluded: []string{"a.tmp"},
+			kept:     []string{"a.txt", "# *.txt"},
+		},
+		{
+			// git cannot re-include a file inside an excluded directory; the
+			// matcher only matches files, so it can.
+			name:     "negation inside an excluded directory",
+			patterns: []string{"build/", "!build/keep.txt"},
+			excluded: []string{"build/a.o", "build/sub/keep.txt"},
+			kept:     []string{"build/keep.txt"},
+		},
+		{
+			name:     "negation of a file pattern in an excluded directory",
+			patterns: []string{"docs/**/*.png", "!docs/logo.png"},
+			excluded: []string{"docs/a.png", "docs/img/b.png"},
+			kept:     []string{"docs/logo.png", "docs/readme.md", "img/c.png"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := New()
+			for _, pattern := range tt.patterns {
+				if err := m.Add(pattern); err != nil {
+					t.Fatalf("Add(%q): %v", pattern, err)
+				}
+			}
+			for _, file := range tt.excluded {
+				if !m.Excluded(file) {
+					t.Errorf("%s is kept, want it excluded", file)
+				}
+			}
+			for _, file := range tt.kept {
+				if m.Excluded(file) {
+					t.Errorf("%s is excluded, want it kept", file)
+				}
+			}
+		})
+	}
+}
+
+func TestAddInvalid(t *testing.T) {
+	for _, pattern := range []string{"!", "/", "!/", "[z-a]"} {
+		if err := New().Add(pattern); err == nil {
+			t.Errorf("Add(%q) succeeded, want an error", pattern)
+		}
+	}
+}
+
+func TestRead(t *testing.T) {
+	m := New()
+	if err := m.Read(strings.NewReader("# build output\n/build\n\n*.log\n!keep.log\n")); err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	kept, excluded := m.Filter([]string{"build/a.o", "src/build/b.go", "a.log", "keep.log", "main.go"})
+	if want := []string{"src/build/b.go", "keep.log", "main.go"}; !slices.Equal(kept, want) {
+		t.Errorf("kept %v, want %v", kept, want)
+	}
+	if want := []string{"build/a.o", "a.log"}; !slices.Equal(excluded, want) {
+		t.Errorf("excluded %v, want %v", excluded, want)
+	}
+
+	err := New().Read(strings.NewReader("*.log\n\n[z-a]\n"))
+	if err == nil || !strings.Contains(err.Error(), "line 3") {
+		t.Errorf("Read error = %v, want one on line 3", err)
+	}
+}
+
+func TestEmpty(t *testing.T) {
+	m := New()
+	if err := m.Add("# only a comment"); err != nil {
+		t.Fatal(err)
+	}
+	if !m.Empty() || m.Excluded("a.txt") {
+		t.Error("a matcher without patterns excludes files")
+	}
+	m.AddExclude("*.txt")
+	m.AddInclude("keep.txt")
+	if m.Empty() || !m.Excluded("a.txt") || m.Excluded("keep.txt") {
+		t.Error("AddExclude and AddInclude are not applied in order")
+	}
+}
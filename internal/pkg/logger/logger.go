@@ -18,6 +18,8 @@ type Logger interface {
 	Fatalf(format string, args ...interface{})
 	Debug(args ...interface{})
 	Debugf(format string, args ...interface{})
+	// SetOutput redirects the log, e.g. to keep stdout for a result document.
+	SetOutput(writer io.Writer)
 }
 
 type logrusLogger struct {
@@ -64,6 +66,10 @@ func (l *logrusLogger) Debugf(format string, args ...interface{}) {
 	l.logger.Debugf(format, args...)
 }
 
+func (l *logrusLogger) SetOutput(writer io.Writer) {
+	l.logger.SetOutput(writer)
+}
+
 func NewLogger() Logger {
 	log := logrus.New()
 	log.SetOutput(os.Stdout)
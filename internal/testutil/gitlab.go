@@ -0,0 +1,308 @@
+package testutil
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/olegshirko/reposqueeze/internal/domain/entity"
+	"github.com/olegshirko/reposqueeze/internal/domain/gateway"
+)
+
+// FakeGitLab is an in-memory GitLabGateway. Branches are chains of commits,
+// made through CommitFilesViaAPI or SetBranch. Every call that changes GitLab
+// is recorded in Calls, and fails instead once its context is done.
+type FakeGitLab struct {
+	Projects   map[int]*entity.Project
+	Namespaces map[string]*entity.Namespace // by full path
+	Tags       map[int][]entity.Tag
+	Protected  map[int][]entity.ProtectedBranch
+	NextID     int // ID of the last created project
+
+	Calls []string
+	// Fail, if set, is called with each call before it is made. If it
+	// returns an error, the call fails with it and changes nothing.
+	Fail func(call string) error
+
+	// CommitErr, if set, is called before each commit with the number of the
+	// call, counted from 1. If it returns an error, the commit is made only
+	// if land is true, and the error is returned either way.
+	CommitErr   func(call int) (land bool, err error)
+	CommitCalls int
+
+	branches map[int]map[string][]entity.Commit // commits of each branch, oldest first
+}
+
+// NewFakeGitLab returns a FakeGitLab without projects. Created projects get IDs from 101.
+func NewFakeGitLab() *FakeGitLab {
+	return &FakeGitLab{
+		Projects:   map[int]*entity.Project{},
+		Namespaces: map[string]*entity.Namespace{},
+		Tags:       map[int][]entity.Tag{},
+		Protected:  map[int][]entity.ProtectedBranch{},
+		NextID:     100,
+		branches:   map[int]map[string][]entity.Commit{},
+	}
+}
+
+// AddProject adds a project in namespace group with a path equal to its name.
+// The returned project may be changed to set other fields.
+func (g *FakeGitLab) AddProject(id int, name string) *entity.Project {
+	project := &entity.Project{ID: id, Name: name, Path: name, PathWithNamespace: "group/" + name}
+	g.Projects[id] = project
+	return project
+}
+
+// SetBranch moves a branch of a project to a new commit, creating the branch if needed.
+func (g *FakeGitLab) SetBranch(projectID int, branch, sha string) {
+	g.addCommit(projectID, branch, entity.Commit{SHA: sha})
+}
+
+// BranchCommits returns the commits of a branch, oldest first.
+func (g *FakeGitLab) BranchCommits(projectID int, branch string) []entity.Commit {
+	return g.branches[projectID][branch]
+}
+
+// Called reports whether a recorded call starts with prefix.
+func (g *FakeGitLab) Called(prefix string) bool {
+	return slices.ContainsFunc(g.Calls, func(call string) bool { return strings.HasPrefix(call, prefix) })
+}
+
+// call records a call that changes GitLab, unless ctx is done or Fail rejects it.
+func (g *FakeGitLab) call(ctx context.Context, format string, args ...interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	call := fmt.Sprintf(format, args...)
+	if g.Fail != nil {
+		if err := g.Fail(call); err != nil {
+			return err
+		}
+	}
+	g.Calls = append(g.Calls, call)
+	return nil
+}
+
+func (g *FakeGitLab) addCommit(projectID int, branch string, commit entity.Commit) {
+	if g.branches[projectID] == nil {
+		g.branches[projectID] = map[string][]entity.Commit{}
+	}
+	g.branches[projectID][branch] = append(g.branches[projectID][branch], commit)
+}
+
+// project returns the project of an ID or a NotFoundError.
+func (g *FakeGitLab) project(projectID int) (*entity.Project, error) {
+	project := g.Projects[projectID]
+	if project == nil {
+		return nil, entity.NotFound("project %d", projectID)
+	}
+	return project, nil
+}
+
+// copyProject returns a copy, so renaming a project does not change what callers hold.
+func copyProject(project *entity.Project) *entity.Project {
+	if project == nil {
+		return nil
+	}
+	found := *project
+	return &found
+}
+
+func (g *FakeGitLab) CommitFilesViaAPI(ctx context.Context, projectID, branchName, commitMessage string, actions []gateway.CommitAction) (*entity.Commit, error) {
+	id, err := strconv.Atoi(projectID)
+	if err != nil {
+		return nil, err
+	}
+	if err := g.call(ctx, "commit %d files to branch %s of project %d", len(actions), branchName, id); err != nil {
+		return nil, err
+	}
+	g.CommitCalls++
+	commit := entity.Commit{SHA: "sha" + strconv.Itoa(g.CommitCalls), Message: commitMessage}
+	if g.CommitErr != nil {
+		if land, err := g.CommitErr(g.CommitCalls); err != nil {
+			if land {
+				g.addCommit(id, branchName, commit)
+			}
+			return nil, err
+		}
+	}
+	g.addCommit(id, branchName, commit)
+	return &commit, nil
+}
+
+func (g *FakeGitLab) CreateRemoteBranch(ctx context.Context, projectID, branchName, refSHA string) error {
+	id, err := strconv.Atoi(projectID)
+	if err != nil {
+		return err
+	}
+	if err := g.call(ctx, "create branch %s of project %d", branchName, id); err != nil {
+		return err
+	}
+	g.SetBranch(id, branchName, refSHA)
+	return nil
+}
+
+func (g *FakeGitLab) FindProjectByName(ctx context.Context, name string) (*entity.Project, error) {
+	for _, id := range slices.Sorted(maps.Keys(g.Projects)) {
+		if g.Projects[id].Name == name {
+			return copyProject(g.Projects[id]), nil
+		}
+	}
+	return nil, nil
+}
+
+func (g *FakeGitLab) GetProject(ctx context.Context, projectID int) (*entity.Project, error) {
+	return copyProject(g.Projects[projectID]), nil
+}
+
+func (g *FakeGitLab) GetProjectByPath(ctx context.Context, fullPath string) (*entity.Project, error) {
+	for _, project := range g.Projects {
+		if project.PathWithNamespace == strings.Trim(fullPath, "/") {
+			return copyProject(project), nil
+		}
+	}
+	return nil, nil
+}
+
+func (g *FakeGitLab) GetNamespace(ctx context.Context, fullPath string) (*entity.Namespace, error) {
+	return g.Namespaces[strings.Trim(fullPath, "/")], nil
+}
+
+func (g *FakeGitLab) DeleteProject(ctx context.Context, projectID int) error {
+	if err := g.call(ctx, "delete project %d", projectID); err != nil {
+		return err
+	}
+	if _, err := g.project(projectID); err != nil {
+		return err
+	}
+	delete(g.Projects, projectID)
+	return nil
+}
+
+func (g *FakeGitLab) CreateProject(ctx context.Context, options gateway.CreateProjectOptions) (*entity.Project, error) {
+	if err := g.call(ctx, "create project %s", options.Name); err != nil {
+		return nil, err
+	}
+	path := options.Path
+	if path == "" {
+		path = options.Name
+	}
+	for _, project := range g.Projects {
+		if project.Path == path || project.Name == options.Name {
+			return nil, entity.NewAPIError(&entity.APIError{StatusCode: 400, Message: "name has already been taken"})
+		}
+	}
+	g.NextID++
+	project := g.AddProject(g.NextID, options.Name)
+	project.Path, project.PathWithNamespace = path, "group/"+path
+	project.DefaultBranch = options.DefaultBranch
+	return copyProject(project), nil
+}
+
+func (g *FakeGitLab) RenameProject(ctx context.Context, projectID int, name, path string) (*entity.Project, error) {
+	if err := g.call(ctx, "rename project %d to %s", projectID, name); err != nil {
+		return nil, err
+	}
+	project, err := g.project(projectID)
+	if err != nil {
+		return nil, err
+	}
+	project.Name, project.Path, project.PathWithNamespace = name, path, "group/"+path
+	return copyProject(project), nil
+}
+
+func (g *FakeGitLab) SnapshotProjectSettings(ctx context.Context, projectID int) (*entity.ProjectSettings, error) {
+	return &entity.ProjectSettings{}, nil
+}
+
+func (g *FakeGitLab) RestoreProjectSettings(ctx context.Context, projectID int, settings *entity.ProjectSettings) ([]entity.SettingFailure, error) {
+	return nil, g.call(ctx, "restore settings of project %d", projectID)
+}
+
+func (g *FakeGitLab) GetBranch(ctx context.Context, projectID, branchName string) (*entity.Branch, error) {
+	id, err := strconv.Atoi(projectID)
+	if err != nil {
+		return nil, err
+	}
+	commits := g.BranchCommits(id, branchName)
+	if len(commits) == 0 {
+		return nil, nil
+	}
+	head := commits[len(commits)-1]
+	return &entity.Branch{Name: branchName, CommitSHA: head.SHA, CommitMessage: head.Message}, nil
+}
+
+func (g *FakeGitLab) ListBranches(ctx context.Context, projectID int) ([]entity.Branch, error) {
+	var branches []entity.Branch
+	for _, name := range slices.Sorted(maps.Keys(g.branches[projectID])) {
+		branch, _ := g.GetBranch(ctx, strconv.Itoa(projectID), name)
+		branches = append(branches, *branch)
+	}
+	return branches, nil
+}
+
+func (g *FakeGitLab) DeleteBranch(ctx context.Context, projectID int, branchName string) error {
+	if err := g.call(ctx, "delete branch %s of project %d", branchName, projectID); err != nil {
+		return err
+	}
+	if _, ok := g.branches[projectID][branchName]; !ok {
+		return entity.NotFound("branch %s", branchName)
+	}
+	delete(g.branches[projectID], branchName)
+	return nil
+}
+
+func (g *FakeGitLab) ListTags(ctx context.Context, projectID int) ([]entity.Tag, error) {
+	return slices.Clone(g.Tags[projectID]), nil
+}
+
+func (g *FakeGitLab) DeleteTag(ctx context.Context, projectID int, tagName string) error {
+	if err := g.call(ctx, "delete tag %s of project %d", tagName, projectID); err != nil {
+		return err
+	}
+	tags := g.Tags[projectID]
+	i := slices.IndexFunc(tags, func(tag entity.Tag) bool { return tag.Name == tagName })
+	if i < 0 {
+		return entity.NotFound("tag %s", tagName)
+	}
+	g.Tags[projectID] = slices.Delete(tags, i, i+1)
+	return nil
+}
+
+func (g *FakeGitLab) ListProtectedBranches(ctx context.Context, projectID int) ([]entity.ProtectedBranch, error) {
+	return slices.Clone(g.Protected[projectID]), nil
+}
+
+func (g *FakeGitLab) ProtectBranch(ctx context.Context, projectID int, branch entity.ProtectedBranch) error {
+	if err := g.call(ctx, "protect branch %s of project %d", branch.Name, projectID); err != nil {
+		return err
+	}
+	rules := slices.DeleteFunc(g.Protected[projectID], func(rule entity.ProtectedBranch) bool { return rule.Name == branch.Name })
+	g.Protected[projectID] = append(rules, branch)
+	return nil
+}
+
+func (g *FakeGitLab) UnprotectBranch(ctx context.Context, projectID int, name string) error {
+	if err := g.call(ctx, "unprotect branch %s of project %d", name, projectID); err != nil {
+		return err
+	}
+	rules := g.Protected[projectID]
+	i := slices.IndexFunc(rules, func(rule entity.ProtectedBranch) bool { return rule.Name == name })
+	if i < 0 {
+		return entity.NotFound("protected branch %s", name)
+	}
+	g.Protected[projectID] = slices.Delete(rules, i, i+1)
+	return nil
+}
+
+func (g *FakeGitLab) DownloadRepoArchive(ctx context.Context, projectID int, options gateway.ArchiveOptions, writer io.Writer) error {
+	return fmt.Errorf("no archive of project %d", projectID)
+}
+
+func (g *FakeGitLab) GetVersion(ctx context.Context) (*entity.GitLabVersion, error) {
+	return &entity.GitLabVersion{Version: "17.0.0"}, nil
+}
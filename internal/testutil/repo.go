@@ -0,0 +1,86 @@
+package testutil
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Repo is a git repository in a temporary directory.
+type Repo struct {
+	t    testing.TB
+	Path string
+}
+
+// NewRepo creates an empty repository on branch master with a local identity,
+// so the tests do not depend on the git configuration of the user. The
+// directory is named app, which is the project the use cases look up for it.
+// The test is skipped if git is not installed.
+func NewRepo(t testing.TB) *Repo {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	r := &Repo{t: t, Path: filepath.Join(t.TempDir(), "app")}
+	if err := os.Mkdir(r.Path, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	r.Git("init", "--quiet", "--initial-branch=master")
+	r.Git("config", "user.name", "Test User")
+	r.Git("config", "user.email", "test@example.com")
+	r.Git("config", "commit.gpgsign", "false")
+	return r
+}
+
+// Git runs git in the repository and returns its trimmed output.
+func (r *Repo) Git(args ...string) string {
+	r.t.Helper()
+	cmd := exec.Command("git", append([]string{"-C", r.Path}, args...)...)
+	cmd.Env = append(os.Environ(), "GIT_CONFIG_GLOBAL=/dev/null", "GIT_CONFIG_NOSYSTEM=1")
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		r.t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, output)
+	}
+	return strings.TrimSpace(string(output))
+}
+
+// Write writes files into the working tree.
+func (r *Repo) Write(files map[string]string) {
+	r.t.Helper()
+	for name, content := range files {
+		path := filepath.Join(r.Path, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			r.t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			r.t.Fatal(err)
+		}
+	}
+}
+
+// Commit writes files, commits all changes and returns the SHA of the commit.
+func (r *Repo) Commit(message string, files map[string]string) string {
+	r.t.Helper()
+	r.Write(files)
+	r.Git("add", "--all")
+	r.Git("commit", "--quiet", "--message", message)
+	return r.Git("rev-parse", "HEAD")
+}
+
+// Files lists the files of the last commit of a branch.
+func (r *Repo) Files(branch string) []string {
+	r.t.Helper()
+	output := r.Git("ls-tree", "-r", "--name-only", branch)
+	if output == "" {
+		return nil
+	}
+	return strings.Split(output, "\n")
+}
+
+// Branches lists the local branches.
+func (r *Repo) Branches() []string {
+	r.t.Helper()
+	return strings.Fields(r.Git("for-each-ref", "--format=%(refname:short)", "refs/heads"))
+}
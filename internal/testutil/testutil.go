@@ -0,0 +1,15 @@
+// Package testutil holds the fixtures shared by the tests of the other
+// packages: a logger, temporary git repositories and an in-memory GitLab.
+// It is imported by tests only.
+package testutil
+
+import (
+	"io"
+
+	"github.com/olegshirko/reposqueeze/internal/pkg/logger"
+)
+
+// Logger returns a logger that discards the log of the code under test.
+func Logger() logger.Logger {
+	return logger.NewLoggerWithWriter(io.Discard)
+}
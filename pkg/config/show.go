@@ -0,0 +1,90 @@
+package config
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// redacted replaces secrets in the output of Show.
+const redacted = "<redacted>"
+
+// effectiveConfig is the printable form of Config.
+type effectiveConfig struct {
+	Profile           string   `yaml:"profile" json:"profile"`
+	Files             []string `yaml:"files,omitempty" json:"files,omitempty"`
+	GitLabURL         string   `yaml:"gitlab_url" json:"gitlab_url"`
+	GitLabAPIVersion  string   `yaml:"gitlab_api_version" json:"gitlab_api_version"`
+	Token             string   `yaml:"token,omitempty" json:"token,omitempty"`
+	TokenSource       string   `yaml:"token_source" json:"token_source"`
+	TokenEnv          string   `yaml:"token_env,omitempty" json:"token_env,omitempty"`
+	TokenCommand      string   `yaml:"token_command,omitempty" json:"token_command,omitempty"`
+	Namespace         string   `yaml:"namespace,omitempty" json:"namespace,omitempty"`
+	Excludes          []string `yaml:"excludes,omitempty" json:"excludes,omitempty"`
+	Transport         string   `yaml:"transport,omitempty" json:"transport,omitempty"`
+	GitBackend        string   `yaml:"git_backend" json:"git_backend"`
+	DeletionTimeout   string   `yaml:"deletion_timeout" json:"deletion_timeout"`
+	PermanentlyRemove bool     `yaml:"permanently_remove" json:"permanently_remove"`
+	HTTPTimeout       string   `yaml:"http_timeout" json:"http_timeout"`
+	HTTPRetries       int      `yaml:"http_retries" json:"http_retries"`
+}
+
+// Show writes the settings to w as YAML with the token redacted. A token read
+// from an environment variable or a command is not read, only its source is shown.
+func (c *Config) Show(w io.Writer) error {
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(c.effective()); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
+
+// ShowJSON writes the settings to w as JSON, like Show.
+func (c *Config) ShowJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.SetEscapeHTML(false)
+	return encoder.Encode(c.effective())
+}
+
+// effective returns the printable form of the settings.
+func (c *Config) effective() effectiveConfig {
+	token, source := "", c.TokenSource
+	if c.GitLabToken != "" {
+		token = redacted
+	} else {
+		// The sources ResolveToken would try, in its order.
+		var sources []string
+		if c.TokenEnv != "" {
+			sources = append(sources, "env "+c.TokenEnv)
+		}
+		if c.TokenCommand != "" {
+			sources = append(sources, "command "+c.TokenCommand)
+		}
+		source = strings.Join(sources, ", then ")
+	}
+	if source == "" {
+		source = "none"
+	}
+	return effectiveConfig{
+		Profile:           c.Profile,
+		Files:             c.Files,
+		GitLabURL:         c.GitLabURL,
+		GitLabAPIVersion:  c.GitLabAPIVersion,
+		Token:             token,
+		TokenSource:       source,
+		TokenEnv:          c.TokenEnv,
+		TokenCommand:      c.TokenCommand,
+		Namespace:         c.Namespace,
+		Excludes:          c.Excludes,
+		Transport:         c.Transport,
+		GitBackend:        c.GitBackend,
+		DeletionTimeout:   c.DeletionTimeout.String(),
+		PermanentlyRemove: c.PermanentlyRemove,
+		HTTPTimeout:       c.HTTPTimeout.String(),
+		HTTPRetries:       c.HTTPRetries,
+	}
+}
@@ -0,0 +1,50 @@
+package config
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestShow(t *testing.T) {
+	marker := filepath.Join(t.TempDir(), "marker")
+	t.Setenv("WORK_TOKEN", "secret-from-env")
+	tests := []struct {
+		name       string
+		cfg        *Config
+		wantSource string
+	}{
+		{"token", &Config{GitLabToken: "secret-token", TokenSource: "profile work"}, "profile work"},
+		{"token_env", &Config{TokenEnv: "WORK_TOKEN"}, "env WORK_TOKEN"},
+		{"token_command", &Config{TokenCommand: "touch " + marker}, "command touch " + marker},
+		{"both", &Config{TokenEnv: "WORK_TOKEN", TokenCommand: "touch " + marker}, "env WORK_TOKEN, then command touch " + marker},
+		{"none", &Config{}, "none"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for format, show := range map[string]func(*Config, *bytes.Buffer) error{
+				"yaml": func(c *Config, w *bytes.Buffer) error { return c.Show(w) },
+				"json": func(c *Config, w *bytes.Buffer) error { return c.ShowJSON(w) },
+			} {
+				var output bytes.Buffer
+				if err := show(tt.cfg, &output); err != nil {
+					t.Fatalf("%s: %v", format, err)
+				}
+				if strings.Contains(output.String(), "secret") {
+					t.Errorf("%s output shows the token:\n%s", format, output.String())
+				}
+				if tt.cfg.GitLabToken != "" && !strings.Contains(output.String(), redacted) {
+					t.Errorf("%s output does not show that the token is set:\n%s", format, output.String())
+				}
+				if effective := tt.cfg.effective(); effective.TokenSource != tt.wantSource {
+					t.Errorf("token source = %q, want %q", effective.TokenSource, tt.wantSource)
+				}
+			}
+			if _, err := os.Stat(marker); err == nil {
+				t.Error("Show has run the token command")
+			}
+		})
+	}
+}